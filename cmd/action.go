@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/api"
+	"github.com/bananaops/cloudoff/internal/scheduler"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serverURL string
+	apiToken  string
+	hours     int
+	account   string
+	region    string
+)
+
+// newActionCommand returns a command sending a manual action to the cloudoff server.
+func newActionCommand(action, short string) *cobra.Command {
+	command := &cobra.Command{
+		Use:   action + " <kind> <id>",
+		Short: short,
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// The client waits as long as the server runs the action
+			client := api.NewClient(serverURL, apiToken)
+			status, err := client.Apply(context.Background(), api.ActionRequest{Kind: args[0], ID: args[1], Account: account, Region: region, Action: action, Hours: hours})
+			if err != nil {
+				return err
+			}
+
+			if action == scheduler.ActionExtend && status.TTLExpiresAt != nil {
+				fmt.Printf("%s %s: ttl expires at %s\n", status.Kind, status.ID, status.TTLExpiresAt.Format(time.RFC3339))
+			} else if status.Override != nil {
+				fmt.Printf("%s %s: %s until %s\n", status.Kind, status.ID, status.Override.State, status.Override.Until.Format(time.RFC3339))
+			} else {
+				fmt.Printf("%s %s: schedule applies\n", status.Kind, status.ID)
+			}
+			return nil
+		},
+	}
+
+	command.Flags().StringVar(&serverURL, "server", envOrDefault("CLOUDOFF_SERVER", "http://localhost:8080"), "cloudoff server URL (env CLOUDOFF_SERVER)")
+	command.Flags().StringVar(&apiToken, "token", os.Getenv("CLOUDOFF_API_TOKEN"), "API token of the cloudoff server (env CLOUDOFF_API_TOKEN)")
+	command.Flags().StringVar(&account, "account", "", "account of the resource, when resources of several accounts share its ID")
+	command.Flags().StringVar(&region, "region", "", "region of the resource, when resources of several regions share its ID")
+	switch action {
+	case scheduler.ActionSkip, scheduler.ActionResume:
+	case scheduler.ActionExtend:
+		command.Flags().IntVar(&hours, "hours", 0, "hours added to the ttl of the resource")
+	default:
+		command.Flags().IntVar(&hours, "hours", 0, "hours during which the schedule is overridden, until the next transition by default")
+	}
+	return command
+}
+
+func envOrDefault(key, value string) string {
+	if env := os.Getenv(key); env != "" {
+		return env
+	}
+	return value
+}
+
+func init() {
+	rootCmd.AddCommand(
+		newActionCommand(scheduler.ActionStart, "Start a resource now and keep it running until its next transition"),
+		newActionCommand(scheduler.ActionStop, "Stop a resource now and keep it stopped until its next transition"),
+		newActionCommand(scheduler.ActionSkip, "Skip the next scheduled transition of a resource"),
+		newActionCommand(scheduler.ActionSnooze, "Snooze the downtime of a resource for the given hours"),
+		newActionCommand(scheduler.ActionResume, "Remove the manual override of a resource"),
+		newActionCommand(scheduler.ActionExtend, "Postpone the deletion of a resource by its ttl for the given hours"),
+	)
+}
@@ -9,11 +9,25 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/bananaops/cloudoff/internal/api"
+	"github.com/bananaops/cloudoff/internal/audit"
+	ec2 "github.com/bananaops/cloudoff/internal/aws"
+	"github.com/bananaops/cloudoff/internal/azure"
 	"github.com/bananaops/cloudoff/internal/clean"
+	"github.com/bananaops/cloudoff/internal/digest"
+	"github.com/bananaops/cloudoff/internal/gcp"
+	"github.com/bananaops/cloudoff/internal/health"
+	"github.com/bananaops/cloudoff/internal/k8s"
+	"github.com/bananaops/cloudoff/internal/leader"
+	"github.com/bananaops/cloudoff/internal/notify"
+	"github.com/bananaops/cloudoff/internal/resource"
 	"github.com/bananaops/cloudoff/internal/scheduler"
+	"github.com/bananaops/cloudoff/internal/state"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/robfig/cron/v3"
 
@@ -37,6 +51,113 @@ var serv = &cobra.Command{
 		// Add a handler for the /metrics endpoint
 		muxMetrics.Handle("/metrics", promhttp.Handler())
 
+		// Open the store remembering the actions, original capacities and overrides of the
+		// resources, STATE_STORE is "bolt" (default), "dynamodb" or "memory"
+		store, err := state.NewStore(context.Background(), envOrDefault("STATE_STORE", "bolt"), stateLocation(), os.Getenv("DYNAMODB_ENDPOINT"))
+		if err != nil {
+			log.Fatalf("Error opening state store : %v", err)
+		}
+		resource.SetStore(store)
+
+		// Write the audit events of the actions to AUDIT_SINK: "stdout" (default), "file"
+		// or "s3"
+		sink, err := audit.NewSink(context.Background(), envOrDefault("AUDIT_SINK", "stdout"), auditLocation(), envOrDefault("AUDIT_PREFIX", "cloudoff/"), os.Getenv("S3_ENDPOINT"))
+		if err != nil {
+			log.Fatalf("Error opening audit sink : %v", err)
+		}
+
+		// Notify the actions and the resources about to expire to Slack, Teams or webhooks
+		// configured in NOTIFY_CONFIG
+		var notifier *notify.Notifier
+		if path := os.Getenv("NOTIFY_CONFIG"); path != "" {
+			config, err := notify.LoadConfig(path)
+			if err != nil {
+				log.Fatalf("Error loading notification config : %v", err)
+			}
+			notifier, err = notify.NewNotifier(config, &http.Client{Timeout: 30 * time.Second})
+			if err != nil {
+				log.Fatalf("Error creating notifier : %v", err)
+			}
+			sink = audit.MultiSink{sink, notifier}
+		}
+
+		// Apply the CloudoffSchedule objects of the cluster to the resources matching their
+		// selector, and record the actions on these resources as events of the schedules
+		var schedules *k8s.ScheduleController
+		if os.Getenv("KUBERNETES_SCHEDULES") == "true" {
+			dynamicClient, err := k8s.NewDynamicClient()
+			if err != nil {
+				log.Fatalf("Error creating kubernetes client : %v", err)
+			}
+			client, err := k8s.NewClient()
+			if err != nil {
+				log.Fatalf("Error creating kubernetes client : %v", err)
+			}
+			schedules = k8s.NewScheduleController(dynamicClient, client)
+			if err := schedules.Load(context.Background()); err != nil {
+				log.Fatalf("Error loading cloudoff schedules : %v", err)
+			}
+			resource.AddTagSource(schedules)
+			sink = audit.MultiSink{sink, schedules}
+		}
+		audit.SetSink(sink)
+
+		// Elect the replica running the tasks, the others only serve the API and metrics
+		elector := newElector()
+
+		// Schedule and clean the resources in a single loop sharing one discovery, which
+		// sleeps until the next transition or RESYNC_INTERVAL (5m by default). The
+		// followers only refresh the resources listed by their API.
+		resync, err := time.ParseDuration(envOrDefault("RESYNC_INTERVAL", "5m"))
+		if err != nil {
+			log.Fatalf("Invalid RESYNC_INTERVAL : %v", err)
+		}
+		loop := scheduler.NewLoop(resync, elector.IsLeader)
+
+		// On SIGTERM the cycles and tasks in progress are cancelled after SHUTDOWN_TIMEOUT
+		// (25s by default, below the 30s grace period of Kubernetes)
+		shutdownTimeout, err := time.ParseDuration(envOrDefault("SHUTDOWN_TIMEOUT", "25s"))
+		if err != nil {
+			log.Fatalf("Invalid SHUTDOWN_TIMEOUT : %v", err)
+		}
+		elector.OnLeading(loop.Wake)
+
+		electionCtx, stopElection := context.WithCancel(context.Background())
+		go elector.Run(electionCtx)
+
+		// workCtx cancels the cycles and tasks in progress when the shutdown timeout is exceeded
+		workCtx, cancelWork := context.WithCancel(context.Background())
+		defer cancelWork()
+
+		// leaderOnly skips a task on the replicas which are not the leader
+		leaderOnly := func(task func(ctx context.Context)) func() {
+			return func() {
+				if elector.IsLeader() {
+					task(workCtx)
+				}
+			}
+		}
+
+		// Add the health and readiness probes, readiness fails after
+		// READINESS_FAILURE_THRESHOLD consecutive failed cycles (3 by default)
+		threshold := 3
+		if value := os.Getenv("READINESS_FAILURE_THRESHOLD"); value != "" {
+			threshold, err = strconv.Atoi(value)
+			if err != nil {
+				log.Fatalf("Invalid READINESS_FAILURE_THRESHOLD : %v", err)
+			}
+		}
+		checker := health.NewChecker(threshold, max(10*time.Minute, 2*resync), func(ctx context.Context) error {
+			_, err := ec2.CheckCredentials(ctx)
+			return err
+		})
+		muxMetrics.Handle("/healthz", checker.Handler())
+		muxMetrics.Handle("/readyz", checker.Handler())
+
+		// Add the management API listing the resources and their schedule state, manual
+		// actions are enabled when API_TOKEN is set
+		muxMetrics.Handle("/api/", api.NewServer(os.Getenv("API_TOKEN"), elector.IsLeader).Handler())
+
 		metricsServer := &http.Server{
 			Addr:              "0.0.0.0:8080",
 			ReadHeaderTimeout: 2 * time.Second, // Fix CWE-400 Potential Slowloris Attack because ReadHeaderTimeout is not configured in the http.Server
@@ -44,22 +165,134 @@ var serv = &cobra.Command{
 			ErrorLog:          httplogger,
 		}
 
-		c := cron.New()
+		// The tasks outside of the reconcile loop keep their own discovery: NAT gateways and
+		// Kubernetes workloads are not resource providers yet, and the hourly cleanups,
+		// notifications and digests don't follow the schedules. A run still in progress
+		// skips the next one, so a task never overlaps itself.
+		c := cron.New(cron.WithChain(cron.SkipIfStillRunning(cronLogger{})))
 
-		// Add task schedule EC2
-		_, err := c.AddFunc("* * * * *", scheduler.ScheduleEC2Instance)
-		if err != nil {
-			log.Fatalf("Error adding scheduled task : %v", err)
+		// Register the providers of the resources managed by the scheduler and the cleaner
+		for _, provider := range ec2.Providers() {
+			resource.Register(provider)
 		}
 
-		// Add task clean EC2
-		_, err = c.AddFunc("* * * * *", clean.CleanEC2Instance)
-		if err != nil {
-			log.Fatalf("Error adding clean task : %v", err)
+		// Register a Compute Engine provider for each Google Cloud project
+		if projects := os.Getenv("GCP_PROJECTS"); projects != "" {
+			for _, project := range strings.Split(projects, ",") {
+				provider, err := gcp.NewComputeProvider(context.Background(), strings.TrimSpace(project))
+				if err != nil {
+					log.Fatalf("Error creating compute engine provider : %v", err)
+				}
+				resource.Register(provider)
+			}
+		}
+
+		// Register the virtual machine and scale set providers of each Azure subscription
+		if subscriptions := os.Getenv("AZURE_SUBSCRIPTIONS"); subscriptions != "" {
+			for _, subscription := range strings.Split(subscriptions, ",") {
+				client, err := azure.NewClient(strings.TrimSpace(subscription))
+				if err != nil {
+					log.Fatalf("Error creating azure client : %v", err)
+				}
+				for _, provider := range client.Providers() {
+					resource.Register(provider)
+				}
+			}
+		}
+
+		// Add task schedule NAT gateways, opt-in as they are deleted and recreated
+		if os.Getenv("NAT_GATEWAY_SCHEDULER") == "true" {
+			_, err = c.AddFunc("* * * * *", leaderOnly(scheduler.ScheduleNatGateway))
+			if err != nil {
+				log.Fatalf("Error adding scheduled task : %v", err)
+			}
+		}
+
+		// Add task reload the cloudoff schedules, the leader reports their matched resources
+		if schedules != nil {
+			_, err = c.AddFunc("* * * * *", func() {
+				if err := schedules.Load(workCtx); err != nil {
+					slog.Error("error loading cloudoff schedules", "error", err)
+					return
+				}
+				if !elector.IsLeader() {
+					return
+				}
+				if err := schedules.UpdateStatus(workCtx); err != nil {
+					slog.Error("error updating cloudoff schedules status", "error", err)
+				}
+			})
+			if err != nil {
+				log.Fatalf("Error adding scheduled task : %v", err)
+			}
+		}
+
+		// Add tasks clean EBS volumes, snapshots and Elastic IPs, every hour as
+		// ttl and orphan age are counted in hours at least
+		for _, task := range []func(context.Context){clean.CleanVolumes, clean.CleanSnapshots, clean.CleanAddresses} {
+			_, err = c.AddFunc("0 * * * *", leaderOnly(task))
+			if err != nil {
+				log.Fatalf("Error adding clean task : %v", err)
+			}
+		}
+
+		// Add task notify the resources whose ttl expires soon
+		if notifier != nil {
+			_, err = c.AddFunc("0 * * * *", leaderOnly(func(ctx context.Context) {
+				notifier.WarnExpiring(ctx, time.Now())
+			}))
+			if err != nil {
+				log.Fatalf("Error adding notification task : %v", err)
+			}
+		}
+
+		// Add task email a daily digest to the owner of each instance which needs attention
+		if host := os.Getenv("SMTP_HOST"); host != "" {
+			mailer := digest.NewMailer(host, envOrDefault("SMTP_PORT", "25"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), envOrDefault("DIGEST_FROM", "cloudoff@localhost"))
+			options := digest.Options{
+				ExpiringWithin:   envDuration("DIGEST_EXPIRING_WITHIN", "2d"),
+				StoppedFor:       envDuration("DIGEST_STOPPED_FOR", "2w"),
+				EmailDomain:      os.Getenv("DIGEST_EMAIL_DOMAIN"),
+				DefaultRecipient: os.Getenv("DIGEST_DEFAULT_RECIPIENT"),
+			}
+			_, err = c.AddFunc(envOrDefault("DIGEST_SCHEDULE", "0 8 * * *"), leaderOnly(func(ctx context.Context) {
+				digest.SendDigests(ctx, mailer, options)
+			}))
+			if err != nil {
+				log.Fatalf("Error adding digest task : %v", err)
+			}
 		}
 
-		// start the cron scheduler
+		// Add tasks schedule and clean Kubernetes workloads
+		if os.Getenv("KUBERNETES_SCHEDULER") == "true" {
+			client, err := k8s.NewClient()
+			if err != nil {
+				log.Fatalf("Error creating kubernetes client : %v", err)
+			}
+			k8sScheduler := k8s.NewScheduler(client, os.Getenv("DRYRUN") == "true")
+
+			_, err = c.AddFunc("* * * * *", leaderOnly(func(ctx context.Context) {
+				if err := k8sScheduler.Schedule(ctx, time.Now()); err != nil {
+					slog.Error("error scheduling kubernetes workloads", "error", err)
+				}
+			}))
+			if err != nil {
+				log.Fatalf("Error adding scheduled task : %v", err)
+			}
+
+			_, err = c.AddFunc("* * * * *", leaderOnly(func(ctx context.Context) {
+				if err := k8sScheduler.Clean(ctx); err != nil {
+					slog.Error("error cleaning kubernetes namespaces", "error", err)
+				}
+			}))
+			if err != nil {
+				log.Fatalf("Error adding clean task : %v", err)
+			}
+		}
+
+		// start the cron scheduler and the reconcile loop
 		c.Start()
+		go loop.Run(workCtx)
 		log.Println("task planner started")
 
 		go func() {
@@ -78,16 +311,140 @@ var serv = &cobra.Command{
 
 		slog.Info("shutting down application...")
 
-		// Gracefully stop Metrics server
-		if err := metricsServer.Shutdown(context.Background()); err != nil {
-			log.Fatal(fmt.Printf("failed to shutdown metrics server: %v\n", err))
+		// Stop starting cycles and tasks, and let the ones in progress complete until
+		// SHUTDOWN_TIMEOUT
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancelShutdown()
+		loopStopped := loop.Stop()
+		cronStopped := c.Stop()
+
+		// Gracefully stop Metrics server, the manual actions in progress complete
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("failed to shutdown metrics server", "error", err)
 		}
 
+		if !waitDone(shutdownCtx, loopStopped, cronStopped) {
+			// The cancelled actions fail as soon as their requests are aborted
+			for _, action := range resource.ActionsInProgress() {
+				slog.Warn("action interrupted by shutdown", "kind", action.Kind, "resource", action.ID, "region", action.Region, "action", action.Action, "started", action.Started)
+			}
+			cancelWork()
+
+			abortCtx, cancelAbort := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancelAbort()
+			waitDone(abortCtx, loopStopped, cronStopped)
+		}
+		// The instances in transition are polled until SHUTDOWN_TIMEOUT, the transitions
+		// left in progress are failed after their timeout by the next leader
+		ec2.DrainWaiters(shutdownCtx)
+
+		for _, action := range resource.ActionsInProgress() {
+			slog.Error("action incomplete at exit", "kind", action.Kind, "resource", action.ID, "region", action.Region, "action", action.Action, "started", action.Started)
+		}
+
+		// Release the leadership once the tasks are stopped so another replica takes over
+		// without waiting for the lease to expire
+		stopElection()
+
+		// Close the state store and the audit sink last so the actions of the stopped tasks
+		// are recorded and the pending audit events are written
+		resource.ResetStore()
+		audit.ResetSink()
+
 		slog.Info("application stopped")
 
 	},
 }
 
+// cronLogger logs the messages of the cron scheduler, such as the skipped runs, with slog.
+type cronLogger struct{}
+
+func (cronLogger) Info(msg string, keysAndValues ...interface{}) {
+	slog.Info("cron: "+msg, keysAndValues...)
+}
+
+func (cronLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	slog.Error("cron: "+msg, append(keysAndValues, "error", err)...)
+}
+
+// waitDone waits for every context to be done, and returns false when ctx is done first.
+func waitDone(ctx context.Context, contexts ...context.Context) bool {
+	for _, c := range contexts {
+		select {
+		case <-c.Done():
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// newElector returns the leader elector configured by LEADER_ELECTION: "kubernetes"
+// uses a Lease, "file" a lock on LEADER_ELECTION_LOCK_FILE. Without election this
+// replica is always the leader.
+func newElector() *leader.Elector {
+	identity, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("Error reading hostname : %v", err)
+	}
+
+	switch os.Getenv("LEADER_ELECTION") {
+	case "":
+		return leader.NewElector(nil)
+	case "kubernetes":
+		client, err := k8s.NewClient()
+		if err != nil {
+			log.Fatalf("Error creating kubernetes client : %v", err)
+		}
+		return leader.NewElector(leader.NewKubernetesLock(client, podNamespace(), "cloudoff-leader", identity))
+	case "file":
+		return leader.NewElector(leader.NewFileLock(envOrDefault("LEADER_ELECTION_LOCK_FILE", "/tmp/cloudoff.lock")))
+	default:
+		log.Fatalf("Invalid LEADER_ELECTION : %s", os.Getenv("LEADER_ELECTION"))
+		return nil
+	}
+}
+
+// stateLocation returns the path of the BoltDB file from STATE_FILE, or the name of the
+// DynamoDB table from STATE_TABLE.
+func stateLocation() string {
+	if os.Getenv("STATE_STORE") == "dynamodb" {
+		return envOrDefault("STATE_TABLE", "cloudoff-state")
+	}
+	return envOrDefault("STATE_FILE", "/var/lib/cloudoff/state.db")
+}
+
+// auditLocation returns the path of the audit file from AUDIT_FILE, or the bucket from
+// AUDIT_BUCKET.
+func auditLocation() string {
+	if os.Getenv("AUDIT_SINK") == "s3" {
+		return os.Getenv("AUDIT_BUCKET")
+	}
+	return envOrDefault("AUDIT_FILE", "/var/log/cloudoff/audit.jsonl")
+}
+
+// envDuration returns the duration of an environment variable in the format of the
+// cloudoff:ttl tag (ex. : "2d"), or the default value when it is not set.
+func envDuration(key, defaultValue string) time.Duration {
+	duration, err := clean.ParseDuration(envOrDefault(key, defaultValue))
+	if err != nil {
+		log.Fatalf("Invalid %s : %v", key, err)
+	}
+	return duration
+}
+
+// podNamespace returns the namespace of the pod from POD_NAMESPACE or its service account.
+func podNamespace() string {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	namespace, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		return "default"
+	}
+	return strings.TrimSpace(string(namespace))
+}
+
 func init() {
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	slog.SetDefault(logger)
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/resource"
+	"github.com/bananaops/cloudoff/internal/scheduler"
+)
+
+// ActionTimeout bounds a manual action, which outlives the request so a client
+// disconnecting doesn't interrupt a stop or a start halfway. The clients wait as long.
+const ActionTimeout = 5 * time.Minute
+
+// ActionRequest is the body of a manual action. IDs can contain slashes (Azure resource
+// IDs, node groups...) so the resource is identified in the body rather than the path.
+type ActionRequest struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+	// Account and Region are only required when resources of several accounts or
+	// regions share the ID
+	Account string `json:"account,omitempty"`
+	Region  string `json:"region,omitempty"`
+	Action  string `json:"action"`
+	// Hours is the duration of the override, required to snooze downtime
+	Hours int `json:"hours,omitempty"`
+}
+
+// ActionResponse returns the resource after the action.
+type ActionResponse struct {
+	Resource ResourceStatus `json:"resource"`
+}
+
+// authenticated rejects the requests without the bearer token of the server.
+func (s *Server) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" {
+			writeError(w, http.StatusForbidden, "manual actions are disabled, set API_TOKEN to enable them")
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// applyAction performs a manual action on a resource of the last discovery.
+func (s *Server) applyAction(w http.ResponseWriter, r *http.Request) {
+	if !s.isLeader() {
+		writeError(w, http.StatusServiceUnavailable, "this replica is not the leader, retry on the leader")
+		return
+	}
+
+	var request ActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	provider, res, err := resource.Find(request.Kind, request.Account, request.Region, request.ID)
+	if errors.Is(err, resource.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), ActionTimeout)
+	defer cancel()
+
+	now := s.now()
+	_, err = scheduler.ApplyManualAction(ctx, provider, res, request.Action, request.Hours, now)
+	if err != nil {
+		logger.Error("error applying manual action", "kind", res.Kind, "resource", res.ID, "action", request.Action, "error", err)
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	// The resource is described with the state set by the action
+	if _, updated, err := resource.Find(res.Kind, res.Account, res.Region, res.ID); err == nil {
+		res = updated
+	}
+	writeJSON(w, http.StatusOK, ActionResponse{Resource: Describe(ctx, res, now)})
+}
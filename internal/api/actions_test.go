@@ -0,0 +1,276 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/resource"
+	"github.com/bananaops/cloudoff/internal/scheduler"
+)
+
+func TestApplyAction(t *testing.T) {
+	resource.ResetInventory()
+	resource.ResetStore()
+	defer resource.ResetInventory()
+	defer resource.ResetStore()
+
+	// Monday 06/01/2025 21:00 UTC, during downtime
+	now := time.Date(2025, 1, 6, 21, 0, 0, 0, time.UTC)
+	downtime := []resource.Tag{{Key: "cloudoff:downtime", Value: "Mon-Fri_20:00-23:59"}}
+
+	provider := &fakeProvider{}
+	resource.UpdateInventory(provider, []resource.Resource{
+		{ID: "i-web", Kind: "ec2-instance", State: resource.StateStopped, Tags: downtime},
+		{ID: "i-batch", Kind: "ec2-instance", State: resource.StateStopped, Tags: downtime},
+		{ID: "i-api", Kind: "ec2-instance", State: resource.StateStopped, Tags: downtime},
+	})
+
+	server := NewServer("secret", leader)
+	server.now = func() time.Time { return now }
+	httpServer := httptest.NewServer(server.Handler())
+	defer httpServer.Close()
+
+	tests := []struct {
+		name          string
+		token         string
+		request       ActionRequest
+		expectedErr   string
+		expectedState resource.State
+		expectedUntil time.Time
+		// expectedCurrent is the state of the resource after the action
+		expectedCurrent resource.State
+	}{
+		{
+			name:        "Invalid token",
+			token:       "invalid",
+			request:     ActionRequest{Kind: "ec2-instance", ID: "i-web", Action: scheduler.ActionStart},
+			expectedErr: "401",
+		},
+		{
+			name:        "Unknown resource",
+			token:       "secret",
+			request:     ActionRequest{Kind: "ec2-instance", ID: "i-missing", Action: scheduler.ActionStart},
+			expectedErr: "404",
+		},
+		{
+			name:            "Start until the end of downtime",
+			token:           "secret",
+			request:         ActionRequest{Kind: "ec2-instance", ID: "i-web", Action: scheduler.ActionStart},
+			expectedState:   resource.StateRunning,
+			expectedUntil:   time.Date(2025, 1, 7, 0, 0, 0, 0, time.UTC),
+			expectedCurrent: resource.StateRunning,
+		},
+		{
+			name:            "Snooze downtime",
+			token:           "secret",
+			request:         ActionRequest{Kind: "ec2-instance", ID: "i-batch", Action: scheduler.ActionSnooze, Hours: 2},
+			expectedState:   resource.StateRunning,
+			expectedUntil:   now.Add(2 * time.Hour),
+			expectedCurrent: resource.StateRunning,
+		},
+		{
+			name:        "Snooze without hours",
+			token:       "secret",
+			request:     ActionRequest{Kind: "ec2-instance", ID: "i-batch", Action: scheduler.ActionSnooze},
+			expectedErr: "hours is required",
+		},
+		{
+			name:            "Skip the end of downtime",
+			token:           "secret",
+			request:         ActionRequest{Kind: "ec2-instance", ID: "i-api", Action: scheduler.ActionSkip},
+			expectedState:   resource.StateStopped,
+			expectedUntil:   time.Date(2025, 1, 7, 20, 0, 0, 0, time.UTC),
+			expectedCurrent: resource.StateStopped,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(httpServer.URL, tt.token)
+			status, err := client.Apply(context.Background(), tt.request)
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if status.Override == nil || status.Override.State != tt.expectedState || !status.Override.Until.Equal(tt.expectedUntil) {
+				t.Errorf("unexpected override %+v", status.Override)
+			}
+			if status.DesiredState != tt.expectedState {
+				t.Errorf("expected desired state %v, got %v", tt.expectedState, status.DesiredState)
+			}
+			if status.State != tt.expectedCurrent {
+				t.Errorf("expected state %v, got %v", tt.expectedCurrent, status.State)
+			}
+		})
+	}
+
+	if strings.Join(provider.started, ",") != "i-web,i-batch" || len(provider.stopped) != 0 {
+		t.Errorf("unexpected actions: started %v, stopped %v", provider.started, provider.stopped)
+	}
+
+	// Resume removes the override
+	_, err := NewClient(httpServer.URL, "secret").Apply(context.Background(), ActionRequest{Kind: "ec2-instance", ID: "i-web", Action: scheduler.ActionResume})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := resource.ActiveOverride(context.Background(), resource.Resource{ID: "i-web", Kind: "ec2-instance"}, now); ok {
+		t.Errorf("expected the override to be removed")
+	}
+}
+
+func TestActionsDisabled(t *testing.T) {
+	httpServer := httptest.NewServer(NewServer("", leader).Handler())
+	defer httpServer.Close()
+
+	_, err := NewClient(httpServer.URL, "").Apply(context.Background(), ActionRequest{Kind: "ec2-instance", ID: "i-web", Action: scheduler.ActionStart})
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected actions to be disabled, got %v", err)
+	}
+}
+
+func TestActionsOnFollower(t *testing.T) {
+	httpServer := httptest.NewServer(NewServer("secret", func() bool { return false }).Handler())
+	defer httpServer.Close()
+
+	_, err := NewClient(httpServer.URL, "secret").Apply(context.Background(), ActionRequest{Kind: "ec2-instance", ID: "i-web", Action: scheduler.ActionStart})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected actions to be rejected by a follower, got %v", err)
+	}
+}
+
+// blockingProvider starts its resources once released, and records whether the context
+// of the action was cancelled in the meantime.
+type blockingProvider struct {
+	fakeProvider
+	entered chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func (p *blockingProvider) Start(ctx context.Context, r resource.Resource) error {
+	close(p.entered)
+	<-p.release
+	p.err = ctx.Err()
+	return p.err
+}
+
+func TestApplyActionClientDisconnect(t *testing.T) {
+	resource.ResetInventory()
+	resource.ResetStore()
+	defer resource.ResetInventory()
+	defer resource.ResetStore()
+
+	provider := &blockingProvider{entered: make(chan struct{}), release: make(chan struct{})}
+	resource.UpdateInventory(provider, []resource.Resource{{ID: "i-web", Kind: "ec2-instance", State: resource.StateStopped}})
+
+	httpServer := httptest.NewServer(NewServer("secret", leader).Handler())
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewClient(httpServer.URL, "secret").Apply(ctx, ActionRequest{Kind: "ec2-instance", ID: "i-web", Action: scheduler.ActionStart, Hours: 1})
+	}()
+
+	<-provider.entered
+	cancel()
+	<-done
+	close(provider.release)
+
+	// The handler completes the action after the client is gone
+	httpServer.Close()
+	if provider.err != nil {
+		t.Errorf("expected the action to outlive the request, got %v", provider.err)
+	}
+	if _, r, _ := resource.Find("ec2-instance", "", "", "i-web"); r.State != resource.StateRunning {
+		t.Errorf("expected the instance to be running, got %v", r.State)
+	}
+}
+
+func TestApplyActionSharedID(t *testing.T) {
+	resource.ResetInventory()
+	resource.ResetStore()
+	defer resource.ResetInventory()
+	defer resource.ResetStore()
+
+	provider := &fakeProvider{}
+	resource.UpdateInventory(provider, []resource.Resource{
+		{ID: "web", Kind: "autoscaling-group", Account: "111111111111", Region: "eu-west-1", State: resource.StateStopped},
+		{ID: "web", Kind: "autoscaling-group", Account: "222222222222", Region: "eu-west-1", State: resource.StateStopped},
+	})
+
+	httpServer := httptest.NewServer(NewServer("secret", leader).Handler())
+	defer httpServer.Close()
+	client := NewClient(httpServer.URL, "secret")
+
+	_, err := client.Apply(context.Background(), ActionRequest{Kind: "autoscaling-group", ID: "web", Action: scheduler.ActionStart, Hours: 1})
+	if err == nil || !strings.Contains(err.Error(), "409") {
+		t.Fatalf("expected the action to be rejected as ambiguous, got %v", err)
+	}
+
+	status, err := client.Apply(context.Background(), ActionRequest{Kind: "autoscaling-group", ID: "web", Account: "222222222222", Action: scheduler.ActionStart, Hours: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.Account != "222222222222" || status.State != resource.StateRunning {
+		t.Errorf("unexpected resource %+v", status)
+	}
+}
+
+func TestApplyActionExtend(t *testing.T) {
+	resource.ResetInventory()
+	resource.ResetStore()
+	defer resource.ResetInventory()
+	defer resource.ResetStore()
+
+	now := time.Date(2025, 1, 6, 21, 0, 0, 0, time.UTC)
+	ttl := []resource.Tag{{Key: "cloudoff:ttl", Value: "2d"}}
+	resource.UpdateInventory(&fakeProvider{}, []resource.Resource{
+		{ID: "i-dev", Kind: "ec2-instance", State: resource.StateRunning, Tags: ttl, TTLStart: now.Add(-47 * time.Hour)},
+		{ID: "i-prod", Kind: "ec2-instance", State: resource.StateRunning},
+	})
+
+	server := NewServer("secret", leader)
+	server.now = func() time.Time { return now }
+	httpServer := httptest.NewServer(server.Handler())
+	defer httpServer.Close()
+	client := NewClient(httpServer.URL, "secret")
+
+	// The ttl is extended from its expiration, one hour from now
+	status, err := client.Apply(context.Background(), ActionRequest{Kind: "ec2-instance", ID: "i-dev", Action: scheduler.ActionExtend, Hours: 24})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := now.Add(25 * time.Hour)
+	if status.TTLExpiresAt == nil || !status.TTLExpiresAt.Equal(expected) || status.TTLExtension == nil {
+		t.Errorf("expected the ttl to expire at %v, got %v (extension %+v)", expected, status.TTLExpiresAt, status.TTLExtension)
+	}
+	if status.Override != nil {
+		t.Errorf("expected the schedule untouched, got %+v", status.Override)
+	}
+
+	tests := []struct {
+		name        string
+		request     ActionRequest
+		expectedErr string
+	}{
+		{"Without hours", ActionRequest{Kind: "ec2-instance", ID: "i-dev", Action: scheduler.ActionExtend}, "hours is required"},
+		{"Without ttl", ActionRequest{Kind: "ec2-instance", ID: "i-prod", Action: scheduler.ActionExtend, Hours: 24}, "no cloudoff:ttl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.Apply(context.Background(), tt.request); err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("expected error containing %q, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
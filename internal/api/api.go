@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/clean"
+	"github.com/bananaops/cloudoff/internal/resource"
+	"github.com/bananaops/cloudoff/internal/scheduler"
+)
+
+var logger *slog.Logger
+
+// Server exposes the resources managed by cloudoff over HTTP.
+type Server struct {
+	// token authenticates the manual actions, which are disabled when it is empty
+	token string
+	// isLeader reports whether this replica performs the actions, followers only serve
+	// the read-only endpoints
+	isLeader func() bool
+	now      func() time.Time
+}
+
+func NewServer(token string, isLeader func() bool) *Server {
+	return &Server{token: token, isLeader: isLeader, now: time.Now}
+}
+
+// Handler returns the routes of the management API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/resources", s.listResources)
+	mux.HandleFunc("GET /api/v1/history", s.listHistory)
+	mux.HandleFunc("GET /api/v1/transitions", s.listTransitions)
+	mux.HandleFunc("POST /api/v1/actions", s.authenticated(s.applyAction))
+	return mux
+}
+
+// ResourceStatus is the representation of a resource returned by the API.
+type ResourceStatus struct {
+	ID                  string                 `json:"id"`
+	Kind                string                 `json:"kind"`
+	Region              string                 `json:"region"`
+	Account             string                 `json:"account"`
+	Tags                map[string]string      `json:"tags"`
+	Uptime              []scheduler.Schedule   `json:"uptime,omitempty"`
+	Downtime            []scheduler.Schedule   `json:"downtime,omitempty"`
+	ScheduleError       string                 `json:"scheduleError,omitempty"`
+	Warning             string                 `json:"warning,omitempty"`
+	State               resource.State         `json:"state"`
+	DesiredState        resource.State         `json:"desiredState,omitempty"`
+	NextTransition      *time.Time             `json:"nextTransition,omitempty"`
+	TTL                 string                 `json:"ttl,omitempty"`
+	TTLExpiresAt        *time.Time             `json:"ttlExpiresAt,omitempty"`
+	TTLRemainingSeconds *int64                 `json:"ttlRemainingSeconds,omitempty"`
+	TTLExtension        *resource.TTLExtension `json:"ttlExtension,omitempty"`
+	LastAction          *resource.Action       `json:"lastAction,omitempty"`
+	Override            *resource.Override     `json:"override,omitempty"`
+	Transition          *resource.Transition   `json:"transition,omitempty"`
+}
+
+type listResponse struct {
+	Resources []ResourceStatus `json:"resources"`
+}
+
+// listResources returns the last discovered resources. They can be filtered with the
+// kind, region and account parameters, and the tag parameter ("key" or "key=value")
+// which can be repeated.
+func (s *Server) listResources(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	now := s.now()
+
+	response := listResponse{Resources: []ResourceStatus{}}
+	for _, res := range resource.Inventory() {
+		if !matches(res, query.Get("kind"), query.Get("region"), query.Get("account"), query["tag"]) {
+			continue
+		}
+		response.Resources = append(response.Resources, Describe(r.Context(), res, now))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+type historyResponse struct {
+	Actions []resource.Action `json:"actions"`
+}
+
+// listHistory returns the last actions performed on the resource identified by the kind,
+// account, region and id parameters, most recent first. The account and the region of a
+// discovered resource can be omitted, the history of deleted resources is kept.
+func (s *Server) listHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	res := resource.Resource{Kind: query.Get("kind"), Account: query.Get("account"), Region: query.Get("region"), ID: query.Get("id")}
+	if res.Kind == "" || res.ID == "" {
+		writeError(w, http.StatusBadRequest, "kind and id are required")
+		return
+	}
+	if _, found, err := resource.Find(res.Kind, res.Account, res.Region, res.ID); err == nil {
+		res = found
+	}
+
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit : %s", value))
+			return
+		}
+	}
+
+	actions, err := resource.History(r.Context(), res, limit)
+	if err != nil {
+		logger.Error("error reading action history", "kind", res.Kind, "resource", res.ID, "error", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if actions == nil {
+		actions = []resource.Action{}
+	}
+
+	writeJSON(w, http.StatusOK, historyResponse{Actions: actions})
+}
+
+type transitionsResponse struct {
+	Transitions []resource.Transition `json:"transitions"`
+}
+
+// listTransitions returns the stops and starts which didn't complete yet, oldest first.
+// With stuck=true, only the resources flagged as stuck are returned.
+func (s *Server) listTransitions(w http.ResponseWriter, r *http.Request) {
+	stuckOnly := r.URL.Query().Get("stuck") == "true"
+
+	response := transitionsResponse{Transitions: []resource.Transition{}}
+	for _, transition := range resource.Transitions(r.Context()) {
+		if stuckOnly && !transition.Stuck {
+			continue
+		}
+		response.Transitions = append(response.Transitions, transition)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// matches reports whether a resource matches every filter. Empty filters match all resources.
+func matches(r resource.Resource, kind, region, account string, tags []string) bool {
+	if kind != "" && r.Kind != kind {
+		return false
+	}
+	if region != "" && r.Region != region {
+		return false
+	}
+	if account != "" && r.Account != account {
+		return false
+	}
+	for _, filter := range tags {
+		key, value, hasValue := strings.Cut(filter, "=")
+		actual, ok := r.TagValue(key)
+		if !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Describe computes the schedule state of a resource at the given time.
+func Describe(ctx context.Context, r resource.Resource, now time.Time) ResourceStatus {
+	status := ResourceStatus{
+		ID:      r.ID,
+		Kind:    r.Kind,
+		Region:  r.Region,
+		Account: r.Account,
+		Tags:    map[string]string{},
+		State:   r.State,
+		Warning: r.Warning,
+	}
+
+	for _, tag := range r.Tags {
+		status.Tags[tag.Key] = tag.Value
+
+		var err error
+		switch tag.Key {
+		case "cloudoff:uptime":
+			status.Uptime, err = scheduler.ParseSchedule(tag.Value)
+		case "cloudoff:downtime":
+			status.Downtime, err = scheduler.ParseSchedule(tag.Value)
+		}
+		if err != nil {
+			status.ScheduleError = err.Error()
+		}
+	}
+
+	if override, ok := resource.ActiveOverride(ctx, r, now); ok {
+		status.Override = &override
+		status.DesiredState = override.State
+		status.NextTransition = &override.Until
+	} else if status.ScheduleError == "" && (status.Uptime != nil || status.Downtime != nil || r.StoppedByCloudoff) {
+		downtime, err := scheduler.IsDowntime(r.Tags, now)
+		if err != nil {
+			status.ScheduleError = err.Error()
+		} else if downtime {
+			status.DesiredState = resource.StateStopped
+		} else {
+			status.DesiredState = resource.StateRunning
+		}
+
+		next, ok, err := scheduler.NextTransition(r.Tags, now)
+		if err == nil && ok {
+			status.NextTransition = &next
+		}
+	}
+
+	if ttl, ok := r.TagValue("cloudoff:ttl"); ok {
+		status.TTL = ttl
+		if expiration, ok := clean.Expiration(ctx, r); ok {
+			remaining := int64(max(expiration.Sub(now), 0).Seconds())
+			status.TTLExpiresAt = &expiration
+			status.TTLRemainingSeconds = &remaining
+		}
+		if extension, ok := resource.ActiveTTLExtension(ctx, r); ok {
+			status.TTLExtension = &extension
+		}
+	}
+
+	if action, ok := resource.LastAction(ctx, r); ok {
+		status.LastAction = &action
+	}
+
+	if transition, ok := resource.PendingTransition(ctx, r); ok {
+		status.Transition = &transition
+	}
+
+	return status
+}
+
+// writeError writes an error as {"error": "..."}.
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Error("error writing response", "error", err)
+	}
+}
+
+func init() {
+	logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+type fakeProvider struct {
+	stopped []string
+	started []string
+}
+
+func (p *fakeProvider) Kind() string { return "ec2-instance" }
+
+func (p *fakeProvider) Discover(ctx context.Context) ([]resource.Resource, error) {
+	return nil, nil
+}
+
+func (p *fakeProvider) Stop(ctx context.Context, r resource.Resource) error {
+	p.stopped = append(p.stopped, r.ID)
+	return nil
+}
+
+func (p *fakeProvider) Start(ctx context.Context, r resource.Resource) error {
+	p.started = append(p.started, r.ID)
+	return nil
+}
+
+func TestListResources(t *testing.T) {
+	resource.ResetInventory()
+	resource.ResetStore()
+	defer resource.ResetInventory()
+	defer resource.ResetStore()
+
+	web := resource.Resource{
+		ID: "i-web", Kind: "ec2-instance", Region: "eu-west-1", Account: "111111111111", State: resource.StateRunning,
+		Tags:     []resource.Tag{{Key: "cloudoff:downtime", Value: "Mon-Fri_20:00-23:59"}, {Key: "team", Value: "data"}},
+		TTLStart: time.Date(2025, 1, 6, 10, 0, 0, 0, time.UTC),
+	}
+	batch := resource.Resource{
+		ID: "i-batch", Kind: "ec2-instance", Region: "us-east-1", Account: "222222222222", State: resource.StateStopped,
+		Tags:     []resource.Tag{{Key: "cloudoff:ttl", Value: "1d"}, {Key: "team", Value: "web"}},
+		TTLStart: time.Date(2025, 1, 6, 10, 0, 0, 0, time.UTC),
+	}
+	resource.UpdateInventory(&fakeProvider{}, []resource.Resource{web, batch})
+	resource.RecordAction(context.Background(), web, resource.CapabilityStop, errors.New("access denied"))
+
+	server := NewServer("", leader)
+	// Monday 06/01/2025 19:00 UTC
+	server.now = func() time.Time { return time.Date(2025, 1, 6, 19, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{"No filter", "", []string{"i-batch", "i-web"}},
+		{"Region", "?region=eu-west-1", []string{"i-web"}},
+		{"Account", "?account=222222222222", []string{"i-batch"}},
+		{"Tag key", "?tag=cloudoff:ttl", []string{"i-batch"}},
+		{"Tag value", "?tag=team=data", []string{"i-web"}},
+		{"Several tags", "?tag=team=data&tag=cloudoff:ttl", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/resources"+tt.query, nil))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", recorder.Code)
+			}
+
+			var response listResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if len(response.Resources) != len(tt.expected) {
+				t.Fatalf("expected %v, got %+v", tt.expected, response.Resources)
+			}
+			for i, id := range tt.expected {
+				if response.Resources[i].ID != id {
+					t.Errorf("expected %s, got %s", id, response.Resources[i].ID)
+				}
+			}
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	resource.ResetInventory()
+	resource.ResetStore()
+	defer resource.ResetInventory()
+	defer resource.ResetStore()
+
+	// Monday 06/01/2025 19:00 UTC
+	now := time.Date(2025, 1, 6, 19, 0, 0, 0, time.UTC)
+
+	r := resource.Resource{
+		ID: "i-web", Kind: "ec2-instance", State: resource.StateRunning,
+		Tags: []resource.Tag{
+			{Key: "cloudoff:downtime", Value: "Mon-Fri_20:00-23:59"},
+			{Key: "cloudoff:ttl", Value: "1d"},
+		},
+		TTLStart: now.Add(-23 * time.Hour),
+	}
+	resource.RecordAction(context.Background(), r, resource.CapabilityStart, nil)
+
+	status := Describe(context.Background(), r, now)
+	if status.DesiredState != resource.StateRunning {
+		t.Errorf("expected desired state running, got %v", status.DesiredState)
+	}
+	if len(status.Downtime) != 1 || status.Downtime[0].Start != "20:00" {
+		t.Errorf("unexpected downtime %+v", status.Downtime)
+	}
+	if status.NextTransition == nil || !status.NextTransition.Equal(time.Date(2025, 1, 6, 20, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected next transition %v", status.NextTransition)
+	}
+	if status.TTLRemainingSeconds == nil || *status.TTLRemainingSeconds != 3600 {
+		t.Errorf("unexpected ttl remaining %v", status.TTLRemainingSeconds)
+	}
+	if status.LastAction == nil || status.LastAction.Action != resource.CapabilityStart {
+		t.Errorf("unexpected last action %+v", status.LastAction)
+	}
+
+	unscheduled := Describe(context.Background(), resource.Resource{ID: "i-other", State: resource.StateRunning}, now)
+	if unscheduled.DesiredState != "" || unscheduled.NextTransition != nil {
+		t.Errorf("expected no desired state for an unscheduled resource, got %+v", unscheduled)
+	}
+
+	invalid := Describe(context.Background(), resource.Resource{Tags: []resource.Tag{{Key: "cloudoff:uptime", Value: "Someday_08:00-20:00"}}}, now)
+	if invalid.ScheduleError == "" || invalid.DesiredState != "" {
+		t.Errorf("expected a schedule error, got %+v", invalid)
+	}
+}
+
+func leader() bool { return true }
+
+func TestListHistory(t *testing.T) {
+	resource.ResetStore()
+	defer resource.ResetStore()
+
+	r := resource.Resource{ID: "i-web", Kind: "ec2-instance"}
+	resource.RecordAction(context.Background(), r, resource.CapabilityStop, nil)
+	resource.RecordAction(context.Background(), r, resource.CapabilityStart, errors.New("insufficient capacity"))
+
+	server := NewServer("", leader)
+
+	tests := []struct {
+		name     string
+		query    string
+		code     int
+		expected []resource.Capability
+	}{
+		{"All actions", "?kind=ec2-instance&id=i-web", http.StatusOK, []resource.Capability{resource.CapabilityStart, resource.CapabilityStop}},
+		{"Limit", "?kind=ec2-instance&id=i-web&limit=1", http.StatusOK, []resource.Capability{resource.CapabilityStart}},
+		{"Unknown resource", "?kind=ec2-instance&id=i-other", http.StatusOK, []resource.Capability{}},
+		{"Missing id", "?kind=ec2-instance", http.StatusBadRequest, nil},
+		{"Invalid limit", "?kind=ec2-instance&id=i-web&limit=-1", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/history"+tt.query, nil))
+			if recorder.Code != tt.code {
+				t.Fatalf("expected %d, got %d", tt.code, recorder.Code)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+
+			var response historyResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if len(response.Actions) != len(tt.expected) {
+				t.Fatalf("expected %v, got %+v", tt.expected, response.Actions)
+			}
+			for i, action := range tt.expected {
+				if response.Actions[i].Action != action {
+					t.Errorf("expected %s, got %s", action, response.Actions[i].Action)
+				}
+			}
+		})
+	}
+}
+
+func TestListTransitions(t *testing.T) {
+	resource.ResetInventory()
+	resource.ResetStore()
+	defer resource.ResetInventory()
+	defer resource.ResetStore()
+	t.Setenv("START_MAX_ATTEMPTS", "1")
+
+	stopping := resource.Resource{ID: "i-stopping", Kind: "ec2-instance"}
+	capacity := resource.Resource{ID: "i-capacity", Kind: "ec2-instance"}
+	resource.UpdateInventory(&fakeProvider{}, []resource.Resource{stopping, capacity})
+	resource.BeginTransition(context.Background(), stopping, resource.CapabilityStop, time.Now())
+	resource.FailTransition(context.Background(), capacity, resource.CapabilityStart, errors.New("insufficient capacity"), time.Now())
+
+	// The transitions are read from the store, a follower returns those of the leader
+	server := NewServer("", func() bool { return false })
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{"All transitions", "", []string{"i-stopping", "i-capacity"}},
+		{"Stuck resources", "?stuck=true", []string{"i-capacity"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/transitions"+tt.query, nil))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", recorder.Code)
+			}
+
+			var response transitionsResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if len(response.Transitions) != len(tt.expected) {
+				t.Fatalf("expected %v, got %+v", tt.expected, response.Transitions)
+			}
+			for i, id := range tt.expected {
+				if response.Transitions[i].ID != id {
+					t.Errorf("expected %s, got %s", id, response.Transitions[i].ID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// clientTimeoutMargin leaves the server the time to answer once an action times out, so
+// the client never gives up on an action the server completes.
+const clientTimeoutMargin = 30 * time.Second
+
+// Client sends manual actions to a cloudoff server.
+type Client struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func NewClient(endpoint, token string) *Client {
+	return &Client{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		token:    token,
+		client:   &http.Client{Timeout: ActionTimeout + clientTimeoutMargin},
+	}
+}
+
+// Apply sends a manual action and returns the resource after the action.
+func (c *Client) Apply(ctx context.Context, request ActionRequest) (ResourceStatus, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return ResourceStatus{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"/api/v1/actions", bytes.NewReader(body))
+	if err != nil {
+		return ResourceStatus{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return ResourceStatus{}, fmt.Errorf("error sending action: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiError struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiError)
+		return ResourceStatus{}, fmt.Errorf("action rejected (%s): %s", resp.Status, apiError.Error)
+	}
+
+	var response ActionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return ResourceStatus{}, fmt.Errorf("invalid response: %v", err)
+	}
+	return response.Resource, nil
+}
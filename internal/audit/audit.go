@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+var logger *slog.Logger
+
+// Actors performing the actions
+const (
+	ActorScheduler = "scheduler"
+	ActorCleaner   = "cleaner"
+	ActorAPI       = "api"
+)
+
+// Reasons of the actions
+const (
+	ReasonDowntime        = "downtime"
+	ReasonUptime          = "uptime"
+	ReasonScheduleRemoved = "schedule removed"
+	ReasonOverride        = "override"
+	ReasonManual          = "manual"
+	ReasonTTL             = "ttl"
+	ReasonOrphaned        = "orphaned"
+)
+
+// Result is the outcome of an action.
+type Result string
+
+const (
+	ResultSuccess Result = "success"
+	ResultFailure Result = "failure"
+	// ResultDryRun is the result of the actions not performed because of DRYRUN
+	ResultDryRun Result = "dry-run"
+)
+
+// Event describes an action taken by cloudoff on a resource: who took it, on what and why.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+
+	Kind     string `json:"kind"`
+	Resource string `json:"resource"`
+	Region   string `json:"region,omitempty"`
+	Account  string `json:"account,omitempty"`
+	// Owner and Team are the values of the owner and team tags of the resource
+	Owner string `json:"owner,omitempty"`
+	Team  string `json:"team,omitempty"`
+
+	Reason string `json:"reason"`
+	// Tag is the tag which triggered the action, as "key=value"
+	Tag string `json:"tag,omitempty"`
+	// Schedule is the entry of the uptime or downtime schedule matching the time of the action
+	Schedule string `json:"schedule,omitempty"`
+
+	DryRun bool   `json:"dryRun"`
+	Result Result `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// NewEvent returns an event for an action on a resource.
+func NewEvent(actor, action, reason string, r resource.Resource) Event {
+	owner, _ := r.TagValue("owner")
+	team, _ := r.TagValue("team")
+	return Event{
+		Actor:    actor,
+		Action:   action,
+		Kind:     r.Kind,
+		Resource: r.ID,
+		Region:   r.Region,
+		Account:  r.Account,
+		Owner:    owner,
+		Team:     team,
+		Reason:   reason,
+	}
+}
+
+// WithTag sets the tag which triggered the action.
+func (e Event) WithTag(key, value string) Event {
+	e.Tag = key + "=" + value
+	return e
+}
+
+// Sink receives the audit events.
+type Sink interface {
+	Write(event Event) error
+	Close() error
+}
+
+var (
+	sinkMu sync.Mutex
+	sink   Sink = NewWriterSink(os.Stdout)
+)
+
+// SetSink replaces the sink of the events, the previous one is closed.
+func SetSink(s Sink) {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+	if err := sink.Close(); err != nil {
+		logger.Error("error closing audit sink", "error", err)
+	}
+	sink = s
+}
+
+// ResetSink replaces the sink with stdout, the previous one is closed.
+func ResetSink() {
+	SetSink(NewWriterSink(os.Stdout))
+}
+
+// Record sets the time and the result of an event from the error of the action, then
+// writes it to the sink.
+func Record(event Event, err error) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	switch {
+	case event.DryRun:
+		event.Result = ResultDryRun
+	case err != nil:
+		event.Result = ResultFailure
+		event.Error = err.Error()
+	default:
+		event.Result = ResultSuccess
+	}
+
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+	if err := sink.Write(event); err != nil {
+		logger.Error("error writing audit event", "kind", event.Kind, "resource", event.Resource, "action", event.Action, "error", err)
+	}
+}
+
+func init() {
+	logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+}
@@ -0,0 +1,184 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+// memorySink keeps the events written.
+type memorySink struct {
+	events []Event
+}
+
+func (s *memorySink) Write(event Event) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+func TestRecord(t *testing.T) {
+	sink := &memorySink{}
+	SetSink(sink)
+	defer ResetSink()
+
+	r := resource.Resource{ID: "i-web", Kind: "ec2-instance", Region: "eu-west-1", Account: "111111111111"}
+
+	tests := []struct {
+		name     string
+		dryRun   bool
+		err      error
+		expected Result
+	}{
+		{"Success", false, nil, ResultSuccess},
+		{"Failure", false, errors.New("access denied"), ResultFailure},
+		{"Dry run", true, nil, ResultDryRun},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := NewEvent(ActorScheduler, "stop", ReasonDowntime, r).WithTag("cloudoff:downtime", "Mon-Fri_20:00-23:59")
+			event.DryRun = tt.dryRun
+			Record(event, tt.err)
+
+			recorded := sink.events[i]
+			if recorded.Result != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, recorded.Result)
+			}
+			if tt.err != nil && recorded.Error != tt.err.Error() {
+				t.Errorf("expected error %q, got %q", tt.err, recorded.Error)
+			}
+			if recorded.Time.IsZero() || recorded.Tag != "cloudoff:downtime=Mon-Fri_20:00-23:59" || recorded.Account != "111111111111" {
+				t.Errorf("unexpected event %+v", recorded)
+			}
+		})
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	// Events are appended to the existing file
+	for _, id := range []string{"i-web", "i-batch"} {
+		sink, err := NewFileSink(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := sink.Write(Event{Action: "stop", Resource: id, Result: ResultSuccess}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", content)
+	}
+	var event Event
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil || event.Resource != "i-batch" {
+		t.Errorf("unexpected line %q: %v", lines[1], err)
+	}
+}
+
+// fakeS3 keeps the objects written.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	err     error
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	body, _ := io.ReadAll(params.Body)
+	f.objects[*params.Bucket+"/"+*params.Key] = body
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) lines() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := 0
+	for _, body := range f.objects {
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			count++
+		}
+	}
+	return count
+}
+
+func TestS3Sink(t *testing.T) {
+	client := &fakeS3{objects: map[string][]byte{}, err: errors.New("no such bucket")}
+	sink := newS3Sink(client, "audit", "cloudoff/", time.Hour)
+
+	start := time.Date(2025, 1, 6, 19, 0, 0, 0, time.UTC)
+	for i := range s3BatchSize - 1 {
+		if err := sink.Write(Event{Time: start.Add(time.Duration(i) * time.Second), Action: "stop"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(client.objects) != 0 {
+		t.Fatalf("expected the events to wait for a full batch")
+	}
+
+	// The batch is kept when the bucket can't be written
+	if err := sink.Write(Event{Time: start.Add(time.Hour), Action: "stop"}); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	client.mu.Lock()
+	client.err = nil
+	client.mu.Unlock()
+	if err := sink.Write(Event{Time: start.Add(2 * time.Hour), Action: "start"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := client.objects["audit/cloudoff/2025/01/06/20250106T190000.000000000Z.jsonl"]; !ok {
+		t.Errorf("unexpected objects %v", client.objects)
+	}
+
+	// Close writes the pending events
+	if err := sink.Write(Event{Time: start.Add(3 * time.Hour), Action: "stop"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.lines() != s3BatchSize+2 {
+		t.Errorf("expected %d events, got %d", s3BatchSize+2, client.lines())
+	}
+}
+
+func TestNewSink(t *testing.T) {
+	if _, err := NewSink(context.Background(), "stdout", "", "", ""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := NewSink(context.Background(), "s3", "", "", ""); err == nil {
+		t.Errorf("expected an error without bucket")
+	}
+	if _, err := NewSink(context.Background(), "unknown", "", "", ""); err == nil {
+		t.Errorf("expected an error for an unknown sink")
+	}
+}
@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// s3BatchSize is the number of events written in an object
+	s3BatchSize = 100
+	// s3FlushInterval is the maximum time an event waits before being written
+	s3FlushInterval = time.Minute
+	// s3MaxPending is the number of events kept while the bucket can't be written,
+	// the oldest ones are dropped beyond
+	s3MaxPending = 10000
+	// s3WriteTimeout bounds the write of a batch, the events are written in the
+	// background or at exit and no caller waits for them
+	s3WriteTimeout = 30 * time.Second
+)
+
+// s3API is the part of the S3 client used by the sink.
+type s3API interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// S3Sink writes the events in batches to JSON lines objects of a bucket, under
+// <prefix>YYYY/MM/DD/.
+type S3Sink struct {
+	client s3API
+	bucket string
+	prefix string
+
+	mu      sync.Mutex
+	pending []Event
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewS3Sink returns a sink writing to the given bucket. The endpoint is only set to use
+// a local stand-in such as MinIO or LocalStack.
+func NewS3Sink(ctx context.Context, bucket, prefix, endpoint string) (*S3Sink, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading AWS configuration: %v", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return newS3Sink(client, bucket, prefix, s3FlushInterval), nil
+}
+
+func newS3Sink(client s3API, bucket, prefix string, interval time.Duration) *S3Sink {
+	s := &S3Sink{
+		client: client,
+		bucket: bucket,
+		prefix: prefix,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go s.run(interval)
+	return s
+}
+
+// run writes the pending events periodically so they don't wait for a full batch.
+func (s *S3Sink) run(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				logger.Error("error writing audit events", "bucket", s.bucket, "error", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *S3Sink) Write(event Event) error {
+	s.mu.Lock()
+	s.pending = append(s.pending, event)
+	if len(s.pending) > s3MaxPending {
+		logger.Error("too many pending audit events, dropping the oldest", "bucket", s.bucket, "dropped", len(s.pending)-s3MaxPending)
+		s.pending = s.pending[len(s.pending)-s3MaxPending:]
+	}
+	full := len(s.pending) >= s3BatchSize
+	s.mu.Unlock()
+
+	if full {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush writes the pending events to a new object. They are kept to be written on the
+// next flush when the bucket can't be written.
+func (s *S3Sink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, event := range s.pending {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	first := s.pending[0].Time.UTC()
+	key := fmt.Sprintf("%s%s/%s.jsonl", s.prefix, first.Format("2006/01/02"), first.Format("20060102T150405.000000000Z"))
+	ctx, cancel := context.WithTimeout(context.Background(), s3WriteTimeout)
+	defer cancel()
+
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body.Bytes()),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		return fmt.Errorf("error writing audit events to s3://%s/%s: %v", s.bucket, key, err)
+	}
+
+	s.pending = nil
+	return nil
+}
+
+// Close stops the periodic writes and writes the pending events.
+func (s *S3Sink) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.Flush()
+}
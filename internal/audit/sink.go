@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// WriterSink writes the events as JSON lines.
+type WriterSink struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+func NewWriterSink(writer io.Writer) *WriterSink {
+	return &WriterSink{writer: writer}
+}
+
+// NewFileSink appends the events as JSON lines to a file, created if needed.
+func NewFileSink(path string) (*WriterSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit file %s: %v", path, err)
+	}
+	return NewWriterSink(file), nil
+}
+
+func (s *WriterSink) Write(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.writer.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file of the sink, stdout and stderr are left open.
+func (s *WriterSink) Close() error {
+	if s.writer == os.Stdout || s.writer == os.Stderr {
+		return nil
+	}
+	if closer, ok := s.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// MultiSink writes the events to several sinks.
+type MultiSink []Sink
+
+func (s MultiSink) Write(event Event) error {
+	var errs []error
+	for _, sink := range s {
+		if err := sink.Write(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s MultiSink) Close() error {
+	var errs []error
+	for _, sink := range s {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewSink returns the sink of the given type: "stdout", "file" (the location is the path
+// of the file) or "s3" (the location is the bucket).
+func NewSink(ctx context.Context, sinkType, location, prefix, endpoint string) (Sink, error) {
+	switch sinkType {
+	case "stdout":
+		return NewWriterSink(os.Stdout), nil
+	case "file":
+		return NewFileSink(location)
+	case "s3":
+		if location == "" {
+			return nil, fmt.Errorf("bucket is required for the s3 audit sink")
+		}
+		return NewS3Sink(ctx, location, prefix, endpoint)
+	default:
+		return nil, fmt.Errorf("unknown audit sink : %s", sinkType)
+	}
+}
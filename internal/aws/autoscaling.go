@@ -0,0 +1,231 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	astypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+)
+
+// SavedCapacityTag is the tag used to remember the capacity of an Auto Scaling
+// group or an EKS node group while it is scaled to zero.
+const SavedCapacityTag = "cloudoff:saved-capacity"
+
+// autoScalingGroupTag is set by AWS on every instance launched by an Auto Scaling group.
+const autoScalingGroupTag = "aws:autoscaling:groupName"
+
+type Capacity struct {
+	MinSize         int32
+	MaxSize         int32
+	DesiredCapacity int32
+}
+
+// IsScaledDown reports whether no node can run with this capacity. The max size
+// is ignored as EKS requires it to be at least 1.
+func (c Capacity) IsScaledDown() bool {
+	return c.MinSize == 0 && c.DesiredCapacity == 0
+}
+
+type AutoScalingGroup struct {
+	Name     string
+	Arn      string
+	Region   string
+	Capacity Capacity
+	Tags     []Tag
+}
+
+// DiscoverAutoScalingGroups returns the Auto Scaling groups carrying a cloudoff tag.
+func DiscoverAutoScalingGroups(ctx context.Context) ([]AutoScalingGroup, error) {
+
+	svc, err := sharedAutoScalingClient(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	input := &autoscaling.DescribeAutoScalingGroupsInput{
+		Filters: []astypes.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []string{"cloudoff:uptime", "cloudoff:downtime", SavedCapacityTag},
+			},
+		},
+	}
+
+	var listGroups []AutoScalingGroup
+
+	paginator := autoscaling.NewDescribeAutoScalingGroupsPaginator(svc, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe auto scaling groups: %v", err)
+		}
+
+		for _, group := range page.AutoScalingGroups {
+			var tags []Tag
+			for _, tag := range group.Tags {
+				tags = append(tags, Tag{
+					Key:   aws.ToString(tag.Key),
+					Value: aws.ToString(tag.Value),
+				})
+			}
+
+			listGroups = append(listGroups, AutoScalingGroup{
+				Name:   aws.ToString(group.AutoScalingGroupName),
+				Arn:    aws.ToString(group.AutoScalingGroupARN),
+				Region: svc.Options().Region,
+				Capacity: Capacity{
+					MinSize:         aws.ToInt32(group.MinSize),
+					MaxSize:         aws.ToInt32(group.MaxSize),
+					DesiredCapacity: aws.ToInt32(group.DesiredCapacity),
+				},
+				Tags: tags,
+			})
+		}
+	}
+
+	return listGroups, nil
+}
+
+// SavedCapacity returns the capacity recorded on the group before it was scaled down.
+func (g AutoScalingGroup) SavedCapacity() (Capacity, bool, error) {
+	return savedCapacity(g.Tags)
+}
+
+// savedCapacity reads the capacity recorded in the cloudoff:saved-capacity tag.
+func savedCapacity(tags []Tag) (Capacity, bool, error) {
+	for _, tag := range tags {
+		if tag.Key == SavedCapacityTag {
+			capacity, err := ParseCapacity(tag.Value)
+			if err != nil {
+				return Capacity{}, true, err
+			}
+			return capacity, true, nil
+		}
+	}
+	return Capacity{}, false, nil
+}
+
+// FormatCapacity encodes a capacity as a tag value (ex. : "min=1 max=3 desired=2").
+// Entries are separated by spaces because EKS doesn't allow commas in tag values.
+func FormatCapacity(c Capacity) string {
+	return fmt.Sprintf("min=%d max=%d desired=%d", c.MinSize, c.MaxSize, c.DesiredCapacity)
+}
+
+// ParseCapacity decodes a tag value written by FormatCapacity. Entries separated
+// by commas are also accepted.
+func ParseCapacity(input string) (Capacity, error) {
+	var capacity Capacity
+	seen := map[string]bool{}
+
+	parts := strings.FieldsFunc(input, func(r rune) bool { return r == ',' || r == ' ' })
+	for _, part := range parts {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return Capacity{}, fmt.Errorf("invalid capacity entry : %s", part)
+		}
+
+		value, err := strconv.ParseInt(kv[1], 10, 32)
+		if err != nil || value < 0 {
+			return Capacity{}, fmt.Errorf("invalid capacity value : %s", part)
+		}
+
+		switch kv[0] {
+		case "min":
+			capacity.MinSize = int32(value)
+		case "max":
+			capacity.MaxSize = int32(value)
+		case "desired":
+			capacity.DesiredCapacity = int32(value)
+		default:
+			return Capacity{}, fmt.Errorf("unknown capacity key : %s", kv[0])
+		}
+		seen[kv[0]] = true
+	}
+
+	if len(seen) != 3 {
+		return Capacity{}, fmt.Errorf("incomplete capacity : %s", input)
+	}
+
+	return capacity, nil
+}
+
+// ScaleDownAutoScalingGroup records the current capacity in a tag then sets
+// min, max and desired capacity to zero.
+func ScaleDownAutoScalingGroup(ctx context.Context, group AutoScalingGroup) error {
+	asgClient, err := sharedAutoScalingClient(ctx, group.Region)
+	if err != nil {
+		return err
+	}
+
+	// Save the capacity first so it is never lost if the update fails
+	_, err = asgClient.CreateOrUpdateTags(ctx, &autoscaling.CreateOrUpdateTagsInput{
+		Tags: []astypes.Tag{
+			{
+				Key:               aws.String(SavedCapacityTag),
+				Value:             aws.String(FormatCapacity(group.Capacity)),
+				ResourceId:        aws.String(group.Name),
+				ResourceType:      aws.String("auto-scaling-group"),
+				PropagateAtLaunch: aws.Bool(false),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error saving capacity of auto scaling group %s: %v", group.Name, err)
+	}
+
+	err = updateCapacity(ctx, asgClient, group.Name, Capacity{})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("auto scaling group scaled down successfully", "autoscalinggroup", group.Name, "capacity", FormatCapacity(group.Capacity))
+	return nil
+}
+
+// RestoreAutoScalingGroup sets the group back to the given capacity and removes the saved capacity tag.
+func RestoreAutoScalingGroup(ctx context.Context, group AutoScalingGroup, capacity Capacity) error {
+	asgClient, err := sharedAutoScalingClient(ctx, group.Region)
+	if err != nil {
+		return err
+	}
+
+	err = updateCapacity(ctx, asgClient, group.Name, capacity)
+	if err != nil {
+		return err
+	}
+
+	_, err = asgClient.DeleteTags(ctx, &autoscaling.DeleteTagsInput{
+		Tags: []astypes.Tag{
+			{
+				Key:          aws.String(SavedCapacityTag),
+				ResourceId:   aws.String(group.Name),
+				ResourceType: aws.String("auto-scaling-group"),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error removing saved capacity of auto scaling group %s: %v", group.Name, err)
+	}
+
+	logger.Info("auto scaling group restored successfully", "autoscalinggroup", group.Name, "capacity", FormatCapacity(capacity))
+	return nil
+}
+
+func updateCapacity(ctx context.Context, asgClient *autoscaling.Client, name string, capacity Capacity) error {
+	input := &autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(name),
+		MinSize:              aws.Int32(capacity.MinSize),
+		MaxSize:              aws.Int32(capacity.MaxSize),
+		DesiredCapacity:      aws.Int32(capacity.DesiredCapacity),
+	}
+
+	_, err := asgClient.UpdateAutoScalingGroup(ctx, input)
+	if err != nil {
+		return fmt.Errorf("error updating capacity of auto scaling group %s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package ec2
+
+import (
+	"testing"
+)
+
+func TestParseCapacity(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Capacity
+		hasError bool
+	}{
+		{"min=1 max=3 desired=2", Capacity{MinSize: 1, MaxSize: 3, DesiredCapacity: 2}, false},
+		{"min=1,max=3,desired=2", Capacity{MinSize: 1, MaxSize: 3, DesiredCapacity: 2}, false},
+		{"desired=2,min=1,max=3", Capacity{MinSize: 1, MaxSize: 3, DesiredCapacity: 2}, false},
+		{"min=0,max=0,desired=0", Capacity{}, false},
+		{"min=1,max=3", Capacity{}, true},            // Missing desired
+		{"min=1,max=3,desired=x", Capacity{}, true},  // Invalid number
+		{"min=-1,max=3,desired=2", Capacity{}, true}, // Negative value
+		{"min=1,max=3,size=2", Capacity{}, true},     // Unknown key
+		{"", Capacity{}, true},                       // Empty input
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			result, err := ParseCapacity(test.input)
+			if test.hasError {
+				if err == nil {
+					t.Errorf("expected an error for input '%s', but got none", test.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("did not expect an error for input '%s', but got: %v", test.input, err)
+			}
+			if result != test.expected {
+				t.Errorf("for input '%s', expected %v, but got %v", test.input, test.expected, result)
+			}
+		})
+	}
+}
+
+func TestFormatCapacity(t *testing.T) {
+	capacity := Capacity{MinSize: 1, MaxSize: 3, DesiredCapacity: 2}
+
+	value := FormatCapacity(capacity)
+	if value != "min=1 max=3 desired=2" {
+		t.Errorf("unexpected value %s", value)
+	}
+
+	parsed, err := ParseCapacity(value)
+	if err != nil || parsed != capacity {
+		t.Errorf("expected %v, got %v (error %v)", capacity, parsed, err)
+	}
+}
+
+func TestIsScaledDown(t *testing.T) {
+	tests := []struct {
+		capacity Capacity
+		expected bool
+	}{
+		{Capacity{}, true},
+		{Capacity{MaxSize: 3}, true}, // EKS node groups keep a max size
+		{Capacity{MaxSize: 3, DesiredCapacity: 1}, false},
+		{Capacity{MinSize: 1, MaxSize: 3}, false},
+	}
+
+	for _, test := range tests {
+		if result := test.capacity.IsScaledDown(); result != test.expected {
+			t.Errorf("for %v, expected %v, got %v", test.capacity, test.expected, result)
+		}
+	}
+}
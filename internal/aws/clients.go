@@ -0,0 +1,216 @@
+package ec2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+// Default client-side limits per API, below the request token buckets of EC2 (20
+// requests per second for the describe calls and 5 for the mutating calls).
+const (
+	defaultReadRateLimit  = 20
+	defaultWriteRateLimit = 5
+	// rateLimitBurst is the burst of a limiter, in seconds of its rate
+	rateLimitBurst = 5
+	// defaultMaxAttempts is the number of attempts of a call, retries included
+	defaultMaxAttempts = 5
+)
+
+var (
+	throttledRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloudoff_aws_throttled_requests_total",
+		Help: "AWS requests rejected because of throttling, retries included.",
+	}, []string{"service", "operation"})
+	rateLimitWait = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloudoff_aws_rate_limit_wait_seconds_total",
+		Help: "Time spent waiting for the client-side rate limiter before calling AWS.",
+	}, []string{"service", "operation"})
+)
+
+// The configurations and the clients are shared by all the calls of a region, for the
+// account of the credentials, so the retryers and the rate limiters see every request.
+var (
+	clientsMu sync.Mutex
+	configs   = map[string]aws.Config{}
+	clients   = map[string]any{}
+
+	limitersMu sync.Mutex
+	limiters   = map[string]*rate.Limiter{}
+)
+
+func sharedEC2Client(ctx context.Context, region string) (*ec2.Client, error) {
+	return cachedClient(ctx, "ec2", region, func(cfg aws.Config) *ec2.Client { return ec2.NewFromConfig(cfg) })
+}
+
+func sharedAutoScalingClient(ctx context.Context, region string) (*autoscaling.Client, error) {
+	return cachedClient(ctx, "autoscaling", region, func(cfg aws.Config) *autoscaling.Client { return autoscaling.NewFromConfig(cfg) })
+}
+
+func sharedECSClient(ctx context.Context, region string) (*ecs.Client, error) {
+	return cachedClient(ctx, "ecs", region, func(cfg aws.Config) *ecs.Client { return ecs.NewFromConfig(cfg) })
+}
+
+func sharedEKSClient(ctx context.Context, region string) (*eks.Client, error) {
+	return cachedClient(ctx, "eks", region, func(cfg aws.Config) *eks.Client { return eks.NewFromConfig(cfg) })
+}
+
+func sharedRDSClient(ctx context.Context, region string) (*rds.Client, error) {
+	return cachedClient(ctx, "rds", region, func(cfg aws.Config) *rds.Client { return rds.NewFromConfig(cfg) })
+}
+
+// cachedClient returns the client of a service for a region, created on first use. An
+// empty region uses the region of the default configuration.
+func cachedClient[T any](ctx context.Context, service, region string, newClient func(aws.Config) T) (T, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	key := service + "/" + region
+	if client, ok := clients[key]; ok {
+		return client.(T), nil
+	}
+
+	cfg, ok := configs[region]
+	if !ok {
+		var err error
+		if cfg, err = loadConfig(ctx, region); err != nil {
+			var zero T
+			return zero, fmt.Errorf("error loading AWS configuration: %v", err)
+		}
+		configs[region] = cfg
+	}
+
+	client := newClient(cfg)
+	clients[key] = client
+	return client, nil
+}
+
+// loadConfig loads the configuration of a region with the adaptive retry mode, unless
+// AWS_RETRY_MODE or AWS_MAX_ATTEMPTS are set, and the client-side rate limiters.
+func loadConfig(ctx context.Context, region string, optFns ...func(*config.LoadOptions) error) (aws.Config, error) {
+	options := []func(*config.LoadOptions) error{
+		config.WithAPIOptions([]func(*middleware.Stack) error{addRateLimit, addThrottleMetrics}),
+	}
+	if region != "" {
+		options = append(options, config.WithRegion(region))
+	}
+	if os.Getenv("AWS_RETRY_MODE") == "" {
+		options = append(options, config.WithRetryMode(aws.RetryModeAdaptive))
+	}
+	if os.Getenv("AWS_MAX_ATTEMPTS") == "" {
+		options = append(options, config.WithRetryMaxAttempts(defaultMaxAttempts))
+	}
+	return config.LoadDefaultConfig(ctx, append(options, optFns...)...)
+}
+
+// ResetClients drops the cached configurations, clients and rate limiters.
+func ResetClients() {
+	clientsMu.Lock()
+	configs = map[string]aws.Config{}
+	clients = map[string]any{}
+	clientsMu.Unlock()
+
+	limitersMu.Lock()
+	limiters = map[string]*rate.Limiter{}
+	limitersMu.Unlock()
+}
+
+// addRateLimit waits for the token bucket of the API before each call. The retries are
+// paced by the adaptive retry mode instead.
+func addRateLimit(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CloudoffRateLimit",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			service, operation := awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)
+
+			start := time.Now()
+			if err := limiter(service, awsmiddleware.GetRegion(ctx), operation).Wait(ctx); err != nil {
+				return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("error waiting for the rate limit of %s %s: %v", service, operation, err)
+			}
+			if waited := time.Since(start); waited > time.Millisecond {
+				rateLimitWait.WithLabelValues(service, operation).Add(waited.Seconds())
+			}
+			return next.HandleInitialize(ctx, in)
+		}), middleware.After)
+}
+
+// addThrottleMetrics counts the throttled attempts, after the retry middleware so every
+// attempt is seen.
+func addThrottleMetrics(stack *middleware.Stack) error {
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("CloudoffThrottleMetrics",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleFinalize(ctx, in)
+			if isThrottle(err) {
+				throttledRequests.WithLabelValues(awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)).Inc()
+			}
+			return out, metadata, err
+		}), "Retry", middleware.After)
+}
+
+// isThrottle reports whether an error is a throttling error of AWS.
+func isThrottle(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	_, ok := retry.DefaultThrottleErrorCodes[apiErr.ErrorCode()]
+	return ok
+}
+
+// limiter returns the token bucket of an API in a region, as AWS throttles the requests
+// per account and region.
+func limiter(service, region, operation string) *rate.Limiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	key := service + "/" + region + "/" + operation
+	if l, ok := limiters[key]; ok {
+		return l
+	}
+	limit := rateLimit(operation)
+	l := rate.NewLimiter(rate.Limit(limit), int(max(1, limit*rateLimitBurst)))
+	limiters[key] = l
+	return l
+}
+
+// rateLimit returns the calls per second allowed for an API, read from AWS_RATE_LIMIT
+// for the describe calls and AWS_MUTATING_RATE_LIMIT for the others.
+func rateLimit(operation string) float64 {
+	name, limit := "AWS_MUTATING_RATE_LIMIT", float64(defaultWriteRateLimit)
+	for _, prefix := range []string{"Describe", "List", "Get"} {
+		if strings.HasPrefix(operation, prefix) {
+			name, limit = "AWS_RATE_LIMIT", defaultReadRateLimit
+			break
+		}
+	}
+
+	value := os.Getenv(name)
+	if value == "" {
+		return limit
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed <= 0 {
+		logger.Error("invalid "+name+", default used", "value", value, "default", limit)
+		return limit
+	}
+	return parsed
+}
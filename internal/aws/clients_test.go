@@ -0,0 +1,97 @@
+package ec2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCachedClient(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	ResetClients()
+	defer ResetClients()
+
+	ctx := context.Background()
+	first, err := sharedEC2Client(ctx, "eu-west-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, _ := sharedEC2Client(ctx, "eu-west-1")
+	other, _ := sharedEC2Client(ctx, "us-east-1")
+
+	if first != second {
+		t.Errorf("expected the client of a region to be shared")
+	}
+	if first == other || other.Options().Region != "us-east-1" {
+		t.Errorf("expected a client per region, got %s", other.Options().Region)
+	}
+	if mode := first.Options().RetryMode; mode != "adaptive" {
+		t.Errorf("expected the adaptive retry mode, got %s", mode)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		env       map[string]string
+		expected  float64
+	}{
+		{"describe default", "DescribeInstances", nil, defaultReadRateLimit},
+		{"mutating default", "StopInstances", nil, defaultWriteRateLimit},
+		{"describe", "ListClusters", map[string]string{"AWS_RATE_LIMIT": "2.5"}, 2.5},
+		{"mutating", "UpdateNodegroupConfig", map[string]string{"AWS_MUTATING_RATE_LIMIT": "1"}, 1},
+		{"invalid", "StartInstances", map[string]string{"AWS_MUTATING_RATE_LIMIT": "-1"}, defaultWriteRateLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			if limit := rateLimit(tt.operation); limit != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, limit)
+			}
+		})
+	}
+}
+
+func TestThrottleMetrics(t *testing.T) {
+	ResetClients()
+	defer ResetClients()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`<Response><Errors><Error><Code>RequestLimitExceeded</Code><Message>Request limit exceeded.</Message></Error></Errors><RequestID>1</RequestID></Response>`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	cfg, err := loadConfig(ctx, "eu-west-3",
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("test", "test", "")),
+		config.WithBaseEndpoint(server.URL),
+		config.WithRetryMaxAttempts(1),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	throttled := throttledRequests.WithLabelValues("EC2", "DescribeInstances")
+	before := testutil.ToFloat64(throttled)
+	if _, err := ec2.NewFromConfig(cfg).DescribeInstances(ctx, &ec2.DescribeInstancesInput{}); err == nil {
+		t.Fatalf("expected the throttling error")
+	}
+	if count := testutil.ToFloat64(throttled) - before; count != 1 {
+		t.Errorf("expected 1 throttled request, got %v", count)
+	}
+	if _, ok := limiters["EC2/eu-west-3/DescribeInstances"]; !ok {
+		t.Errorf("expected a rate limiter for the API in the region, got %v", limiters)
+	}
+}
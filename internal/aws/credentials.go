@@ -0,0 +1,28 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// CheckCredentials verifies that the AWS credentials are valid and returns their account.
+func CheckCredentials(ctx context.Context) (string, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error loading AWS configuration: %v", err)
+	}
+	// STS is available in every region, the global endpoint is used without region
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	identity, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("invalid AWS credentials: %v", err)
+	}
+	return aws.ToString(identity.Account), nil
+}
@@ -0,0 +1,185 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+type Volume struct {
+	ID         string
+	Region     string
+	State      string
+	Attached   bool
+	CreateTime time.Time
+	Tags       []Tag
+}
+
+type Snapshot struct {
+	ID     string
+	Region string
+	// VolumeID is the source volume, vol-ffffffff for the copies of snapshots
+	VolumeID  string
+	StartTime time.Time
+	Tags      []Tag
+	// ImageID is the AMI the snapshot is registered to, if any
+	ImageID string
+}
+
+// copiedSnapshotVolume is the source volume of the snapshots created by CopySnapshot.
+const copiedSnapshotVolume = "vol-ffffffff"
+
+// Copy reports whether the snapshot is a copy of another snapshot, its source volume is
+// unknown.
+func (s Snapshot) Copy() bool {
+	return s.VolumeID == copiedSnapshotVolume
+}
+
+// Backup reports whether the snapshot was created by AWS Backup.
+func (s Snapshot) Backup() bool {
+	for _, tag := range s.Tags {
+		if strings.HasPrefix(tag.Key, "aws:backup:") {
+			return true
+		}
+	}
+	return false
+}
+
+// DiscoverVolumes returns the EBS volumes of the account.
+func DiscoverVolumes(ctx context.Context) ([]Volume, error) {
+
+	svc, err := sharedEC2Client(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var listVolumes []Volume
+
+	paginator := ec2.NewDescribeVolumesPaginator(svc, &ec2.DescribeVolumesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe volumes: %v", err)
+		}
+
+		for _, volume := range page.Volumes {
+			listVolumes = append(listVolumes, Volume{
+				ID:         aws.ToString(volume.VolumeId),
+				Region:     svc.Options().Region,
+				State:      string(volume.State),
+				Attached:   volume.State != types.VolumeStateAvailable || len(volume.Attachments) > 0,
+				CreateTime: aws.ToTime(volume.CreateTime),
+				Tags:       ConvertToCustomTag(volume.Tags),
+			})
+		}
+	}
+
+	return listVolumes, nil
+}
+
+// DiscoverSnapshots returns the EBS snapshots owned by the account, with the AMI they
+// are registered to.
+func DiscoverSnapshots(ctx context.Context) ([]Snapshot, error) {
+
+	svc, err := sharedEC2Client(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var listSnapshots []Snapshot
+
+	paginator := ec2.NewDescribeSnapshotsPaginator(svc, &ec2.DescribeSnapshotsInput{
+		OwnerIds: []string{"self"},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe snapshots: %v", err)
+		}
+
+		for _, snapshot := range page.Snapshots {
+			listSnapshots = append(listSnapshots, Snapshot{
+				ID:        aws.ToString(snapshot.SnapshotId),
+				Region:    svc.Options().Region,
+				VolumeID:  aws.ToString(snapshot.VolumeId),
+				StartTime: aws.ToTime(snapshot.StartTime),
+				Tags:      ConvertToCustomTag(snapshot.Tags),
+			})
+		}
+	}
+
+	images, err := describeImageSnapshots(ctx, svc)
+	if err != nil {
+		return nil, err
+	}
+	for i, snapshot := range listSnapshots {
+		listSnapshots[i].ImageID = images[snapshot.ID]
+	}
+
+	return listSnapshots, nil
+}
+
+// describeImageSnapshots returns the AMIs owned by the account by the snapshots they
+// are registered to.
+func describeImageSnapshots(ctx context.Context, svc *ec2.Client) (map[string]string, error) {
+	images := map[string]string{}
+
+	paginator := ec2.NewDescribeImagesPaginator(svc, &ec2.DescribeImagesInput{
+		Owners: []string{"self"},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe images: %v", err)
+		}
+
+		for _, image := range page.Images {
+			for _, mapping := range image.BlockDeviceMappings {
+				if mapping.Ebs != nil && mapping.Ebs.SnapshotId != nil {
+					images[*mapping.Ebs.SnapshotId] = aws.ToString(image.ImageId)
+				}
+			}
+		}
+	}
+	return images, nil
+}
+
+func DeleteVolume(ctx context.Context, volumeID, region string) error {
+	ec2Client, err := sharedEC2Client(ctx, region)
+	if err != nil {
+		return err
+	}
+
+	_, err = ec2Client.DeleteVolume(ctx, &ec2.DeleteVolumeInput{
+		VolumeId: aws.String(volumeID),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting volume %s: %v", volumeID, err)
+	}
+
+	logger.Info("volume deleted successfully", "volume", volumeID)
+	return nil
+}
+
+// DeleteSnapshot deletes an EBS snapshot. AWS refuses to delete a snapshot used by an AMI.
+func DeleteSnapshot(ctx context.Context, snapshotID, region string) error {
+	ec2Client, err := sharedEC2Client(ctx, region)
+	if err != nil {
+		return err
+	}
+
+	_, err = ec2Client.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{
+		SnapshotId: aws.String(snapshotID),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting snapshot %s: %v", snapshotID, err)
+	}
+
+	logger.Info("snapshot deleted successfully", "snapshot", snapshotID)
+	return nil
+}
@@ -3,23 +3,21 @@ package ec2
 import (
 	"context"
 	"fmt"
-	"log"
 	"log/slog"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/bananaops/cloudoff/internal/resource"
 )
 
 var logger *slog.Logger
 
-type Tag struct {
-	Key   string
-	Value string
-}
+type Tag = resource.Tag
 
 type Instance struct {
 	Spot             bool
@@ -28,21 +26,33 @@ type Instance struct {
 	PrivateIpAddress string
 	InstanceId       string
 	Region           string
+	Account          string
 	State            string
 	LaunchTime       time.Time
 	AttachTime       time.Time
-	Tags             []Tag
+	// StoppedAt is the time a stopped instance was stopped, zero when unknown
+	StoppedAt time.Time
+	Tags      []Tag
+	// Hibernation is true when hibernation was enabled at launch, it can then be hibernated
+	Hibernation bool
+	// SpotRequestID is the spot request which launched the instance
+	SpotRequestID string
+	// SpotRequestType is the type of the spot request of the instance, one-time or persistent
+	SpotRequestType string
+	// SpotFleet is the Spot Fleet or EC2 Fleet which launched the instance
+	SpotFleet string
+	// SpotLaunchTemplate is set on the spot instances terminated by cloudoff, to the launch
+	// template they are launched again from
+	SpotLaunchTemplate string
 }
 
-func DiscoverEC2Instances() []Instance {
+func DiscoverEC2Instances(ctx context.Context) ([]Instance, error) {
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	svc, err := sharedEC2Client(ctx, "")
 	if err != nil {
-		log.Fatalf("unable to load SDK config, %v", err)
+		return nil, err
 	}
 
-	svc := ec2.NewFromConfig(cfg)
-
 	filters := []types.Filter{
 		{
 			Name:   aws.String("instance-state-name"),
@@ -55,49 +65,126 @@ func DiscoverEC2Instances() []Instance {
 		Filters: filters,
 	}
 
-	// Request DescribeInstances
-	result, err := svc.DescribeInstances(context.TODO(), input)
-	if err != nil {
-		log.Fatalf("failed to describe instances, %v", err)
-	}
-
 	var listInstances []Instance
+	var spotRequests []string
+	// live holds every instance returned and the spot instances they were launched again
+	// from, the launch templates of those are not launched again
+	live := map[string]bool{}
+
+	// Describe the instances page by page, an account can run thousands of them
+	paginator := ec2.NewDescribeInstancesPaginator(svc, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe instances, %v", err)
+		}
 
-	// For each instance in the result, get the name and the private IP address
-	for _, reservation := range result.Reservations {
-		for _, instance := range reservation.Instances {
+		// For each instance in the result, get the name and the private IP address
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				live[aws.ToString(instance.InstanceId)] = true
+				for _, tag := range instance.Tags {
+					if aws.ToString(tag.Key) == spotOriginTag {
+						live[aws.ToString(tag.Value)] = true
+					}
+				}
 
-			// Get the name of the instance
-			title := instance.InstanceId
-			for _, tag := range instance.Tags {
-				if *tag.Key == "Name" {
-					title = tag.Value
-					break
+				// Instances launched by an Auto Scaling group are managed through the group
+				if hasTagKey(instance.Tags, autoScalingGroupTag) {
+					continue
+				}
+
+				// EKS and Karpenter nodes are managed through their node group or node pool,
+				// stopping them individually breaks the cluster
+				if isKubernetesNode(instance.Tags) {
+					continue
 				}
-			}
 
-			var spot = false
+				// Get the name of the instance
+				title := instance.InstanceId
+				for _, tag := range instance.Tags {
+					if aws.ToString(tag.Key) == "Name" {
+						title = tag.Value
+						break
+					}
+				}
 
-			if instance.InstanceLifecycle == "spot" {
-				spot = true
+				var stoppedAt time.Time
+				if instance.State.Name == types.InstanceStateNameStopped {
+					stoppedAt, _ = parseStateTransitionTime(aws.ToString(instance.StateTransitionReason))
+				}
+
+				spot := instance.InstanceLifecycle == types.InstanceLifecycleTypeSpot
+				if spot && instance.SpotInstanceRequestId != nil {
+					spotRequests = append(spotRequests, *instance.SpotInstanceRequestId)
+				}
+
+				listInstances = append(listInstances, Instance{
+					Spot:             spot,
+					SpotRequestID:    aws.ToString(instance.SpotInstanceRequestId),
+					SpotFleet:        spotFleet(instance.Tags),
+					Hibernation:      instance.HibernationOptions != nil && aws.ToBool(instance.HibernationOptions.Configured),
+					ID:               *instance.InstanceId,
+					Name:             aws.ToString(title),
+					PrivateIpAddress: aws.ToString(instance.PrivateIpAddress),
+					InstanceId:       *instance.InstanceId,
+					Region:           svc.Options().Region,
+					Account:          aws.ToString(reservation.OwnerId),
+					State:            string(instance.State.Name),
+					Tags:             ConvertToCustomTag(instance.Tags),
+					LaunchTime:       aws.ToTime(instance.LaunchTime),
+					AttachTime:       attachTime(instance),
+					StoppedAt:        stoppedAt,
+				})
 			}
+		}
+	}
 
-			listInstances = append(listInstances, Instance{
-				Spot:             spot,
-				ID:               *instance.InstanceId,
-				Name:             *title,
-				PrivateIpAddress: *instance.PrivateIpAddress,
-				InstanceId:       *instance.InstanceId,
-				Region:           svc.Options().Region,
-				State:            string(instance.State.Name),
-				Tags:             ConvertToCustomTag(instance.Tags),
-				LaunchTime:       *instance.LaunchTime,
-				AttachTime:       *instance.NetworkInterfaces[0].Attachment.AttachTime,
-			})
+	// The type of the spot requests decides whether the spot instances can be stopped
+	requestTypes, err := describeSpotRequestTypes(ctx, svc, spotRequests)
+	if err != nil {
+		return nil, err
+	}
+	for i, instance := range listInstances {
+		if instance.Spot {
+			listInstances[i].SpotRequestType = requestTypes[instance.SpotRequestID]
 		}
 	}
 
-	return listInstances
+	terminated, err := discoverTerminatedSpotInstances(ctx, svc, live)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(listInstances, terminated...), nil
+}
+
+// attachTime returns the attach time of the primary network interface of an instance, or
+// its launch time when it has no attached interface.
+func attachTime(instance types.Instance) time.Time {
+	for _, networkInterface := range instance.NetworkInterfaces {
+		if networkInterface.Attachment != nil && networkInterface.Attachment.AttachTime != nil && aws.ToInt32(networkInterface.Attachment.DeviceIndex) == 0 {
+			return *networkInterface.Attachment.AttachTime
+		}
+	}
+	return aws.ToTime(instance.LaunchTime)
+}
+
+// stateTransitionTime matches the time of a state transition reason
+// (ex. : "User initiated (2025-01-06 19:00:12 GMT)").
+var stateTransitionTime = regexp.MustCompile(`\((\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) GMT\)`)
+
+// parseStateTransitionTime returns the time of the last state transition of an instance.
+func parseStateTransitionTime(reason string) (time.Time, bool) {
+	match := stateTransitionTime.FindStringSubmatch(reason)
+	if match == nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("2006-01-02 15:04:05", match[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
 }
 
 // Function to convert InstanceTag to CustomTag
@@ -114,73 +201,82 @@ func ConvertToCustomTag(instanceTag []types.Tag) []Tag {
 
 }
 
-func StopInstance(instanceID, region string) error {
-	// Load AWS configuration
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
-	if err != nil {
-		return fmt.Errorf("error loading AWS configuration: %v", err)
+// hasTagKey reports whether one of the tags uses the given key
+func hasTagKey(tags []types.Tag, key string) bool {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key {
+			return true
+		}
 	}
+	return false
+}
 
-	// Create an EC2 client
-	ec2Client := ec2.NewFromConfig(cfg)
+// StopInstance stops an instance, or hibernates it. An instance which EC2 refuses to
+// hibernate is stopped normally.
+func StopInstance(ctx context.Context, instanceID, region string, hibernate bool) error {
+	ec2Client, err := sharedEC2Client(ctx, region)
+	if err != nil {
+		return err
+	}
 
 	// Prepare input for StopInstances
 	input := &ec2.StopInstancesInput{
 		InstanceIds: []string{instanceID},
 	}
+	if hibernate {
+		input.Hibernate = aws.Bool(true)
+	}
 
 	// Call StopInstances
-	_, err = ec2Client.StopInstances(context.TODO(), input)
+	_, err = ec2Client.StopInstances(ctx, input)
+	if err != nil && hibernate && isHibernationRefused(err) {
+		logger.Warn("hibernation refused, instance stopped normally", "instance", instanceID, "error", err)
+		input.Hibernate = nil
+		_, err = ec2Client.StopInstances(ctx, input)
+	}
 	if err != nil {
 		return fmt.Errorf("error stopping instance %s: %v", instanceID, err)
 	}
 
-	logger.Info("instance stopped successfully", "instance", instanceID)
+	// The instance is stopping, waitForInstance logs when it is stopped
+	logger.Info("instance stop requested", "instance", instanceID, "hibernate", input.Hibernate != nil)
 	return nil
 }
 
-func StartInstance(instanceID, region string) error {
-	// Load AWS configuration
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+func StartInstance(ctx context.Context, instanceID, region string) error {
+	ec2Client, err := sharedEC2Client(ctx, region)
 	if err != nil {
-		return fmt.Errorf("error loading AWS configuration: %v", err)
+		return err
 	}
 
-	// Create an EC2 client
-	ec2Client := ec2.NewFromConfig(cfg)
-
 	// Prepare input for StartInstances
 	input := &ec2.StartInstancesInput{
 		InstanceIds: []string{instanceID},
 	}
 
 	// Call StartInstances
-	_, err = ec2Client.StartInstances(context.TODO(), input)
+	_, err = ec2Client.StartInstances(ctx, input)
 	if err != nil {
 		return fmt.Errorf("error starting instance %s: %v", instanceID, err)
 	}
 
-	logger.Info("instance started successfully", "instance", instanceID)
+	logger.Info("instance start requested", "instance", instanceID)
 	return nil
 }
 
-func TerminateInstance(instanceID, region string) error {
-	// Load AWS configuration
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+func TerminateInstance(ctx context.Context, instanceID, region string) error {
+	ec2Client, err := sharedEC2Client(ctx, region)
 	if err != nil {
-		return fmt.Errorf("error loading AWS configuration: %v", err)
+		return err
 	}
 
-	// Create an EC2 client
-	ec2Client := ec2.NewFromConfig(cfg)
-
 	// Prepare input for TerminateInstances
 	input := &ec2.TerminateInstancesInput{
 		InstanceIds: []string{instanceID},
 	}
 
 	// Call TerminateInstances
-	_, err = ec2Client.TerminateInstances(context.TODO(), input)
+	_, err = ec2Client.TerminateInstances(ctx, input)
 	if err != nil {
 		return fmt.Errorf("error terminating instance %s: %v", instanceID, err)
 	}
@@ -189,6 +285,18 @@ func TerminateInstance(instanceID, region string) error {
 	return nil
 }
 
+// startFailure returns the reason of a start which failed on the AWS side: the instance
+// is stopped again with a server state reason (ex. : Server.InsufficientInstanceCapacity).
+func startFailure(instance types.Instance) (string, bool) {
+	if instance.State == nil || instance.State.Name != types.InstanceStateNameStopped || instance.StateReason == nil {
+		return "", false
+	}
+	if code := aws.ToString(instance.StateReason.Code); strings.HasPrefix(code, "Server.") {
+		return aws.ToString(instance.StateReason.Message), true
+	}
+	return "", false
+}
+
 func init() {
 	logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	slog.SetDefault(logger)
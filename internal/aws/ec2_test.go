@@ -0,0 +1,248 @@
+package ec2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+func TestParseStateTransitionTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		reason   string
+		expected time.Time
+		ok       bool
+	}{
+		{"User initiated", "User initiated (2025-01-06 19:00:12 GMT)", time.Date(2025, 1, 6, 19, 0, 12, 0, time.UTC), true},
+		{"Spot interruption", "Service initiated (2024-12-24 03:15:00 GMT)", time.Date(2024, 12, 24, 3, 15, 0, 0, time.UTC), true},
+		{"No time", "Server.SpotInstanceTermination: Spot instance termination", time.Time{}, false},
+		{"Empty", "", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, ok := parseStateTransitionTime(tt.reason)
+			if ok != tt.ok || !result.Equal(tt.expected) {
+				t.Errorf("expected %v %v, got %v %v", tt.expected, tt.ok, result, ok)
+			}
+		})
+	}
+}
+
+// describeInstance returns a DescribeInstances response with the state of an instance.
+func describeInstance(state, reasonCode string) string {
+	return `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><reservationSet><item>` +
+		`<reservationId>r-1</reservationId><instancesSet><item><instanceId>i-1</instanceId>` +
+		`<instanceState><name>` + state + `</name></instanceState>` +
+		`<stateReason><code>` + reasonCode + `</code><message>` + reasonCode + `: Insufficient capacity.</message></stateReason>` +
+		`</item></instancesSet></item></reservationSet></DescribeInstancesResponse>`
+}
+
+// useTestWaiter replaces the instance waiter by one polling every 10ms, with a timeout
+// of 100ms.
+func useTestWaiter(t *testing.T) {
+	interval, timeout, previous := waiterInterval, resource.TransitionTimeout, waiter
+	waiterInterval, resource.TransitionTimeout, waiter = 10*time.Millisecond, 100*time.Millisecond, newInstanceWaiter()
+	t.Cleanup(func() {
+		waiter.cancel()
+		waiterInterval, resource.TransitionTimeout, waiter = interval, timeout, previous
+	})
+}
+
+func TestWaitForInstance(t *testing.T) {
+	tests := []struct {
+		name     string
+		action   resource.Capability
+		state    string
+		reason   string
+		pending  bool
+		attempts int
+		stuck    bool
+	}{
+		{name: "Stopped", action: resource.CapabilityStop, state: "stopped", reason: "Client.UserInitiatedShutdown"},
+		{name: "Running", action: resource.CapabilityStart, state: "running"},
+		{name: "Insufficient capacity", action: resource.CapabilityStart, state: "stopped", reason: "Server.InsufficientInstanceCapacity", pending: true, attempts: 1},
+		{name: "Terminated while starting", action: resource.CapabilityStart, state: "terminated", pending: true, attempts: 1},
+		{name: "Still stopping", action: resource.CapabilityStop, state: "stopping", pending: true, stuck: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ResetClients()
+			resource.ResetStore()
+			defer ResetClients()
+			defer resource.ResetStore()
+			useTestWaiter(t)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(describeInstance(tt.state, tt.reason)))
+			}))
+			defer server.Close()
+
+			cfg, err := loadConfig(context.Background(), "eu-west-3",
+				config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("test", "test", "")),
+				config.WithBaseEndpoint(server.URL),
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			clients["ec2/eu-west-3"] = ec2.NewFromConfig(cfg)
+
+			r := resource.Resource{ID: "i-1", Kind: KindInstance, Region: "eu-west-3"}
+			resource.BeginTransition(context.Background(), r, tt.action, time.Now())
+			waitForInstance(r, tt.action)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if !DrainWaiters(ctx) {
+				t.Fatalf("expected the waiter stopped")
+			}
+
+			transition, pending := resource.PendingTransition(context.Background(), r)
+			if pending != tt.pending || transition.Attempts != tt.attempts || transition.Stuck != tt.stuck {
+				t.Errorf("unexpected transition %+v (pending %v)", transition, pending)
+			}
+		})
+	}
+}
+
+func TestWaitForInstancesBatched(t *testing.T) {
+	ResetClients()
+	resource.ResetStore()
+	defer ResetClients()
+	defer resource.ResetStore()
+	useTestWaiter(t)
+
+	// The instances of a region are described together, i-2 is still stopping
+	var mu sync.Mutex
+	var calls [][]string
+	client := newTestEC2Client(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		ids := []string{r.Form.Get("Filter.1.Value.1"), r.Form.Get("Filter.1.Value.2")}
+		mu.Lock()
+		calls = append(calls, ids)
+		mu.Unlock()
+		w.Write([]byte(`<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><reservationSet><item>` +
+			`<reservationId>r-1</reservationId><instancesSet>` +
+			`<item><instanceId>i-1</instanceId><instanceState><name>stopped</name></instanceState></item>` +
+			`<item><instanceId>i-2</instanceId><instanceState><name>stopping</name></instanceState></item>` +
+			`</instancesSet></item></reservationSet></DescribeInstancesResponse>`))
+	})
+	clients["ec2/eu-west-3"] = client
+
+	instances := []resource.Resource{
+		{ID: "i-1", Kind: KindInstance, Region: "eu-west-3"},
+		{ID: "i-2", Kind: KindInstance, Region: "eu-west-3"},
+	}
+	for _, r := range instances {
+		resource.BeginTransition(context.Background(), r, resource.CapabilityStop, time.Now())
+		waitForInstance(r, resource.CapabilityStop)
+	}
+
+	// The shutdown interrupts the waiter before i-2 is stopped
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if DrainWaiters(ctx) {
+		t.Fatalf("expected the waiter interrupted")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) == 0 || calls[0][0] == "" || calls[0][1] == "" {
+		t.Fatalf("expected the instances described together, got %v", calls)
+	}
+	if _, pending := resource.PendingTransition(context.Background(), instances[0]); pending {
+		t.Errorf("expected the stop of i-1 completed")
+	}
+	if transition, pending := resource.PendingTransition(context.Background(), instances[1]); !pending || !transition.InProgress() {
+		t.Errorf("expected the stop of i-2 left in progress, got %+v", transition)
+	}
+}
+
+// instancesPage returns a page of a DescribeInstances response with a running instance.
+func instancesPage(id, nextToken string) string {
+	return `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><reservationSet><item>` +
+		`<reservationId>r-` + id + `</reservationId><ownerId>123456789012</ownerId><instancesSet><item><instanceId>` + id + `</instanceId>` +
+		`<instanceState><name>running</name></instanceState><privateIpAddress>10.0.0.1</privateIpAddress>` +
+		`<launchTime>2025-01-06T08:00:00.000Z</launchTime><networkInterfaceSet><item><attachment>` +
+		`<attachTime>2025-01-06T08:00:00.000Z</attachTime></attachment></item></networkInterfaceSet>` +
+		`</item></instancesSet></item></reservationSet><nextToken>` + nextToken + `</nextToken></DescribeInstancesResponse>`
+}
+
+func TestDiscoverEC2InstancesPages(t *testing.T) {
+	ResetClients()
+	defer ResetClients()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch {
+		case r.Form.Get("Action") == "DescribeLaunchTemplates":
+			w.Write([]byte(`<DescribeLaunchTemplatesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><launchTemplates/></DescribeLaunchTemplatesResponse>`))
+		case r.Form.Get("NextToken") == "":
+			w.Write([]byte(instancesPage("i-1", "page-2")))
+		default:
+			// An instance without private IP nor network interface doesn't stop the discovery
+			w.Write([]byte(`<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><reservationSet><item>` +
+				`<reservationId>r-i-2</reservationId><instancesSet><item><instanceId>i-2</instanceId><instanceState><name>stopped</name></instanceState>` +
+				`<launchTime>2025-01-06T08:00:00.000Z</launchTime></item></instancesSet></item></reservationSet></DescribeInstancesResponse>`))
+		}
+	}))
+	defer server.Close()
+
+	cfg, err := loadConfig(context.Background(), "eu-west-3",
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("test", "test", "")),
+		config.WithBaseEndpoint(server.URL),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clients["ec2/"] = ec2.NewFromConfig(cfg)
+
+	instances, err := DiscoverEC2Instances(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(instances) != 2 || instances[0].ID != "i-1" || instances[1].ID != "i-2" {
+		t.Fatalf("expected the instances of both pages, got %+v", instances)
+	}
+	if instances[1].PrivateIpAddress != "" || !instances[1].AttachTime.Equal(instances[1].LaunchTime) {
+		t.Errorf("expected the launch time without network interface, got %+v", instances[1])
+	}
+}
+
+func TestAttachTime(t *testing.T) {
+	launched := time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC)
+	attached := launched.Add(time.Minute)
+
+	tests := []struct {
+		name     string
+		instance types.Instance
+		expected time.Time
+	}{
+		{"Primary interface", types.Instance{LaunchTime: &launched, NetworkInterfaces: []types.InstanceNetworkInterface{
+			{Attachment: &types.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int32(1), AttachTime: aws.Time(attached.Add(time.Hour))}},
+			{Attachment: &types.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int32(0), AttachTime: &attached}},
+		}}, attached},
+		{"No network interface", types.Instance{LaunchTime: &launched}, launched},
+		{"Detached interface", types.Instance{LaunchTime: &launched, NetworkInterfaces: []types.InstanceNetworkInterface{{}}}, launched},
+		{"No launch time", types.Instance{}, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := attachTime(tt.instance); !result.Equal(tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// SavedDesiredCountTag is the tag used to remember the desired count of an ECS
+// service while it is scaled to zero.
+const SavedDesiredCountTag = "cloudoff:saved-desired-count"
+
+// describeServicesBatch is the maximum number of services accepted by DescribeServices.
+const describeServicesBatch = 10
+
+type Service struct {
+	Name         string
+	Arn          string
+	ClusterArn   string
+	Region       string
+	Status       string
+	DesiredCount int32
+	Daemon       bool
+	Tags         []Tag
+}
+
+// DiscoverServices returns the ECS services of every cluster. Tags set on the cluster
+// apply to all its services, tags set on a service override them.
+func DiscoverServices(ctx context.Context) ([]Service, error) {
+
+	svc, err := sharedECSClient(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var listServices []Service
+
+	clusters := ecs.NewListClustersPaginator(svc, &ecs.ListClustersInput{})
+	for clusters.HasMorePages() {
+		page, err := clusters.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list ecs clusters: %v", err)
+		}
+		if len(page.ClusterArns) == 0 {
+			continue
+		}
+
+		result, err := svc.DescribeClusters(ctx, &ecs.DescribeClustersInput{
+			Clusters: page.ClusterArns,
+			Include:  []ecstypes.ClusterField{ecstypes.ClusterFieldTags},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe ecs clusters: %v", err)
+		}
+
+		for _, cluster := range result.Clusters {
+			services, err := discoverClusterServices(ctx, svc, cluster)
+			if err != nil {
+				return nil, err
+			}
+			listServices = append(listServices, services...)
+		}
+	}
+
+	return listServices, nil
+}
+
+func discoverClusterServices(ctx context.Context, svc *ecs.Client, cluster ecstypes.Cluster) ([]Service, error) {
+	clusterArn := aws.ToString(cluster.ClusterArn)
+	clusterTags := convertECSTags(cluster.Tags)
+
+	var listServices []Service
+
+	paginator := ecs.NewListServicesPaginator(svc, &ecs.ListServicesInput{
+		Cluster: aws.String(clusterArn),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list services of ecs cluster %s: %v", clusterArn, err)
+		}
+
+		for start := 0; start < len(page.ServiceArns); start += describeServicesBatch {
+			end := min(start+describeServicesBatch, len(page.ServiceArns))
+
+			result, err := svc.DescribeServices(ctx, &ecs.DescribeServicesInput{
+				Cluster:  aws.String(clusterArn),
+				Services: page.ServiceArns[start:end],
+				Include:  []ecstypes.ServiceField{ecstypes.ServiceFieldTags},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe services of ecs cluster %s: %v", clusterArn, err)
+			}
+
+			for _, service := range result.Services {
+				listServices = append(listServices, Service{
+					Name:         aws.ToString(service.ServiceName),
+					Arn:          aws.ToString(service.ServiceArn),
+					ClusterArn:   clusterArn,
+					Region:       svc.Options().Region,
+					Status:       aws.ToString(service.Status),
+					DesiredCount: service.DesiredCount,
+					Daemon:       service.SchedulingStrategy == ecstypes.SchedulingStrategyDaemon,
+					Tags:         MergeTags(clusterTags, convertECSTags(service.Tags)),
+				})
+			}
+		}
+	}
+
+	return listServices, nil
+}
+
+func convertECSTags(ecsTags []ecstypes.Tag) []Tag {
+	var customTags []Tag
+	for _, tag := range ecsTags {
+		customTags = append(customTags, Tag{
+			Key:   aws.ToString(tag.Key),
+			Value: aws.ToString(tag.Value),
+		})
+	}
+	return customTags
+}
+
+// MergeTags returns the parent tags overridden by the tags of the resource.
+func MergeTags(parent, resource []Tag) []Tag {
+	merged := append([]Tag{}, resource...)
+	for _, tag := range parent {
+		overridden := false
+		for _, own := range resource {
+			if own.Key == tag.Key {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			merged = append(merged, tag)
+		}
+	}
+	return merged
+}
+
+// SavedDesiredCount returns the desired count recorded on the service before it was scaled down.
+func (s Service) SavedDesiredCount() (int32, bool, error) {
+	for _, tag := range s.Tags {
+		if tag.Key == SavedDesiredCountTag {
+			value, err := strconv.ParseInt(tag.Value, 10, 32)
+			if err != nil || value < 0 {
+				return 0, true, fmt.Errorf("invalid desired count : %s", tag.Value)
+			}
+			return int32(value), true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// ScaleDownService records the desired count of the service in a tag then sets it to zero.
+func ScaleDownService(ctx context.Context, service Service) error {
+	ecsClient, err := sharedECSClient(ctx, service.Region)
+	if err != nil {
+		return err
+	}
+
+	// Save the desired count first so it is never lost if the update fails
+	_, err = ecsClient.TagResource(ctx, &ecs.TagResourceInput{
+		ResourceArn: aws.String(service.Arn),
+		Tags: []ecstypes.Tag{
+			{
+				Key:   aws.String(SavedDesiredCountTag),
+				Value: aws.String(strconv.Itoa(int(service.DesiredCount))),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error saving desired count of service %s: %v", service.Name, err)
+	}
+
+	err = updateDesiredCount(ctx, ecsClient, service, 0)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("service scaled down successfully", "service", service.Name, "cluster", service.ClusterArn, "desiredCount", service.DesiredCount)
+	return nil
+}
+
+// RestoreService sets the service back to the given desired count and removes the saved desired count tag.
+func RestoreService(ctx context.Context, service Service, desiredCount int32) error {
+	ecsClient, err := sharedECSClient(ctx, service.Region)
+	if err != nil {
+		return err
+	}
+
+	err = updateDesiredCount(ctx, ecsClient, service, desiredCount)
+	if err != nil {
+		return err
+	}
+
+	_, err = ecsClient.UntagResource(ctx, &ecs.UntagResourceInput{
+		ResourceArn: aws.String(service.Arn),
+		TagKeys:     []string{SavedDesiredCountTag},
+	})
+	if err != nil {
+		return fmt.Errorf("error removing saved desired count of service %s: %v", service.Name, err)
+	}
+
+	logger.Info("service restored successfully", "service", service.Name, "cluster", service.ClusterArn, "desiredCount", desiredCount)
+	return nil
+}
+
+func updateDesiredCount(ctx context.Context, ecsClient *ecs.Client, service Service, desiredCount int32) error {
+	input := &ecs.UpdateServiceInput{
+		Cluster:      aws.String(service.ClusterArn),
+		Service:      aws.String(service.Arn),
+		DesiredCount: aws.Int32(desiredCount),
+	}
+
+	_, err := ecsClient.UpdateService(ctx, input)
+	if err != nil {
+		return fmt.Errorf("error updating desired count of service %s: %v", service.Name, err)
+	}
+	return nil
+}
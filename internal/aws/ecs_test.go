@@ -0,0 +1,52 @@
+package ec2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeTags(t *testing.T) {
+	parent := []Tag{
+		{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"},
+		{Key: "team", Value: "platform"},
+	}
+	resource := []Tag{
+		{Key: "cloudoff:uptime", Value: "Mon-Fri 09:00-18:00"},
+	}
+
+	expected := []Tag{
+		{Key: "cloudoff:uptime", Value: "Mon-Fri 09:00-18:00"},
+		{Key: "team", Value: "platform"},
+	}
+
+	result := MergeTags(parent, resource)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
+
+func TestSavedDesiredCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     []Tag
+		expected int32
+		found    bool
+		hasError bool
+	}{
+		{"Saved count", []Tag{{Key: SavedDesiredCountTag, Value: "3"}}, 3, true, false},
+		{"No saved count", []Tag{{Key: "team", Value: "platform"}}, 0, false, false},
+		{"Invalid saved count", []Tag{{Key: SavedDesiredCountTag, Value: "three"}}, 0, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, found, err := Service{Tags: tt.tags}.SavedDesiredCount()
+			if (err != nil) != tt.hasError {
+				t.Errorf("error = %v, hasError %v", err, tt.hasError)
+			}
+			if count != tt.expected || found != tt.found {
+				t.Errorf("expected (%d, %v), got (%d, %v)", tt.expected, tt.found, count, found)
+			}
+		})
+	}
+}
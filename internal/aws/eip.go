@@ -0,0 +1,118 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// UnassociatedSinceTag records when cloudoff first saw an Elastic IP without association,
+// as AWS doesn't expose the allocation time of an address.
+const UnassociatedSinceTag = "cloudoff:unassociated-since"
+
+type Address struct {
+	AllocationID string
+	PublicIP     string
+	Region       string
+	Associated   bool
+	Tags         []Tag
+}
+
+// DiscoverAddresses returns the Elastic IPs of the account.
+func DiscoverAddresses(ctx context.Context) ([]Address, error) {
+
+	svc, err := sharedEC2Client(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := svc.DescribeAddresses(ctx, &ec2.DescribeAddressesInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe addresses: %v", err)
+	}
+
+	var listAddresses []Address
+
+	for _, address := range result.Addresses {
+		listAddresses = append(listAddresses, Address{
+			AllocationID: aws.ToString(address.AllocationId),
+			PublicIP:     aws.ToString(address.PublicIp),
+			Region:       svc.Options().Region,
+			Associated:   aws.ToString(address.AssociationId) != "",
+			Tags:         ConvertToCustomTag(address.Tags),
+		})
+	}
+
+	return listAddresses, nil
+}
+
+// UnassociatedSince returns the time recorded in the cloudoff:unassociated-since tag.
+func (a Address) UnassociatedSince() (time.Time, bool) {
+	for _, tag := range a.Tags {
+		if tag.Key == UnassociatedSinceTag {
+			since, err := time.Parse(time.RFC3339, tag.Value)
+			if err != nil {
+				return time.Time{}, false
+			}
+			return since, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// MarkAddressUnassociated sets the cloudoff:unassociated-since tag to the given time.
+func MarkAddressUnassociated(ctx context.Context, address Address, since time.Time) error {
+	ec2Client, err := sharedEC2Client(ctx, address.Region)
+	if err != nil {
+		return err
+	}
+
+	_, err = ec2Client.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{address.AllocationID},
+		Tags: []types.Tag{
+			{Key: aws.String(UnassociatedSinceTag), Value: aws.String(since.UTC().Format(time.RFC3339))},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error tagging address %s: %v", address.AllocationID, err)
+	}
+	return nil
+}
+
+// UnmarkAddressUnassociated removes the cloudoff:unassociated-since tag.
+func UnmarkAddressUnassociated(ctx context.Context, address Address) error {
+	ec2Client, err := sharedEC2Client(ctx, address.Region)
+	if err != nil {
+		return err
+	}
+
+	_, err = ec2Client.DeleteTags(ctx, &ec2.DeleteTagsInput{
+		Resources: []string{address.AllocationID},
+		Tags:      []types.Tag{{Key: aws.String(UnassociatedSinceTag)}},
+	})
+	if err != nil {
+		return fmt.Errorf("error untagging address %s: %v", address.AllocationID, err)
+	}
+	return nil
+}
+
+func ReleaseAddress(ctx context.Context, allocationID, region string) error {
+	ec2Client, err := sharedEC2Client(ctx, region)
+	if err != nil {
+		return err
+	}
+
+	_, err = ec2Client.ReleaseAddress(ctx, &ec2.ReleaseAddressInput{
+		AllocationId: aws.String(allocationID),
+	})
+	if err != nil {
+		return fmt.Errorf("error releasing address %s: %v", allocationID, err)
+	}
+
+	logger.Info("address released successfully", "address", allocationID)
+	return nil
+}
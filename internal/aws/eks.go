@@ -0,0 +1,183 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+)
+
+// nodegroupTag is set by EKS on every instance of a managed node group.
+const nodegroupTag = "eks:nodegroup-name"
+
+// karpenterTagPrefix prefixes the tags set by Karpenter on the instances it launches.
+const karpenterTagPrefix = "karpenter.sh/"
+
+type Nodegroup struct {
+	Name        string
+	ClusterName string
+	Arn         string
+	Region      string
+	Status      string
+	Capacity    Capacity
+	Tags        []Tag
+}
+
+// DiscoverNodegroups returns the managed node groups of every EKS cluster.
+func DiscoverNodegroups(ctx context.Context) ([]Nodegroup, error) {
+
+	svc, err := sharedEKSClient(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var listNodegroups []Nodegroup
+
+	clusters := eks.NewListClustersPaginator(svc, &eks.ListClustersInput{})
+	for clusters.HasMorePages() {
+		page, err := clusters.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list eks clusters: %v", err)
+		}
+
+		for _, clusterName := range page.Clusters {
+			nodegroups := eks.NewListNodegroupsPaginator(svc, &eks.ListNodegroupsInput{
+				ClusterName: aws.String(clusterName),
+			})
+			for nodegroups.HasMorePages() {
+				ngPage, err := nodegroups.NextPage(ctx)
+				if err != nil {
+					return nil, fmt.Errorf("failed to list node groups of eks cluster %s: %v", clusterName, err)
+				}
+
+				for _, name := range ngPage.Nodegroups {
+					result, err := svc.DescribeNodegroup(ctx, &eks.DescribeNodegroupInput{
+						ClusterName:   aws.String(clusterName),
+						NodegroupName: aws.String(name),
+					})
+					if err != nil {
+						return nil, fmt.Errorf("failed to describe node group %s of eks cluster %s: %v", name, clusterName, err)
+					}
+
+					listNodegroups = append(listNodegroups, convertNodegroup(result.Nodegroup, svc.Options().Region))
+				}
+			}
+		}
+	}
+
+	return listNodegroups, nil
+}
+
+func convertNodegroup(nodegroup *ekstypes.Nodegroup, region string) Nodegroup {
+	var tags []Tag
+	for key, value := range nodegroup.Tags {
+		tags = append(tags, Tag{Key: key, Value: value})
+	}
+
+	var capacity Capacity
+	if nodegroup.ScalingConfig != nil {
+		capacity = Capacity{
+			MinSize:         aws.ToInt32(nodegroup.ScalingConfig.MinSize),
+			MaxSize:         aws.ToInt32(nodegroup.ScalingConfig.MaxSize),
+			DesiredCapacity: aws.ToInt32(nodegroup.ScalingConfig.DesiredSize),
+		}
+	}
+
+	return Nodegroup{
+		Name:        aws.ToString(nodegroup.NodegroupName),
+		ClusterName: aws.ToString(nodegroup.ClusterName),
+		Arn:         aws.ToString(nodegroup.NodegroupArn),
+		Region:      region,
+		Status:      string(nodegroup.Status),
+		Capacity:    capacity,
+		Tags:        tags,
+	}
+}
+
+// SavedCapacity returns the capacity recorded on the node group before it was scaled down.
+func (n Nodegroup) SavedCapacity() (Capacity, bool, error) {
+	return savedCapacity(n.Tags)
+}
+
+// ScaleDownNodegroup records the current scaling configuration in a tag then sets
+// min and desired size to zero. The max size is kept as EKS requires it to be at least 1.
+func ScaleDownNodegroup(ctx context.Context, nodegroup Nodegroup) error {
+	eksClient, err := sharedEKSClient(ctx, nodegroup.Region)
+	if err != nil {
+		return err
+	}
+
+	// Save the capacity first so it is never lost if the update fails
+	_, err = eksClient.TagResource(ctx, &eks.TagResourceInput{
+		ResourceArn: aws.String(nodegroup.Arn),
+		Tags:        map[string]string{SavedCapacityTag: FormatCapacity(nodegroup.Capacity)},
+	})
+	if err != nil {
+		return fmt.Errorf("error saving capacity of node group %s: %v", nodegroup.Name, err)
+	}
+
+	err = updateScalingConfig(ctx, eksClient, nodegroup, Capacity{MaxSize: max(nodegroup.Capacity.MaxSize, 1)})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("node group scaled down successfully", "cluster", nodegroup.ClusterName, "nodegroup", nodegroup.Name, "capacity", FormatCapacity(nodegroup.Capacity))
+	return nil
+}
+
+// RestoreNodegroup sets the node group back to the given capacity and removes the saved capacity tag.
+func RestoreNodegroup(ctx context.Context, nodegroup Nodegroup, capacity Capacity) error {
+	eksClient, err := sharedEKSClient(ctx, nodegroup.Region)
+	if err != nil {
+		return err
+	}
+
+	err = updateScalingConfig(ctx, eksClient, nodegroup, capacity)
+	if err != nil {
+		return err
+	}
+
+	_, err = eksClient.UntagResource(ctx, &eks.UntagResourceInput{
+		ResourceArn: aws.String(nodegroup.Arn),
+		TagKeys:     []string{SavedCapacityTag},
+	})
+	if err != nil {
+		return fmt.Errorf("error removing saved capacity of node group %s: %v", nodegroup.Name, err)
+	}
+
+	logger.Info("node group restored successfully", "cluster", nodegroup.ClusterName, "nodegroup", nodegroup.Name, "capacity", FormatCapacity(capacity))
+	return nil
+}
+
+func updateScalingConfig(ctx context.Context, eksClient *eks.Client, nodegroup Nodegroup, capacity Capacity) error {
+	input := &eks.UpdateNodegroupConfigInput{
+		ClusterName:   aws.String(nodegroup.ClusterName),
+		NodegroupName: aws.String(nodegroup.Name),
+		ScalingConfig: &ekstypes.NodegroupScalingConfig{
+			MinSize:     aws.Int32(capacity.MinSize),
+			MaxSize:     aws.Int32(capacity.MaxSize),
+			DesiredSize: aws.Int32(capacity.DesiredCapacity),
+		},
+	}
+
+	_, err := eksClient.UpdateNodegroupConfig(ctx, input)
+	if err != nil {
+		return fmt.Errorf("error updating scaling config of node group %s: %v", nodegroup.Name, err)
+	}
+	return nil
+}
+
+// isKubernetesNode reports whether the instance belongs to an EKS managed node group or was launched by Karpenter
+func isKubernetesNode(tags []types.Tag) bool {
+	for _, tag := range tags {
+		key := aws.ToString(tag.Key)
+		if key == nodegroupTag || strings.HasPrefix(key, karpenterTagPrefix) {
+			return true
+		}
+	}
+	return false
+}
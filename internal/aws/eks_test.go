@@ -0,0 +1,30 @@
+package ec2
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestIsKubernetesNode(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     []types.Tag
+		expected bool
+	}{
+		{"Managed node group", []types.Tag{{Key: aws.String("eks:nodegroup-name"), Value: aws.String("default")}}, true},
+		{"Karpenter node", []types.Tag{{Key: aws.String("karpenter.sh/nodepool"), Value: aws.String("default")}}, true},
+		{"Standalone instance", []types.Tag{{Key: aws.String("Name"), Value: aws.String("web")}}, false},
+		{"No tags", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := isKubernetesNode(tt.tags)
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
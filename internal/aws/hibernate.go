@@ -0,0 +1,49 @@
+package ec2
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/aws/smithy-go"
+)
+
+// StopModeTag set to "hibernate" hibernates an instance instead of stopping it, the
+// STOP_MODE environment variable sets the mode of the instances without this tag.
+const StopModeTag = "cloudoff:stop-mode"
+
+// Stop modes of the instances
+const (
+	StopModeStop      = "stop"
+	StopModeHibernate = "hibernate"
+)
+
+// Hibernate reports whether the instance is hibernated when it is stopped. Hibernation
+// must be enabled at launch, the other instances fall back to a normal stop.
+func (i Instance) Hibernate() bool {
+	mode, ok := tagValue(i.Tags, StopModeTag)
+	if !ok {
+		mode = os.Getenv("STOP_MODE")
+	}
+
+	switch mode {
+	case "", StopModeStop:
+		return false
+	case StopModeHibernate:
+		if !i.Hibernation {
+			logger.Warn("hibernation not configured, instance stopped normally", "instance", i.ID)
+			return false
+		}
+		return true
+	default:
+		logger.Warn("invalid stop mode, instance stopped normally", "instance", i.ID, "mode", mode)
+		return false
+	}
+}
+
+// isHibernationRefused reports whether EC2 refused to hibernate an instance, which can
+// still be stopped normally.
+func isHibernationRefused(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && strings.HasPrefix(apiErr.ErrorCode(), "UnsupportedHibernation")
+}
@@ -0,0 +1,76 @@
+package ec2
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+func TestHibernate(t *testing.T) {
+	hibernateTag := []Tag{{Key: StopModeTag, Value: "hibernate"}}
+
+	tests := []struct {
+		name     string
+		instance Instance
+		stopMode string
+		expected bool
+	}{
+		{"Default", Instance{Hibernation: true}, "", false},
+		{"Tag", Instance{Hibernation: true, Tags: hibernateTag}, "", true},
+		{"Global setting", Instance{Hibernation: true}, "hibernate", true},
+		{"Tag overrides global setting", Instance{Hibernation: true, Tags: []Tag{{Key: StopModeTag, Value: "stop"}}}, "hibernate", false},
+		{"Hibernation not configured", Instance{Tags: hibernateTag}, "", false},
+		{"Invalid mode", Instance{Hibernation: true, Tags: []Tag{{Key: StopModeTag, Value: "sleep"}}}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("STOP_MODE", tt.stopMode)
+			if hibernate := tt.instance.Hibernate(); hibernate != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, hibernate)
+			}
+		})
+	}
+}
+
+func TestStopInstanceHibernationRefused(t *testing.T) {
+	ResetClients()
+	defer ResetClients()
+
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, string(body))
+		if strings.Contains(string(body), "Hibernate=true") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`<Response><Errors><Error><Code>UnsupportedHibernationConfiguration</Code><Message>Hibernation not ready.</Message></Error></Errors><RequestID>1</RequestID></Response>`))
+			return
+		}
+		w.Write([]byte(`<StopInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>2</requestId><instancesSet/></StopInstancesResponse>`))
+	}))
+	defer server.Close()
+
+	cfg, err := loadConfig(context.Background(), "eu-west-3",
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("test", "test", "")),
+		config.WithBaseEndpoint(server.URL),
+		config.WithRetryMaxAttempts(1),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clients["ec2/eu-west-3"] = ec2.NewFromConfig(cfg)
+
+	if err := StopInstance(context.Background(), "i-1", "eu-west-3", true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(requests) != 2 || strings.Contains(requests[1], "Hibernate") {
+		t.Errorf("expected a normal stop after the refused hibernation, got %v", requests)
+	}
+}
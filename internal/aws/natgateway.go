@@ -0,0 +1,318 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Tags recording what is needed to recreate a NAT gateway deleted by cloudoff. They are
+// set on the Elastic IP and on the route tables, which survive the NAT gateway.
+const (
+	NatSubnetTag       = "cloudoff:nat-subnet"
+	natTagPrefix       = "cloudoff:nat-tag:"
+	natRoutesTagPrefix = "cloudoff:nat-routes:"
+)
+
+type NatGateway struct {
+	ID                 string
+	Region             string
+	State              string
+	SubnetID           string
+	AllocationID       string
+	Private            bool
+	SecondaryAddresses bool
+	Tags               []Tag
+}
+
+type Route struct {
+	RouteTableID         string
+	DestinationCidrBlock string
+}
+
+// DiscoverNatGateways returns the pending and available NAT gateways.
+func DiscoverNatGateways(ctx context.Context) ([]NatGateway, error) {
+
+	svc, err := sharedEC2Client(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var listNatGateways []NatGateway
+
+	paginator := ec2.NewDescribeNatGatewaysPaginator(svc, &ec2.DescribeNatGatewaysInput{
+		Filter: []types.Filter{
+			{
+				Name:   aws.String("state"),
+				Values: []string{"pending", "available"},
+			},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe nat gateways: %v", err)
+		}
+
+		for _, natGateway := range page.NatGateways {
+			var allocationID string
+			secondary := false
+			for _, address := range natGateway.NatGatewayAddresses {
+				if aws.ToBool(address.IsPrimary) {
+					allocationID = aws.ToString(address.AllocationId)
+				} else {
+					secondary = true
+				}
+			}
+
+			listNatGateways = append(listNatGateways, NatGateway{
+				ID:                 aws.ToString(natGateway.NatGatewayId),
+				Region:             svc.Options().Region,
+				State:              string(natGateway.State),
+				SubnetID:           aws.ToString(natGateway.SubnetId),
+				AllocationID:       allocationID,
+				Private:            natGateway.ConnectivityType == types.ConnectivityTypePrivate,
+				SecondaryAddresses: secondary,
+				Tags:               ConvertToCustomTag(natGateway.Tags),
+			})
+		}
+	}
+
+	return listNatGateways, nil
+}
+
+// NatGatewayRoutes returns the IPv4 routes targeting the NAT gateway.
+func NatGatewayRoutes(ctx context.Context, natGateway NatGateway) ([]Route, error) {
+	return describeRoutes(ctx, natGateway.Region, types.Filter{
+		Name:   aws.String("route.nat-gateway-id"),
+		Values: []string{natGateway.ID},
+	}, func(table types.RouteTable) []string {
+		var cidrs []string
+		for _, route := range table.Routes {
+			if aws.ToString(route.NatGatewayId) == natGateway.ID && aws.ToString(route.DestinationCidrBlock) != "" {
+				cidrs = append(cidrs, aws.ToString(route.DestinationCidrBlock))
+			}
+		}
+		return cidrs
+	})
+}
+
+// SavedNatGatewayRoutes returns the routes recorded on the route tables for the NAT
+// gateway that was using the Elastic IP.
+func SavedNatGatewayRoutes(ctx context.Context, address Address) ([]Route, error) {
+	key := natRoutesTagPrefix + address.AllocationID
+	return describeRoutes(ctx, address.Region, types.Filter{
+		Name:   aws.String("tag-key"),
+		Values: []string{key},
+	}, func(table types.RouteTable) []string {
+		for _, tag := range table.Tags {
+			if aws.ToString(tag.Key) == key {
+				return strings.Fields(aws.ToString(tag.Value))
+			}
+		}
+		return nil
+	})
+}
+
+func describeRoutes(ctx context.Context, region string, filter types.Filter, cidrs func(types.RouteTable) []string) ([]Route, error) {
+	ec2Client, err := sharedEC2Client(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []Route
+
+	paginator := ec2.NewDescribeRouteTablesPaginator(ec2Client, &ec2.DescribeRouteTablesInput{
+		Filters: []types.Filter{filter},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe route tables: %v", err)
+		}
+
+		for _, table := range page.RouteTables {
+			for _, cidr := range cidrs(table) {
+				routes = append(routes, Route{
+					RouteTableID:         aws.ToString(table.RouteTableId),
+					DestinationCidrBlock: cidr,
+				})
+			}
+		}
+	}
+
+	return routes, nil
+}
+
+// ReservedNatGateway returns the subnet and the tags of the NAT gateway deleted by
+// cloudoff that was using the Elastic IP.
+func (a Address) ReservedNatGateway() (string, []Tag, bool) {
+	var subnetID string
+	var tags []Tag
+	for _, tag := range a.Tags {
+		if tag.Key == NatSubnetTag {
+			subnetID = tag.Value
+		}
+		if strings.HasPrefix(tag.Key, natTagPrefix) {
+			tags = append(tags, Tag{Key: strings.TrimPrefix(tag.Key, natTagPrefix), Value: tag.Value})
+		}
+	}
+	return subnetID, tags, subnetID != ""
+}
+
+// DeleteNatGateway records the subnet, the tags and the routes of the NAT gateway on
+// its Elastic IP and route tables, then deletes it. The routes become blackholes until
+// the NAT gateway is recreated.
+func DeleteNatGateway(ctx context.Context, natGateway NatGateway, routes []Route) error {
+	ec2Client, err := sharedEC2Client(ctx, natGateway.Region)
+	if err != nil {
+		return err
+	}
+
+	// Save the recreation metadata first so it is never lost if the deletion fails
+	addressTags := []types.Tag{
+		{Key: aws.String(NatSubnetTag), Value: aws.String(natGateway.SubnetID)},
+	}
+	for _, tag := range natGateway.Tags {
+		// Tags reserved by AWS can't be set on the recreated NAT gateway
+		if strings.HasPrefix(tag.Key, "aws:") {
+			continue
+		}
+		addressTags = append(addressTags, types.Tag{Key: aws.String(natTagPrefix + tag.Key), Value: aws.String(tag.Value)})
+	}
+	_, err = ec2Client.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{natGateway.AllocationID},
+		Tags:      addressTags,
+	})
+	if err != nil {
+		return fmt.Errorf("error saving nat gateway %s on address %s: %v", natGateway.ID, natGateway.AllocationID, err)
+	}
+
+	for routeTableID, cidrs := range groupRoutes(routes) {
+		_, err = ec2Client.CreateTags(ctx, &ec2.CreateTagsInput{
+			Resources: []string{routeTableID},
+			Tags: []types.Tag{
+				{Key: aws.String(natRoutesTagPrefix + natGateway.AllocationID), Value: aws.String(strings.Join(cidrs, " "))},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("error saving routes of nat gateway %s on route table %s: %v", natGateway.ID, routeTableID, err)
+		}
+	}
+
+	_, err = ec2Client.DeleteNatGateway(ctx, &ec2.DeleteNatGatewayInput{
+		NatGatewayId: aws.String(natGateway.ID),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting nat gateway %s: %v", natGateway.ID, err)
+	}
+
+	logger.Info("nat gateway deleted successfully", "natgateway", natGateway.ID, "subnet", natGateway.SubnetID, "address", natGateway.AllocationID)
+	return nil
+}
+
+// CreateNatGateway recreates the NAT gateway recorded on the Elastic IP.
+func CreateNatGateway(ctx context.Context, address Address) (string, error) {
+	subnetID, tags, ok := address.ReservedNatGateway()
+	if !ok {
+		return "", fmt.Errorf("no nat gateway recorded on address %s", address.AllocationID)
+	}
+
+	ec2Client, err := sharedEC2Client(ctx, address.Region)
+	if err != nil {
+		return "", err
+	}
+
+	var natTags []types.Tag
+	for _, tag := range tags {
+		natTags = append(natTags, types.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)})
+	}
+
+	input := &ec2.CreateNatGatewayInput{
+		SubnetId:     aws.String(subnetID),
+		AllocationId: aws.String(address.AllocationID),
+	}
+	if len(natTags) > 0 {
+		input.TagSpecifications = []types.TagSpecification{
+			{ResourceType: types.ResourceTypeNatgateway, Tags: natTags},
+		}
+	}
+
+	result, err := ec2Client.CreateNatGateway(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("error creating nat gateway in subnet %s: %v", subnetID, err)
+	}
+
+	natGatewayID := aws.ToString(result.NatGateway.NatGatewayId)
+	logger.Info("nat gateway created successfully", "natgateway", natGatewayID, "subnet", subnetID, "address", address.AllocationID)
+	return natGatewayID, nil
+}
+
+// RestoreNatGatewayRoutes points the saved routes to the recreated NAT gateway, then
+// removes the recreation metadata from the route tables and the Elastic IP.
+func RestoreNatGatewayRoutes(ctx context.Context, natGateway NatGateway, address Address, routes []Route) error {
+	ec2Client, err := sharedEC2Client(ctx, natGateway.Region)
+	if err != nil {
+		return err
+	}
+
+	for _, route := range routes {
+		// The route is kept as a blackhole when the NAT gateway is deleted, but may
+		// have been removed since
+		_, err := ec2Client.ReplaceRoute(ctx, &ec2.ReplaceRouteInput{
+			RouteTableId:         aws.String(route.RouteTableID),
+			DestinationCidrBlock: aws.String(route.DestinationCidrBlock),
+			NatGatewayId:         aws.String(natGateway.ID),
+		})
+		if err != nil {
+			_, err = ec2Client.CreateRoute(ctx, &ec2.CreateRouteInput{
+				RouteTableId:         aws.String(route.RouteTableID),
+				DestinationCidrBlock: aws.String(route.DestinationCidrBlock),
+				NatGatewayId:         aws.String(natGateway.ID),
+			})
+			if err != nil {
+				return fmt.Errorf("error restoring route %s in route table %s: %v", route.DestinationCidrBlock, route.RouteTableID, err)
+			}
+		}
+	}
+
+	for routeTableID := range groupRoutes(routes) {
+		_, err = ec2Client.DeleteTags(ctx, &ec2.DeleteTagsInput{
+			Resources: []string{routeTableID},
+			Tags:      []types.Tag{{Key: aws.String(natRoutesTagPrefix + address.AllocationID)}},
+		})
+		if err != nil {
+			return fmt.Errorf("error removing saved routes from route table %s: %v", routeTableID, err)
+		}
+	}
+
+	addressTags := []types.Tag{{Key: aws.String(NatSubnetTag)}}
+	for _, tag := range address.Tags {
+		if strings.HasPrefix(tag.Key, natTagPrefix) {
+			addressTags = append(addressTags, types.Tag{Key: aws.String(tag.Key)})
+		}
+	}
+	_, err = ec2Client.DeleteTags(ctx, &ec2.DeleteTagsInput{
+		Resources: []string{address.AllocationID},
+		Tags:      addressTags,
+	})
+	if err != nil {
+		return fmt.Errorf("error removing saved nat gateway from address %s: %v", address.AllocationID, err)
+	}
+
+	logger.Info("nat gateway routes restored successfully", "natgateway", natGateway.ID, "routes", len(routes))
+	return nil
+}
+
+// groupRoutes returns the destination CIDR blocks of the routes by route table.
+func groupRoutes(routes []Route) map[string][]string {
+	grouped := map[string][]string{}
+	for _, route := range routes {
+		grouped[route.RouteTableID] = append(grouped[route.RouteTableID], route.DestinationCidrBlock)
+	}
+	return grouped
+}
@@ -0,0 +1,35 @@
+package ec2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReservedNatGateway(t *testing.T) {
+	address := Address{
+		AllocationID: "eipalloc-1",
+		Tags: []Tag{
+			{Key: NatSubnetTag, Value: "subnet-1"},
+			{Key: "cloudoff:nat-tag:Name", Value: "dev-nat"},
+			{Key: "cloudoff:nat-tag:cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"},
+			{Key: "team", Value: "platform"},
+		},
+	}
+
+	subnetID, tags, ok := address.ReservedNatGateway()
+	if !ok || subnetID != "subnet-1" {
+		t.Fatalf("expected subnet-1, got %q (%v)", subnetID, ok)
+	}
+
+	expected := []Tag{
+		{Key: "Name", Value: "dev-nat"},
+		{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"},
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected %v, got %v", expected, tags)
+	}
+
+	if _, _, ok := (Address{AllocationID: "eipalloc-2"}).ReservedNatGateway(); ok {
+		t.Errorf("expected address without nat gateway")
+	}
+}
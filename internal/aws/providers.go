@@ -0,0 +1,480 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+// Kinds of the resources discovered by the AWS providers
+const (
+	KindInstance         = "ec2-instance"
+	KindAutoScalingGroup = "autoscaling-group"
+	KindNodegroup        = "eks-nodegroup"
+	KindService          = "ecs-service"
+	KindDBInstance       = "rds-instance"
+	KindDBCluster        = "rds-cluster"
+)
+
+// Kinds of the AWS resources handled outside of the providers
+const (
+	KindVolume     = "ebs-volume"
+	KindSnapshot   = "ebs-snapshot"
+	KindAddress    = "elastic-ip"
+	KindNatGateway = "nat-gateway"
+)
+
+// Providers returns the providers of every AWS resource kind.
+func Providers() []resource.Provider {
+	return []resource.Provider{
+		InstanceProvider{},
+		AutoScalingGroupProvider{},
+		NodegroupProvider{},
+		ServiceProvider{},
+		DBInstanceProvider{},
+		DBClusterProvider{},
+	}
+}
+
+// accountFromARN returns the account ID of an ARN, or an empty string when it can't be parsed.
+func accountFromARN(value string) string {
+	parsed, err := arn.Parse(value)
+	if err != nil {
+		return ""
+	}
+	return parsed.AccountID
+}
+
+// InstanceProvider manages EC2 instances.
+type InstanceProvider struct{}
+
+func (InstanceProvider) Kind() string { return KindInstance }
+
+func (InstanceProvider) Discover(ctx context.Context) ([]resource.Resource, error) {
+	instances, err := DiscoverEC2Instances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	spotInstances.Reset()
+	var resources []resource.Resource
+	for _, instance := range instances {
+		state := resource.StateUnknown
+		switch instance.State {
+		case "running":
+			state = resource.StateRunning
+		case "stopped":
+			state = resource.StateStopped
+		}
+
+		mode, reason := instance.SpotMode()
+		if instance.Spot {
+			spotInstances.WithLabelValues(string(mode)).Inc()
+		}
+		if mode == SpotModeUnsupported {
+			state = resource.StateUnknown
+			if isScheduled(instance.Tags) {
+				logger.Warn("schedule ignored, spot instance can't be stopped", "instance", instance.ID, "reason", reason)
+			}
+		}
+
+		resources = append(resources, resource.Resource{
+			ID:       instance.ID,
+			Kind:     KindInstance,
+			Region:   instance.Region,
+			Account:  instance.Account,
+			Tags:     instance.Tags,
+			State:    state,
+			Warning:  reason,
+			TTLStart: instance.TTLStart(),
+			// The spot instances terminated by cloudoff are launched again even without schedule
+			StoppedByCloudoff: instance.SpotLaunchTemplate != "",
+			Object:            instance,
+		})
+	}
+	return resources, nil
+}
+
+// Stop stops or hibernates an instance and waits in the background until it is stopped.
+// The one-time spot instances tagged cloudoff:spot=recreate are terminated instead, the
+// other spot instances which can't be stopped are refused with the reason.
+func (InstanceProvider) Stop(ctx context.Context, r resource.Resource) error {
+	hibernate := false
+	if instance, ok := r.Object.(Instance); ok {
+		switch mode, reason := instance.SpotMode(); mode {
+		case SpotModeRecreate:
+			return TerminateSpotInstance(ctx, instance)
+		case SpotModeUnsupported:
+			return fmt.Errorf("error stopping instance %s: %s", r.ID, reason)
+		}
+		hibernate = instance.Hibernate()
+	}
+
+	if err := StopInstance(ctx, r.ID, r.Region, hibernate); err != nil {
+		return err
+	}
+	resource.BeginTransition(ctx, r, resource.CapabilityStop, time.Now())
+	waitForInstance(r, resource.CapabilityStop)
+	return nil
+}
+
+// Start starts an instance and waits in the background until it is running, or launches
+// again a spot instance terminated by cloudoff. Failed starts are retried later by the
+// scheduler, the spot instances which can't be stopped are refused with the reason.
+func (InstanceProvider) Start(ctx context.Context, r resource.Resource) error {
+	if instance, ok := r.Object.(Instance); ok {
+		mode, reason := instance.SpotMode()
+		if mode == SpotModeUnsupported {
+			return fmt.Errorf("error starting instance %s: %s", r.ID, reason)
+		}
+		if instance.SpotLaunchTemplate != "" {
+			if _, err := RecreateSpotInstance(ctx, instance); err != nil {
+				resource.FailTransition(ctx, r, resource.CapabilityStart, err, time.Now())
+				return err
+			}
+			// The new instance has a new ID, there is no transition to wait for
+			resource.CompleteTransition(ctx, r)
+			return nil
+		}
+	}
+
+	if err := StartInstance(ctx, r.ID, r.Region); err != nil {
+		resource.FailTransition(ctx, r, resource.CapabilityStart, err, time.Now())
+		return err
+	}
+	resource.BeginTransition(ctx, r, resource.CapabilityStart, time.Now())
+	waitForInstance(r, resource.CapabilityStart)
+	return nil
+}
+
+func (InstanceProvider) Delete(ctx context.Context, r resource.Resource) error {
+	if instance, ok := r.Object.(Instance); ok && instance.SpotLaunchTemplate != "" {
+		return DeleteSpotLaunchTemplate(ctx, instance)
+	}
+	return TerminateInstance(ctx, r.ID, r.Region)
+}
+
+// isScheduled reports whether the resource carries a cloudoff:uptime or cloudoff:downtime tag.
+func isScheduled(tags []Tag) bool {
+	for _, tag := range tags {
+		if tag.Key == "cloudoff:uptime" || tag.Key == "cloudoff:downtime" {
+			return true
+		}
+	}
+	return false
+}
+
+// AutoScalingGroupProvider manages Auto Scaling groups, stopped by scaling them to zero.
+type AutoScalingGroupProvider struct{}
+
+func (AutoScalingGroupProvider) Kind() string { return KindAutoScalingGroup }
+
+func (AutoScalingGroupProvider) Discover(ctx context.Context) ([]resource.Resource, error) {
+	groups, err := DiscoverAutoScalingGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []resource.Resource
+	for _, group := range groups {
+		_, hasSaved, err := group.SavedCapacity()
+		if err != nil {
+			logger.Error("error reading saved capacity", "autoscalinggroup", group.Name, "error", err)
+			continue
+		}
+
+		r := resource.Resource{
+			ID:      group.Name,
+			Kind:    KindAutoScalingGroup,
+			Region:  group.Region,
+			Account: accountFromARN(group.Arn),
+			Tags:    group.Tags,
+			Object:  group,
+		}
+		if !hasSaved && group.Capacity.IsScaledDown() {
+			_, hasSaved = storedCapacity(ctx, r)
+		}
+		r.State = capacityState(group.Capacity, hasSaved)
+		r.StoppedByCloudoff = hasSaved
+		resources = append(resources, r)
+	}
+	return resources, nil
+}
+
+func (AutoScalingGroupProvider) Stop(ctx context.Context, r resource.Resource) error {
+	group := r.Object.(AutoScalingGroup)
+	resource.SaveOriginalCapacity(ctx, r, FormatCapacity(group.Capacity))
+	return ScaleDownAutoScalingGroup(ctx, group)
+}
+
+func (AutoScalingGroupProvider) Start(ctx context.Context, r resource.Resource) error {
+	group := r.Object.(AutoScalingGroup)
+	saved, hasSaved, err := group.SavedCapacity()
+	if err == nil && !hasSaved {
+		saved, hasSaved = storedCapacity(ctx, r)
+	}
+	if err != nil || !hasSaved {
+		return fmt.Errorf("no saved capacity for auto scaling group %s: %v", group.Name, err)
+	}
+	if err := RestoreAutoScalingGroup(ctx, group, saved); err != nil {
+		return err
+	}
+	resource.SaveOriginalCapacity(ctx, r, "")
+	return nil
+}
+
+// storedCapacity returns the capacity remembered in the state store for a resource
+// scaled to zero, used when its saved capacity tag was removed. It is only read for
+// resources still scaled down, as the store can't see a capacity restored by hand.
+func storedCapacity(ctx context.Context, r resource.Resource) (Capacity, bool) {
+	value, ok := resource.OriginalCapacity(ctx, r)
+	if !ok {
+		return Capacity{}, false
+	}
+	capacity, err := ParseCapacity(value)
+	if err != nil {
+		logger.Error("error reading stored capacity", "kind", r.Kind, "resource", r.ID, "error", err)
+		return Capacity{}, false
+	}
+	return capacity, true
+}
+
+// capacityState returns the state of a resource scaled to zero by cloudoff. A resource
+// scaled to zero by someone else is left untouched.
+func capacityState(current Capacity, hasSaved bool) resource.State {
+	switch {
+	case hasSaved:
+		return resource.StateStopped
+	case current.IsScaledDown():
+		return resource.StateUnknown
+	default:
+		return resource.StateRunning
+	}
+}
+
+// NodegroupProvider manages EKS managed node groups, stopped by scaling them to zero.
+type NodegroupProvider struct{}
+
+func (NodegroupProvider) Kind() string { return KindNodegroup }
+
+func (NodegroupProvider) Discover(ctx context.Context) ([]resource.Resource, error) {
+	nodegroups, err := DiscoverNodegroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []resource.Resource
+	for _, nodegroup := range nodegroups {
+		_, hasSaved, err := nodegroup.SavedCapacity()
+		if err != nil {
+			logger.Error("error reading saved capacity", "nodegroup", nodegroup.Name, "error", err)
+			continue
+		}
+
+		r := resource.Resource{
+			ID:      nodegroup.ClusterName + "/" + nodegroup.Name,
+			Kind:    KindNodegroup,
+			Region:  nodegroup.Region,
+			Account: accountFromARN(nodegroup.Arn),
+			Tags:    nodegroup.Tags,
+			State:   resource.StateUnknown,
+			Object:  nodegroup,
+		}
+		if !hasSaved && nodegroup.Capacity.IsScaledDown() {
+			_, hasSaved = storedCapacity(ctx, r)
+		}
+		// Node groups being created, updated or deleted are left untouched
+		if nodegroup.Status == "ACTIVE" {
+			r.State = capacityState(nodegroup.Capacity, hasSaved)
+		}
+		r.StoppedByCloudoff = hasSaved
+		resources = append(resources, r)
+	}
+	return resources, nil
+}
+
+func (NodegroupProvider) Stop(ctx context.Context, r resource.Resource) error {
+	nodegroup := r.Object.(Nodegroup)
+	resource.SaveOriginalCapacity(ctx, r, FormatCapacity(nodegroup.Capacity))
+	return ScaleDownNodegroup(ctx, nodegroup)
+}
+
+func (NodegroupProvider) Start(ctx context.Context, r resource.Resource) error {
+	nodegroup := r.Object.(Nodegroup)
+	saved, hasSaved, err := nodegroup.SavedCapacity()
+	if err == nil && !hasSaved {
+		saved, hasSaved = storedCapacity(ctx, r)
+	}
+	if err != nil || !hasSaved {
+		return fmt.Errorf("no saved capacity for node group %s: %v", nodegroup.Name, err)
+	}
+	if err := RestoreNodegroup(ctx, nodegroup, saved); err != nil {
+		return err
+	}
+	resource.SaveOriginalCapacity(ctx, r, "")
+	return nil
+}
+
+// ServiceProvider manages ECS services, stopped by setting their desired count to zero.
+type ServiceProvider struct{}
+
+func (ServiceProvider) Kind() string { return KindService }
+
+func (ServiceProvider) Discover(ctx context.Context) ([]resource.Resource, error) {
+	services, err := DiscoverServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []resource.Resource
+	for _, service := range services {
+		_, hasSaved, err := service.SavedDesiredCount()
+		if err != nil {
+			logger.Error("error reading saved desired count", "service", service.Name, "error", err)
+			continue
+		}
+
+		r := resource.Resource{
+			ID:      service.Arn,
+			Kind:    KindService,
+			Region:  service.Region,
+			Account: accountFromARN(service.Arn),
+			Tags:    service.Tags,
+			State:   resource.StateUnknown,
+			Object:  service,
+		}
+		if !hasSaved && service.DesiredCount == 0 {
+			_, hasSaved = storedCapacity(ctx, r)
+		}
+		// Daemon services have no desired count
+		if service.Status == "ACTIVE" && !service.Daemon {
+			r.State = capacityState(Capacity{DesiredCapacity: service.DesiredCount}, hasSaved)
+		}
+		r.StoppedByCloudoff = hasSaved
+		resources = append(resources, r)
+	}
+	return resources, nil
+}
+
+func (ServiceProvider) Stop(ctx context.Context, r resource.Resource) error {
+	service := r.Object.(Service)
+	resource.SaveOriginalCapacity(ctx, r, FormatCapacity(Capacity{DesiredCapacity: service.DesiredCount}))
+	return ScaleDownService(ctx, service)
+}
+
+func (ServiceProvider) Start(ctx context.Context, r resource.Resource) error {
+	service := r.Object.(Service)
+	saved, hasSaved, err := service.SavedDesiredCount()
+	if err == nil && !hasSaved {
+		var capacity Capacity
+		capacity, hasSaved = storedCapacity(ctx, r)
+		saved = capacity.DesiredCapacity
+	}
+	if err != nil || !hasSaved {
+		return fmt.Errorf("no saved desired count for service %s: %v", service.Name, err)
+	}
+	if err := RestoreService(ctx, service, saved); err != nil {
+		return err
+	}
+	resource.SaveOriginalCapacity(ctx, r, "")
+	return nil
+}
+
+// DBInstanceProvider manages RDS DB instances that are not part of a cluster.
+type DBInstanceProvider struct{}
+
+func (DBInstanceProvider) Kind() string { return KindDBInstance }
+
+func (DBInstanceProvider) Discover(ctx context.Context) ([]resource.Resource, error) {
+	instances, err := DiscoverDBInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []resource.Resource
+	for _, instance := range instances {
+		supported, reason := instance.StopSupported()
+		resources = append(resources, resource.Resource{
+			ID:      instance.ID,
+			Kind:    KindDBInstance,
+			Region:  instance.Region,
+			Account: accountFromARN(instance.Arn),
+			Tags:    instance.Tags,
+			State:   dbState(KindDBInstance, instance.ID, instance.Status, instance.Tags, supported, reason),
+			Warning: reason,
+			Object:  instance,
+		})
+	}
+	return resources, nil
+}
+
+func (DBInstanceProvider) Stop(ctx context.Context, r resource.Resource) error {
+	return StopDBInstance(ctx, r.ID, r.Region)
+}
+
+func (DBInstanceProvider) Start(ctx context.Context, r resource.Resource) error {
+	return StartDBInstance(ctx, r.ID, r.Region)
+}
+
+// DBClusterProvider manages RDS DB clusters.
+type DBClusterProvider struct{}
+
+func (DBClusterProvider) Kind() string { return KindDBCluster }
+
+func (DBClusterProvider) Discover(ctx context.Context) ([]resource.Resource, error) {
+	clusters, err := DiscoverDBClusters(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []resource.Resource
+	for _, cluster := range clusters {
+		supported, reason := cluster.StopSupported()
+		resources = append(resources, resource.Resource{
+			ID:      cluster.ID,
+			Kind:    KindDBCluster,
+			Region:  cluster.Region,
+			Account: accountFromARN(cluster.Arn),
+			Tags:    cluster.Tags,
+			State:   dbState(KindDBCluster, cluster.ID, cluster.Status, cluster.Tags, supported, reason),
+			Warning: reason,
+			Object:  cluster,
+		})
+	}
+	return resources, nil
+}
+
+func (DBClusterProvider) Stop(ctx context.Context, r resource.Resource) error {
+	return StopDBCluster(ctx, r.ID, r.Region)
+}
+
+func (DBClusterProvider) Start(ctx context.Context, r resource.Resource) error {
+	return StartDBCluster(ctx, r.ID, r.Region)
+}
+
+// dbState returns the state of a DB instance or cluster. Only "available" resources can
+// be stopped and only "stopped" resources can be started, other statuses are transitions
+// RDS must complete first.
+//
+// RDS automatically starts a resource that has been stopped for 7 days. As the decision
+// only depends on the current status, such a resource is stopped again on the next run.
+func dbState(kind, id, status string, tags []Tag, supported bool, reason string) resource.State {
+	if !supported {
+		if isScheduled(tags) {
+			logger.Warn("schedule ignored, stop is not supported", kind, id, "reason", reason)
+		}
+		return resource.StateUnknown
+	}
+
+	switch status {
+	case "available":
+		return resource.StateRunning
+	case "stopped":
+		return resource.StateStopped
+	default:
+		return resource.StateUnknown
+	}
+}
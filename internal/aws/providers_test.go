@@ -0,0 +1,68 @@
+package ec2
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+func TestCapacityState(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  Capacity
+		hasSaved bool
+		expected resource.State
+	}{
+		{"Running group", Capacity{MinSize: 1, MaxSize: 3, DesiredCapacity: 2}, false, resource.StateRunning},
+		{"Scaled down by cloudoff", Capacity{}, true, resource.StateStopped},
+		{"Scaled down by someone else", Capacity{MaxSize: 1}, false, resource.StateUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := capacityState(tt.current, tt.hasSaved)
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestAccountFromARN(t *testing.T) {
+	account := accountFromARN("arn:aws:ecs:eu-west-1:123456789012:service/dev/web")
+	if account != "123456789012" {
+		t.Errorf("expected 123456789012, got %q", account)
+	}
+	if accountFromARN("invalid") != "" {
+		t.Errorf("expected empty account for an invalid arn")
+	}
+}
+
+func TestStoredCapacity(t *testing.T) {
+	resource.ResetStore()
+	defer resource.ResetStore()
+
+	group := resource.Resource{Kind: KindAutoScalingGroup, Account: "111111111111", Region: "eu-west-1", ID: "web"}
+	if _, ok := storedCapacity(context.Background(), group); ok {
+		t.Fatalf("expected no stored capacity")
+	}
+
+	resource.SaveOriginalCapacity(context.Background(), group, FormatCapacity(Capacity{MinSize: 1, MaxSize: 3, DesiredCapacity: 2}))
+	capacity, ok := storedCapacity(context.Background(), group)
+	if !ok || capacity != (Capacity{MinSize: 1, MaxSize: 3, DesiredCapacity: 2}) {
+		t.Errorf("unexpected stored capacity %+v", capacity)
+	}
+
+	// A group with the same name in another account has its own capacity
+	other := group
+	other.Account = "222222222222"
+	if _, ok := storedCapacity(context.Background(), other); ok {
+		t.Errorf("expected the capacity to be scoped by account")
+	}
+
+	resource.SaveOriginalCapacity(context.Background(), group, "invalid")
+	if _, ok := storedCapacity(context.Background(), group); ok {
+		t.Errorf("expected an invalid stored capacity to be ignored")
+	}
+}
@@ -0,0 +1,213 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+)
+
+type DBInstance struct {
+	ID              string
+	Arn             string
+	Region          string
+	Engine          string
+	Status          string
+	IsReadReplica   bool
+	HasReadReplicas bool
+	Tags            []Tag
+}
+
+type DBCluster struct {
+	ID            string
+	Arn           string
+	Region        string
+	Engine        string
+	EngineMode    string
+	Status        string
+	IsReadReplica bool
+	Tags          []Tag
+}
+
+// DiscoverDBInstances returns the RDS DB instances that are not part of a DB cluster.
+// Cluster members can't be stopped individually, they are managed through DiscoverDBClusters.
+func DiscoverDBInstances(ctx context.Context) ([]DBInstance, error) {
+
+	svc, err := sharedRDSClient(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var listInstances []DBInstance
+
+	paginator := rds.NewDescribeDBInstancesPaginator(svc, &rds.DescribeDBInstancesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe db instances: %v", err)
+		}
+
+		for _, instance := range page.DBInstances {
+			if aws.ToString(instance.DBClusterIdentifier) != "" {
+				continue
+			}
+
+			listInstances = append(listInstances, DBInstance{
+				ID:              aws.ToString(instance.DBInstanceIdentifier),
+				Arn:             aws.ToString(instance.DBInstanceArn),
+				Region:          svc.Options().Region,
+				Engine:          aws.ToString(instance.Engine),
+				Status:          aws.ToString(instance.DBInstanceStatus),
+				IsReadReplica:   aws.ToString(instance.ReadReplicaSourceDBInstanceIdentifier) != "" || aws.ToString(instance.ReadReplicaSourceDBClusterIdentifier) != "",
+				HasReadReplicas: len(instance.ReadReplicaDBInstanceIdentifiers) > 0,
+				Tags:            convertRDSTags(instance.TagList),
+			})
+		}
+	}
+
+	return listInstances, nil
+}
+
+// DiscoverDBClusters returns the RDS DB clusters (Aurora, Multi-AZ DB clusters).
+func DiscoverDBClusters(ctx context.Context) ([]DBCluster, error) {
+
+	svc, err := sharedRDSClient(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var listClusters []DBCluster
+
+	paginator := rds.NewDescribeDBClustersPaginator(svc, &rds.DescribeDBClustersInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe db clusters: %v", err)
+		}
+
+		for _, cluster := range page.DBClusters {
+			listClusters = append(listClusters, DBCluster{
+				ID:            aws.ToString(cluster.DBClusterIdentifier),
+				Arn:           aws.ToString(cluster.DBClusterArn),
+				Region:        svc.Options().Region,
+				Engine:        aws.ToString(cluster.Engine),
+				EngineMode:    aws.ToString(cluster.EngineMode),
+				Status:        aws.ToString(cluster.Status),
+				IsReadReplica: aws.ToString(cluster.ReplicationSourceIdentifier) != "",
+				Tags:          convertRDSTags(cluster.TagList),
+			})
+		}
+	}
+
+	return listClusters, nil
+}
+
+func convertRDSTags(rdsTags []rdstypes.Tag) []Tag {
+	var customTags []Tag
+	for _, tag := range rdsTags {
+		customTags = append(customTags, Tag{
+			Key:   aws.ToString(tag.Key),
+			Value: aws.ToString(tag.Value),
+		})
+	}
+	return customTags
+}
+
+// StopSupported reports whether RDS allows stopping the instance, with the reason when it doesn't.
+func (i DBInstance) StopSupported() (bool, string) {
+	switch {
+	case i.IsReadReplica:
+		return false, "read replicas can't be stopped"
+	case i.HasReadReplicas:
+		return false, "instances with read replicas can't be stopped"
+	case strings.HasPrefix(i.Engine, "custom-"):
+		return false, fmt.Sprintf("engine %s is not supported", i.Engine)
+	}
+	return true, ""
+}
+
+// StopSupported reports whether RDS allows stopping the cluster, with the reason when it doesn't.
+func (c DBCluster) StopSupported() (bool, string) {
+	switch {
+	case c.EngineMode == "serverless":
+		return false, "aurora serverless v1 clusters can't be stopped"
+	case c.EngineMode == "global" || c.EngineMode == "multimaster" || c.EngineMode == "parallelquery":
+		return false, fmt.Sprintf("engine mode %s is not supported", c.EngineMode)
+	case c.IsReadReplica:
+		return false, "replica clusters can't be stopped"
+	case c.Engine == "neptune" || c.Engine == "docdb":
+		return false, fmt.Sprintf("engine %s is not supported", c.Engine)
+	}
+	return true, ""
+}
+
+func StopDBInstance(ctx context.Context, instanceID, region string) error {
+	rdsClient, err := sharedRDSClient(ctx, region)
+	if err != nil {
+		return err
+	}
+
+	_, err = rdsClient.StopDBInstance(ctx, &rds.StopDBInstanceInput{
+		DBInstanceIdentifier: aws.String(instanceID),
+	})
+	if err != nil {
+		return fmt.Errorf("error stopping db instance %s: %v", instanceID, err)
+	}
+
+	logger.Info("db instance stopped successfully", "dbinstance", instanceID)
+	return nil
+}
+
+func StartDBInstance(ctx context.Context, instanceID, region string) error {
+	rdsClient, err := sharedRDSClient(ctx, region)
+	if err != nil {
+		return err
+	}
+
+	_, err = rdsClient.StartDBInstance(ctx, &rds.StartDBInstanceInput{
+		DBInstanceIdentifier: aws.String(instanceID),
+	})
+	if err != nil {
+		return fmt.Errorf("error starting db instance %s: %v", instanceID, err)
+	}
+
+	logger.Info("db instance started successfully", "dbinstance", instanceID)
+	return nil
+}
+
+func StopDBCluster(ctx context.Context, clusterID, region string) error {
+	rdsClient, err := sharedRDSClient(ctx, region)
+	if err != nil {
+		return err
+	}
+
+	_, err = rdsClient.StopDBCluster(ctx, &rds.StopDBClusterInput{
+		DBClusterIdentifier: aws.String(clusterID),
+	})
+	if err != nil {
+		return fmt.Errorf("error stopping db cluster %s: %v", clusterID, err)
+	}
+
+	logger.Info("db cluster stopped successfully", "dbcluster", clusterID)
+	return nil
+}
+
+func StartDBCluster(ctx context.Context, clusterID, region string) error {
+	rdsClient, err := sharedRDSClient(ctx, region)
+	if err != nil {
+		return err
+	}
+
+	_, err = rdsClient.StartDBCluster(ctx, &rds.StartDBClusterInput{
+		DBClusterIdentifier: aws.String(clusterID),
+	})
+	if err != nil {
+		return fmt.Errorf("error starting db cluster %s: %v", clusterID, err)
+	}
+
+	logger.Info("db cluster started successfully", "dbcluster", clusterID)
+	return nil
+}
@@ -0,0 +1,138 @@
+package ec2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+func TestDBInstanceStopSupported(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance DBInstance
+		expected bool
+	}{
+		{"Postgres instance", DBInstance{Engine: "postgres"}, true},
+		{"Read replica", DBInstance{Engine: "mysql", IsReadReplica: true}, false},
+		{"Source of read replicas", DBInstance{Engine: "mysql", HasReadReplicas: true}, false},
+		{"RDS Custom", DBInstance{Engine: "custom-oracle-ee"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, reason := tt.instance.StopSupported()
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v (%s)", tt.expected, result, reason)
+			}
+		})
+	}
+}
+
+func TestDBClusterStopSupported(t *testing.T) {
+	tests := []struct {
+		name     string
+		cluster  DBCluster
+		expected bool
+	}{
+		{"Aurora provisioned", DBCluster{Engine: "aurora-postgresql", EngineMode: "provisioned"}, true},
+		{"Aurora serverless v1", DBCluster{Engine: "aurora-mysql", EngineMode: "serverless"}, false},
+		{"Aurora global", DBCluster{Engine: "aurora-mysql", EngineMode: "global"}, false},
+		{"Replica cluster", DBCluster{Engine: "aurora-mysql", EngineMode: "provisioned", IsReadReplica: true}, false},
+		{"DocumentDB", DBCluster{Engine: "docdb"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, reason := tt.cluster.StopSupported()
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v (%s)", tt.expected, result, reason)
+			}
+		})
+	}
+}
+
+func TestDBState(t *testing.T) {
+	downtime := []Tag{{Key: "cloudoff:downtime", Value: "Mon-Fri 20:00-23:59"}}
+
+	tests := []struct {
+		name      string
+		status    string
+		supported bool
+		expected  resource.State
+	}{
+		{"Available", "available", true, resource.StateRunning},
+		{"Stopped", "stopped", true, resource.StateStopped},
+		{"Starting", "starting", true, resource.StateUnknown},
+		{"Stopping", "stopping", true, resource.StateUnknown},
+		{"Backing up", "backing-up", true, resource.StateUnknown},
+		{"Stop not supported", "available", false, resource.StateUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := dbState(KindDBInstance, "db-1", tt.status, downtime, tt.supported, "not supported")
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+// describeDBInstances returns a DescribeDBInstances response with the given instances.
+func describeDBInstances(instances ...string) string {
+	return `<DescribeDBInstancesResponse xmlns="http://rds.amazonaws.com/doc/2014-10-31/"><DescribeDBInstancesResult><DBInstances>` +
+		strings.Join(instances, "") +
+		`</DBInstances></DescribeDBInstancesResult><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></DescribeDBInstancesResponse>`
+}
+
+func TestDBInstanceProviderDiscover(t *testing.T) {
+	ResetClients()
+	defer ResetClients()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(describeDBInstances(
+			`<DBInstance><DBInstanceIdentifier>standalone</DBInstanceIdentifier><DBInstanceArn>arn:aws:rds:eu-west-3:123456789012:db:standalone</DBInstanceArn>`+
+				`<Engine>postgres</Engine><DBInstanceStatus>available</DBInstanceStatus></DBInstance>`,
+			`<DBInstance><DBInstanceIdentifier>stopped</DBInstanceIdentifier><Engine>postgres</Engine><DBInstanceStatus>stopped</DBInstanceStatus></DBInstance>`,
+			`<DBInstance><DBInstanceIdentifier>aurora-1</DBInstanceIdentifier><DBClusterIdentifier>aurora</DBClusterIdentifier>`+
+				`<Engine>aurora-postgresql</Engine><DBInstanceStatus>available</DBInstanceStatus></DBInstance>`,
+		)))
+	}))
+	defer server.Close()
+
+	cfg, err := loadConfig(context.Background(), "eu-west-3",
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("test", "test", "")),
+		config.WithBaseEndpoint(server.URL),
+		config.WithRetryMaxAttempts(1),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clients["rds/"] = rds.NewFromConfig(cfg)
+
+	resources, err := DBInstanceProvider{}.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The cluster members are scheduled through their cluster
+	expected := map[string]resource.State{"standalone": resource.StateRunning, "stopped": resource.StateStopped}
+	if len(resources) != len(expected) {
+		t.Fatalf("expected %v, got %+v", expected, resources)
+	}
+	for _, r := range resources {
+		if state, ok := expected[r.ID]; !ok || r.State != state {
+			t.Errorf("unexpected resource %s in state %v", r.ID, r.State)
+		}
+	}
+	if resources[0].Account != "123456789012" {
+		t.Errorf("expected the account of the arn, got %q", resources[0].Account)
+	}
+}
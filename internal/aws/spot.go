@@ -0,0 +1,318 @@
+package ec2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// SpotTag set to "recreate" lets cloudoff terminate a one-time spot instance, which can't
+// be stopped, and launch it again at the end of the downtime.
+const SpotTag = "cloudoff:spot"
+
+// spotRecreateTag marks the launch templates of the spot instances terminated by cloudoff,
+// with the ID and the launch time of the instance (ex. : "i-0abc 2024-01-02T03:04:05Z").
+const spotRecreateTag = "cloudoff:spot-recreate"
+
+// spotOriginTag is set on the instances launched from the launch template of a spot
+// instance terminated by cloudoff, to the ID of that instance. Its template is never
+// launched again once an instance with this origin exists.
+const spotOriginTag = "cloudoff:spot-origin"
+
+// launchTimeTag carries the launch time of a spot instance over to the instances it is
+// recreated as, so its cloudoff:ttl keeps counting from the first launch.
+const launchTimeTag = "cloudoff:launch-time"
+
+// Tags set by AWS on the instances launched by a Spot Fleet or an EC2 Fleet
+const (
+	spotFleetTag = "aws:ec2spot:fleet-request-id"
+	ec2FleetTag  = "aws:ec2:fleet-id"
+)
+
+var spotInstances = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "cloudoff_spot_instances",
+	Help: "Number of spot instances by the way cloudoff stops them (stop, recreate or unsupported).",
+}, []string{"mode"})
+
+// SpotMode is how cloudoff stops and starts an instance depending on its purchase option.
+type SpotMode string
+
+const (
+	SpotModeOnDemand SpotMode = "on-demand"
+	// SpotModeStop instances come from a persistent spot request and are stopped and started
+	SpotModeStop SpotMode = "stop"
+	// SpotModeRecreate instances are terminated and launched again from a launch template
+	SpotModeRecreate SpotMode = "recreate"
+	// SpotModeUnsupported instances are left untouched
+	SpotModeUnsupported SpotMode = "unsupported"
+)
+
+// SpotMode returns how the instance is stopped and started, with the reason when it
+// can't be. AWS only stops the spot instances of a persistent request, the fleets
+// replace the instances they launched and one-time instances can only be terminated.
+func (i Instance) SpotMode() (SpotMode, string) {
+	if !i.Spot {
+		return SpotModeOnDemand, ""
+	}
+	if i.SpotLaunchTemplate != "" {
+		return SpotModeRecreate, ""
+	}
+	if i.SpotFleet != "" {
+		return SpotModeUnsupported, fmt.Sprintf("spot instance managed by fleet %s, schedule the fleet instead", i.SpotFleet)
+	}
+
+	switch i.SpotRequestType {
+	case string(types.SpotInstanceTypePersistent):
+		return SpotModeStop, ""
+	case string(types.SpotInstanceTypeOneTime):
+		if value, _ := tagValue(i.Tags, SpotTag); value == "recreate" {
+			return SpotModeRecreate, ""
+		}
+		return SpotModeUnsupported, "one-time spot instance can't be stopped, tag it " + SpotTag + "=recreate to terminate it and launch it again"
+	default:
+		return SpotModeUnsupported, "spot request of the instance not found"
+	}
+}
+
+// TTLStart returns the time from which the cloudoff:ttl tag of the instance is counted:
+// its launch, or the launch of the first instance for a spot instance recreated by cloudoff.
+func (i Instance) TTLStart() time.Time {
+	if value, ok := tagValue(i.Tags, launchTimeTag); ok {
+		if launched, err := time.Parse(time.RFC3339, value); err == nil {
+			return launched
+		}
+	}
+	return i.AttachTime
+}
+
+// spotFleet returns the Spot Fleet or EC2 Fleet which launched an instance.
+func spotFleet(tags []types.Tag) string {
+	for _, tag := range tags {
+		if key := aws.ToString(tag.Key); key == spotFleetTag || key == ec2FleetTag {
+			return aws.ToString(tag.Value)
+		}
+	}
+	return ""
+}
+
+// describeSpotRequestTypes returns the type (one-time or persistent) of the spot requests.
+func describeSpotRequestTypes(ctx context.Context, svc *ec2.Client, requestIDs []string) (map[string]string, error) {
+	requestTypes := map[string]string{}
+	if len(requestIDs) == 0 {
+		return requestTypes, nil
+	}
+
+	paginator := ec2.NewDescribeSpotInstanceRequestsPaginator(svc, &ec2.DescribeSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: requestIDs,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe spot instance requests: %v", err)
+		}
+		for _, request := range page.SpotInstanceRequests {
+			requestTypes[aws.ToString(request.SpotInstanceRequestId)] = string(request.Type)
+		}
+	}
+	return requestTypes, nil
+}
+
+// discoverTerminatedSpotInstances returns the spot instances terminated by cloudoff, from
+// the launch templates they are recreated from. They are reported as stopped. The
+// templates of the live instances are skipped, launching them would run a copy of the
+// instance: its termination failed, or it was already launched again from the template
+// which could not be deleted.
+func discoverTerminatedSpotInstances(ctx context.Context, svc *ec2.Client, live map[string]bool) ([]Instance, error) {
+	var instances []Instance
+
+	paginator := ec2.NewDescribeLaunchTemplatesPaginator(svc, &ec2.DescribeLaunchTemplatesInput{
+		Filters: []types.Filter{{Name: aws.String("tag-key"), Values: []string{spotRecreateTag}}},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe launch templates: %v", err)
+		}
+
+		for _, template := range page.LaunchTemplates {
+			var id string
+			var launchTime time.Time
+			var tags []types.Tag
+			for _, tag := range template.Tags {
+				if aws.ToString(tag.Key) == spotRecreateTag {
+					value, launched, _ := strings.Cut(aws.ToString(tag.Value), " ")
+					id = value
+					launchTime, _ = time.Parse(time.RFC3339, launched)
+					continue
+				}
+				tags = append(tags, tag)
+			}
+
+			if live[id] {
+				logger.Warn("launch template of a live spot instance ignored, delete it", "instance", id, "launchtemplate", aws.ToString(template.LaunchTemplateId))
+				continue
+			}
+
+			name := id
+			if value, ok := tagValue(ConvertToCustomTag(tags), "Name"); ok {
+				name = value
+			}
+
+			instances = append(instances, Instance{
+				Spot:               true,
+				ID:                 id,
+				Name:               name,
+				InstanceId:         id,
+				Region:             svc.Options().Region,
+				State:              string(types.InstanceStateNameStopped),
+				LaunchTime:         launchTime,
+				AttachTime:         launchTime,
+				StoppedAt:          aws.ToTime(template.CreateTime),
+				Tags:               ConvertToCustomTag(tags),
+				SpotLaunchTemplate: aws.ToString(template.LaunchTemplateId),
+			})
+		}
+	}
+	return instances, nil
+}
+
+// TerminateSpotInstance saves the configuration of a one-time spot instance in a launch
+// template, used to launch it again, and terminates it. The disks are recreated from
+// the image, the data written since the launch is lost.
+func TerminateSpotInstance(ctx context.Context, instance Instance) error {
+	ec2Client, err := sharedEC2Client(ctx, instance.Region)
+	if err != nil {
+		return err
+	}
+
+	current, err := ec2Client.GetLaunchTemplateData(ctx, &ec2.GetLaunchTemplateDataInput{InstanceId: aws.String(instance.ID)})
+	if err != nil {
+		return fmt.Errorf("error reading configuration of instance %s: %v", instance.ID, err)
+	}
+	data, err := launchTemplateRequest(current.LaunchTemplateData)
+	if err != nil {
+		return fmt.Errorf("error reading configuration of instance %s: %v", instance.ID, err)
+	}
+
+	// The AWS tags are reserved, they are set again by AWS on the new instance. The new
+	// instance keeps the launch time of the first one and records the instance it replaces.
+	var tags []types.Tag
+	for _, tag := range instance.Tags {
+		if !strings.HasPrefix(tag.Key, "aws:") && tag.Key != launchTimeTag && tag.Key != spotOriginTag {
+			tags = append(tags, types.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)})
+		}
+	}
+	tags = append(tags,
+		types.Tag{Key: aws.String(launchTimeTag), Value: aws.String(instance.TTLStart().UTC().Format(time.RFC3339))},
+		types.Tag{Key: aws.String(spotOriginTag), Value: aws.String(instance.ID)},
+	)
+	data.TagSpecifications = []types.LaunchTemplateTagSpecificationRequest{{ResourceType: types.ResourceTypeInstance, Tags: tags}}
+	data.InstanceMarketOptions = &types.LaunchTemplateInstanceMarketOptionsRequest{
+		MarketType:  types.MarketTypeSpot,
+		SpotOptions: &types.LaunchTemplateSpotMarketOptionsRequest{SpotInstanceType: types.SpotInstanceTypeOneTime},
+	}
+	if current.LaunchTemplateData.InstanceMarketOptions != nil && current.LaunchTemplateData.InstanceMarketOptions.SpotOptions != nil {
+		data.InstanceMarketOptions.SpotOptions.MaxPrice = current.LaunchTemplateData.InstanceMarketOptions.SpotOptions.MaxPrice
+	}
+	// The network interfaces are deleted with the instance
+	for i := range data.NetworkInterfaces {
+		data.NetworkInterfaces[i].NetworkInterfaceId = nil
+	}
+
+	marker := types.Tag{Key: aws.String(spotRecreateTag), Value: aws.String(instance.ID + " " + instance.LaunchTime.UTC().Format(time.RFC3339))}
+	template, err := ec2Client.CreateLaunchTemplate(ctx, &ec2.CreateLaunchTemplateInput{
+		LaunchTemplateName: aws.String("cloudoff-" + instance.ID),
+		LaunchTemplateData: data,
+		TagSpecifications:  []types.TagSpecification{{ResourceType: types.ResourceTypeLaunchTemplate, Tags: append(tags, marker)}},
+	})
+	if err != nil {
+		return fmt.Errorf("error saving configuration of instance %s: %v", instance.ID, err)
+	}
+
+	if err := TerminateInstance(ctx, instance.ID, instance.Region); err != nil {
+		// Without the instance terminated, the launch template would launch a copy. It is
+		// deleted even when the termination failed because the context was cancelled.
+		if _, deleteErr := ec2Client.DeleteLaunchTemplate(context.WithoutCancel(ctx), &ec2.DeleteLaunchTemplateInput{LaunchTemplateId: template.LaunchTemplate.LaunchTemplateId}); deleteErr != nil {
+			logger.Error("error deleting launch template", "launchtemplate", aws.ToString(template.LaunchTemplate.LaunchTemplateId), "error", deleteErr)
+		}
+		return err
+	}
+
+	logger.Info("spot instance terminated, it will be launched again from its launch template", "instance", instance.ID, "launchtemplate", aws.ToString(template.LaunchTemplate.LaunchTemplateId))
+	return nil
+}
+
+// launchTemplateRequest converts the configuration of an instance to the data of a launch
+// template. The response and request types of the SDK differ but share their field names.
+func launchTemplateRequest(data *types.ResponseLaunchTemplateData) (*types.RequestLaunchTemplateData, error) {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var request types.RequestLaunchTemplateData
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// RecreateSpotInstance launches a spot instance terminated by cloudoff from its launch
+// template, which is deleted once the new instance is launched. It returns the ID of the
+// new instance, with an error when the template could not be deleted. The new instance
+// is tagged with the ID it replaces, so the template is not launched again meanwhile.
+func RecreateSpotInstance(ctx context.Context, instance Instance) (string, error) {
+	ec2Client, err := sharedEC2Client(ctx, instance.Region)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := ec2Client.RunInstances(ctx, &ec2.RunInstancesInput{
+		LaunchTemplate: &types.LaunchTemplateSpecification{LaunchTemplateId: aws.String(instance.SpotLaunchTemplate), Version: aws.String("$Latest")},
+		MinCount:       aws.Int32(1),
+		MaxCount:       aws.Int32(1),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error launching spot instance %s: %v", instance.ID, err)
+	}
+	newID := aws.ToString(output.Instances[0].InstanceId)
+
+	if err := DeleteSpotLaunchTemplate(context.WithoutCancel(ctx), instance); err != nil {
+		return newID, fmt.Errorf("spot instance %s launched again as %s: %v", instance.ID, newID, err)
+	}
+
+	logger.Info("spot instance launched again", "previous", instance.ID, "instance", newID)
+	return newID, nil
+}
+
+// DeleteSpotLaunchTemplate deletes the launch template of a spot instance terminated by
+// cloudoff, so it is never launched again.
+func DeleteSpotLaunchTemplate(ctx context.Context, instance Instance) error {
+	ec2Client, err := sharedEC2Client(ctx, instance.Region)
+	if err != nil {
+		return err
+	}
+
+	_, err = ec2Client.DeleteLaunchTemplate(ctx, &ec2.DeleteLaunchTemplateInput{LaunchTemplateId: aws.String(instance.SpotLaunchTemplate)})
+	if err != nil {
+		return fmt.Errorf("error deleting launch template %s: %v", instance.SpotLaunchTemplate, err)
+	}
+	return nil
+}
+
+// tagValue returns the value of a tag.
+func tagValue(tags []Tag, key string) (string, bool) {
+	for _, tag := range tags {
+		if tag.Key == key {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,247 @@
+package ec2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestSpotMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance Instance
+		expected SpotMode
+	}{
+		{"On-demand", Instance{}, SpotModeOnDemand},
+		{"Persistent request", Instance{Spot: true, SpotRequestType: "persistent"}, SpotModeStop},
+		{"One-time request", Instance{Spot: true, SpotRequestType: "one-time"}, SpotModeUnsupported},
+		{"One-time request recreated", Instance{Spot: true, SpotRequestType: "one-time", Tags: []Tag{{Key: SpotTag, Value: "recreate"}}}, SpotModeRecreate},
+		{"Fleet", Instance{Spot: true, SpotRequestType: "persistent", SpotFleet: "sfr-1"}, SpotModeUnsupported},
+		{"Unknown request", Instance{Spot: true}, SpotModeUnsupported},
+		{"Terminated by cloudoff", Instance{Spot: true, SpotLaunchTemplate: "lt-1"}, SpotModeRecreate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, reason := tt.instance.SpotMode()
+			if mode != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, mode)
+			}
+			if (mode == SpotModeUnsupported) != (reason != "") {
+				t.Errorf("expected a reason for the unsupported instances only, got %q", reason)
+			}
+		})
+	}
+}
+
+func TestLaunchTemplateRequest(t *testing.T) {
+	data := &types.ResponseLaunchTemplateData{
+		ImageId:          aws.String("ami-1"),
+		InstanceType:     types.InstanceTypeT3Micro,
+		SecurityGroupIds: []string{"sg-1"},
+		NetworkInterfaces: []types.LaunchTemplateInstanceNetworkInterfaceSpecification{
+			{DeviceIndex: aws.Int32(0), SubnetId: aws.String("subnet-1"), NetworkInterfaceId: aws.String("eni-1")},
+		},
+		BlockDeviceMappings: []types.LaunchTemplateBlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &types.LaunchTemplateEbsBlockDevice{VolumeSize: aws.Int32(20)}},
+		},
+	}
+
+	request, err := launchTemplateRequest(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if aws.ToString(request.ImageId) != "ami-1" || request.InstanceType != types.InstanceTypeT3Micro || len(request.SecurityGroupIds) != 1 {
+		t.Errorf("unexpected launch template data %+v", request)
+	}
+	if len(request.NetworkInterfaces) != 1 || aws.ToString(request.NetworkInterfaces[0].SubnetId) != "subnet-1" {
+		t.Errorf("expected the network interface, got %+v", request.NetworkInterfaces)
+	}
+	if len(request.BlockDeviceMappings) != 1 || aws.ToInt32(request.BlockDeviceMappings[0].Ebs.VolumeSize) != 20 {
+		t.Errorf("expected the block device, got %+v", request.BlockDeviceMappings)
+	}
+}
+
+// newTestEC2Client returns an EC2 client of eu-west-3 sending its requests to a test server.
+func newTestEC2Client(t *testing.T, handler http.HandlerFunc) *ec2.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg, err := loadConfig(context.Background(), "eu-west-3",
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("test", "test", "")),
+		config.WithBaseEndpoint(server.URL),
+		config.WithRetryMaxAttempts(1),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return ec2.NewFromConfig(cfg)
+}
+
+// spotTemplate returns a launch template of a spot instance terminated by cloudoff.
+func spotTemplate(templateID, instanceID string) string {
+	return `<item><launchTemplateId>` + templateID + `</launchTemplateId><createTime>2025-01-06T19:00:00.000Z</createTime>` +
+		`<tagSet><item><key>` + spotRecreateTag + `</key><value>` + instanceID + ` 2025-01-06T08:00:00Z</value></item></tagSet></item>`
+}
+
+func TestDiscoverTerminatedSpotInstances(t *testing.T) {
+	svc := newTestEC2Client(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<DescribeLaunchTemplatesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><launchTemplates>` +
+			spotTemplate("lt-1", "i-terminated") + spotTemplate("lt-2", "i-live") +
+			`</launchTemplates></DescribeLaunchTemplatesResponse>`))
+	})
+
+	// The termination of i-live failed, its template must not launch a copy of it
+	instances, err := discoverTerminatedSpotInstances(context.Background(), svc, map[string]bool{"i-live": true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(instances) != 1 || instances[0].ID != "i-terminated" || instances[0].SpotLaunchTemplate != "lt-1" {
+		t.Errorf("expected the terminated instance only, got %+v", instances)
+	}
+}
+
+func TestTerminateSpotInstanceCancelled(t *testing.T) {
+	ResetClients()
+	defer ResetClients()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var deleted atomic.Bool
+	clients["ec2/eu-west-3"] = newTestEC2Client(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.Form.Get("Action") {
+		case "GetLaunchTemplateData":
+			w.Write([]byte(`<GetLaunchTemplateDataResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><launchTemplateData><instanceType>t3.micro</instanceType></launchTemplateData></GetLaunchTemplateDataResponse>`))
+		case "CreateLaunchTemplate":
+			w.Write([]byte(`<CreateLaunchTemplateResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><launchTemplate><launchTemplateId>lt-1</launchTemplateId></launchTemplate></CreateLaunchTemplateResponse>`))
+		case "TerminateInstances":
+			// The request is cancelled while the instance is terminated
+			cancel()
+			<-r.Context().Done()
+		case "DeleteLaunchTemplate":
+			deleted.Store(true)
+			w.Write([]byte(`<DeleteLaunchTemplateResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><launchTemplate><launchTemplateId>lt-1</launchTemplateId></launchTemplate></DeleteLaunchTemplateResponse>`))
+		}
+	})
+
+	instance := Instance{ID: "i-1", Region: "eu-west-3", Spot: true, SpotRequestType: "one-time"}
+	if err := TerminateSpotInstance(ctx, instance); err == nil {
+		t.Fatalf("expected the termination to fail")
+	}
+	if !deleted.Load() {
+		t.Errorf("expected the launch template deleted after the cancelled termination")
+	}
+}
+
+func TestSpotInstanceLaunchTime(t *testing.T) {
+	ResetClients()
+	defer ResetClients()
+
+	launched := time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC)
+	var templateTags url.Values
+	clients["ec2/eu-west-3"] = newTestEC2Client(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.Form.Get("Action") {
+		case "GetLaunchTemplateData":
+			w.Write([]byte(`<GetLaunchTemplateDataResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><launchTemplateData><instanceType>t3.micro</instanceType></launchTemplateData></GetLaunchTemplateDataResponse>`))
+		case "CreateLaunchTemplate":
+			templateTags = r.Form
+			w.Write([]byte(`<CreateLaunchTemplateResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><launchTemplate><launchTemplateId>lt-1</launchTemplateId></launchTemplate></CreateLaunchTemplateResponse>`))
+		case "TerminateInstances":
+			w.Write([]byte(`<TerminateInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><instancesSet/></TerminateInstancesResponse>`))
+		}
+	})
+
+	instance := Instance{ID: "i-1", Region: "eu-west-3", Spot: true, SpotRequestType: "one-time", AttachTime: launched, LaunchTime: launched}
+	if err := TerminateSpotInstance(context.Background(), instance); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The new instance is tagged with the launch time and the ID of the terminated one
+	tags := map[string]string{}
+	for key, values := range templateTags {
+		if strings.HasPrefix(key, "LaunchTemplateData.TagSpecification.") && strings.HasSuffix(key, ".Key") {
+			tags[values[0]] = templateTags.Get(strings.TrimSuffix(key, ".Key") + ".Value")
+		}
+	}
+	value := tags[launchTimeTag]
+	if value != launched.Format(time.RFC3339) || tags[spotOriginTag] != "i-1" {
+		t.Fatalf("expected the new instance tagged with its launch time and origin, got %v", tags)
+	}
+
+	// The ttl of the new instance counts from the first launch, through every recreation
+	recreated := Instance{ID: "i-2", AttachTime: launched.Add(24 * time.Hour), Tags: []Tag{{Key: launchTimeTag, Value: value}}}
+	if start := recreated.TTLStart(); !start.Equal(launched) {
+		t.Errorf("expected the ttl to start at %v, got %v", launched, start)
+	}
+	invalid := Instance{ID: "i-3", AttachTime: launched, Tags: []Tag{{Key: launchTimeTag, Value: "yesterday"}}}
+	if start := invalid.TTLStart(); !start.Equal(launched) {
+		t.Errorf("expected the attach time for an invalid tag, got %v", start)
+	}
+}
+
+func TestRecreatedSpotInstanceDiscovery(t *testing.T) {
+	ResetClients()
+	defer ResetClients()
+
+	// i-old was launched again as i-new, but its launch template could not be deleted
+	clients["ec2/"] = newTestEC2Client(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.Form.Get("Action") {
+		case "DescribeLaunchTemplates":
+			w.Write([]byte(`<DescribeLaunchTemplatesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><launchTemplates>` +
+				spotTemplate("lt-1", "i-old") + `</launchTemplates></DescribeLaunchTemplatesResponse>`))
+		default:
+			w.Write([]byte(`<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><reservationSet><item>` +
+				`<reservationId>r-1</reservationId><instancesSet><item><instanceId>i-new</instanceId><instanceState><name>running</name></instanceState>` +
+				`<launchTime>2025-01-07T08:00:00.000Z</launchTime><tagSet><item><key>` + spotOriginTag + `</key><value>i-old</value></item></tagSet>` +
+				`</item></instancesSet></item></reservationSet></DescribeInstancesResponse>`))
+		}
+	})
+
+	instances, err := DiscoverEC2Instances(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(instances) != 1 || instances[0].ID != "i-new" {
+		t.Errorf("expected the new instance only, got %+v", instances)
+	}
+}
+
+func TestRecreateSpotInstanceTemplateNotDeleted(t *testing.T) {
+	ResetClients()
+	defer ResetClients()
+
+	clients["ec2/eu-west-3"] = newTestEC2Client(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.Form.Get("Action") {
+		case "RunInstances":
+			w.Write([]byte(`<RunInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>1</requestId><instancesSet><item><instanceId>i-new</instanceId></item></instancesSet></RunInstancesResponse>`))
+		case "DeleteLaunchTemplate":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`<Response><Errors><Error><Code>UnauthorizedOperation</Code><Message>denied</Message></Error></Errors><RequestID>1</RequestID></Response>`))
+		}
+	})
+
+	instance := Instance{ID: "i-old", Region: "eu-west-3", Spot: true, SpotLaunchTemplate: "lt-1"}
+	newID, err := RecreateSpotInstance(context.Background(), instance)
+	if err == nil || !strings.Contains(err.Error(), "i-new") {
+		t.Errorf("expected the start to fail with the new instance, got %v", err)
+	}
+	if newID != "i-new" {
+		t.Errorf("expected i-new, got %q", newID)
+	}
+}
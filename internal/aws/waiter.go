@@ -0,0 +1,251 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+// waiterInterval is the delay between two polls of the instances in transition.
+var waiterInterval = 15 * time.Second
+
+// waiterBatchSize is the number of instances polled by a DescribeInstances call.
+const waiterBatchSize = 100
+
+// waitedInstance is an instance waited for until it reaches the state of the action.
+type waitedInstance struct {
+	resource resource.Resource
+	action   resource.Capability
+	deadline time.Time
+}
+
+// regionPoller polls the instances in transition of a region, done is closed when it
+// stops.
+type regionPoller struct {
+	instances map[string]waitedInstance
+	done      chan struct{}
+}
+
+// instanceWaiter polls the instances in transition with one goroutine per region, which
+// stops once the instances of its region reached their state or timed out.
+type instanceWaiter struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	regions map[string]*regionPoller
+}
+
+var waiter = newInstanceWaiter()
+
+func newInstanceWaiter() *instanceWaiter {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &instanceWaiter{ctx: ctx, cancel: cancel, regions: map[string]*regionPoller{}}
+}
+
+// waitForInstance waits for an instance to reach the state of the action, and records
+// the outcome of its transition. A start which ends with the instance stopped, for
+// example for lack of capacity, is recorded as a failed attempt so it is retried later,
+// a stop which times out flags the instance as stuck.
+func waitForInstance(r resource.Resource, action resource.Capability) {
+	waiter.add(r, action)
+}
+
+// DrainWaiters waits for the instances in transition until ctx is done, then stops
+// polling them. It returns false when transitions were interrupted: they stay in
+// progress in the state store and the next cycle fails them after their timeout, on
+// this replica or on the next leader.
+func DrainWaiters(ctx context.Context) bool {
+	return waiter.drain(ctx)
+}
+
+// add adds an instance to the poller of its region, started if needed.
+func (w *instanceWaiter) add(r resource.Resource, action resource.Capability) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	poller, ok := w.regions[r.Region]
+	if !ok {
+		poller = &regionPoller{instances: map[string]waitedInstance{}, done: make(chan struct{})}
+		w.regions[r.Region] = poller
+		go w.poll(r.Region, poller)
+	}
+	poller.instances[r.ID] = waitedInstance{resource: r, action: action, deadline: time.Now().Add(resource.TransitionTimeout)}
+}
+
+// poll polls the instances of a region until there is none left or the waiter is
+// cancelled.
+func (w *instanceWaiter) poll(region string, poller *regionPoller) {
+	defer close(poller.done)
+
+	ticker := time.NewTicker(waiterInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.ctx.Done():
+			w.mu.Lock()
+			delete(w.regions, region)
+			w.mu.Unlock()
+			return
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		if len(poller.instances) == 0 {
+			delete(w.regions, region)
+			w.mu.Unlock()
+			return
+		}
+		instances := make([]waitedInstance, 0, len(poller.instances))
+		for _, instance := range poller.instances {
+			instances = append(instances, instance)
+		}
+		w.mu.Unlock()
+
+		for _, instance := range w.check(region, instances) {
+			w.mu.Lock()
+			// The instance may have been waited for again by a new action meanwhile
+			if current, ok := poller.instances[instance.resource.ID]; ok && current.deadline.Equal(instance.deadline) {
+				delete(poller.instances, instance.resource.ID)
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// check describes the instances of a region, by batches, and records the transitions
+// which completed, failed or timed out. It returns the instances no longer waited for.
+func (w *instanceWaiter) check(region string, instances []waitedInstance) []waitedInstance {
+	states := map[string]types.Instance{}
+	ec2Client, err := sharedEC2Client(w.ctx, region)
+	if err != nil {
+		logger.Error("error polling instances in transition", "region", region, "error", err)
+	}
+	for start := 0; err == nil && start < len(instances); start += waiterBatchSize {
+		ids := make([]string, 0, waiterBatchSize)
+		for _, instance := range instances[start:min(start+waiterBatchSize, len(instances))] {
+			ids = append(ids, instance.resource.ID)
+		}
+
+		// A filter ignores the instances not visible yet, where InstanceIds fails the call
+		input := &ec2.DescribeInstancesInput{Filters: []types.Filter{{Name: aws.String("instance-id"), Values: ids}}}
+		paginator := ec2.NewDescribeInstancesPaginator(ec2Client, input)
+		for paginator.HasMorePages() {
+			output, pageErr := paginator.NextPage(w.ctx)
+			if pageErr != nil {
+				logger.Error("error polling instances in transition", "region", region, "error", pageErr)
+				break
+			}
+			for _, reservation := range output.Reservations {
+				for _, instance := range reservation.Instances {
+					states[aws.ToString(instance.InstanceId)] = instance
+				}
+			}
+		}
+	}
+
+	now := time.Now()
+	var resolved []waitedInstance
+	for _, instance := range instances {
+		r := instance.resource
+		done, failure := transitionOutcome(states[r.ID], instance.action)
+		switch {
+		case failure != nil:
+			resource.AbortTransition(w.ctx, r, failure, now)
+		case done:
+			resource.CompleteTransition(w.ctx, r)
+			if instance.action == resource.CapabilityStop {
+				logger.Info("instance stopped successfully", "instance", r.ID, "region", r.Region)
+			} else {
+				logger.Info("instance started successfully", "instance", r.ID, "region", r.Region)
+			}
+		case now.After(instance.deadline):
+			state := "started"
+			if instance.action == resource.CapabilityStop {
+				state = "stopped"
+			}
+			resource.AbortTransition(w.ctx, r, fmt.Errorf("instance %s not %s after %v", r.ID, state, resource.TransitionTimeout), now)
+		default:
+			continue
+		}
+		resolved = append(resolved, instance)
+	}
+	return resolved
+}
+
+// transitionOutcome returns whether an instance reached the state of the action, or the
+// error of a transition which can't complete. An instance not described yet is still
+// waited for.
+func transitionOutcome(instance types.Instance, action resource.Capability) (bool, error) {
+	if instance.State == nil {
+		return false, nil
+	}
+	id := aws.ToString(instance.InstanceId)
+
+	switch state := instance.State.Name; {
+	case action == resource.CapabilityStop && state == types.InstanceStateNameStopped:
+		return true, nil
+	case action == resource.CapabilityStart && state == types.InstanceStateNameRunning:
+		return true, nil
+	case state == types.InstanceStateNameTerminated || state == types.InstanceStateNameShuttingDown:
+		return false, fmt.Errorf("instance %s %s", id, state)
+	case action == resource.CapabilityStop && state == types.InstanceStateNamePending:
+		return false, fmt.Errorf("instance %s started again while stopping", id)
+	case action == resource.CapabilityStart && state == types.InstanceStateNameStopping:
+		return false, fmt.Errorf("instance %s stopped again while starting", id)
+	}
+	if reason, failed := startFailure(instance); action == resource.CapabilityStart && failed {
+		return false, fmt.Errorf("instance %s did not start: %s", id, reason)
+	}
+	return false, nil
+}
+
+// drain waits for the pollers to stop until ctx is done, then cancels them and logs the
+// transitions interrupted.
+func (w *instanceWaiter) drain(ctx context.Context) bool {
+	for {
+		poller := w.anyPoller()
+		if poller == nil {
+			return true
+		}
+		select {
+		case <-poller.done:
+		case <-ctx.Done():
+			w.interrupt()
+			return false
+		}
+	}
+}
+
+// anyPoller returns one of the running pollers, nil if there is none.
+func (w *instanceWaiter) anyPoller() *regionPoller {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, poller := range w.regions {
+		return poller
+	}
+	return nil
+}
+
+// interrupt cancels the pollers and waits for them to stop.
+func (w *instanceWaiter) interrupt() {
+	w.mu.Lock()
+	pollers := make([]*regionPoller, 0, len(w.regions))
+	for _, poller := range w.regions {
+		pollers = append(pollers, poller)
+		for _, instance := range poller.instances {
+			logger.Warn("instance transition interrupted by shutdown", "instance", instance.resource.ID, "region", instance.resource.Region, "action", instance.action)
+		}
+	}
+	w.mu.Unlock()
+
+	w.cancel()
+	for _, poller := range pollers {
+		<-poller.done
+	}
+}
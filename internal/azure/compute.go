@@ -0,0 +1,307 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+var logger *slog.Logger
+
+// Kinds of the resources discovered by the Azure providers
+const (
+	KindVirtualMachine = "azure-vm"
+	KindScaleSet       = "azure-vmss"
+)
+
+// DefaultEndpoint is the endpoint of the Azure Resource Manager API
+const DefaultEndpoint = "https://management.azure.com"
+
+const (
+	managementScope   = "https://management.azure.com/.default"
+	computeAPIVersion = "2024-07-01"
+)
+
+type VirtualMachine struct {
+	ID           string
+	Name         string
+	Subscription string
+	Location     string
+	PowerState   string
+	TimeCreated  time.Time
+	Tags         []resource.Tag
+}
+
+type ScaleSet struct {
+	ID           string
+	Name         string
+	Subscription string
+	Location     string
+	Capacity     int64
+	PowerStates  map[string]int
+	TimeCreated  time.Time
+	Tags         []resource.Tag
+}
+
+// Client sends requests to the Azure Resource Manager API for a subscription.
+type Client struct {
+	client       *http.Client
+	endpoint     string
+	subscription string
+}
+
+// NewClient returns a client authenticated with the default Azure credential chain
+// (environment, workload identity, managed identity then Azure CLI).
+func NewClient(subscription string) (*Client, error) {
+	credential, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error loading azure credentials: %v", err)
+	}
+	httpClient := &http.Client{Transport: &tokenTransport{credential: credential, base: http.DefaultTransport}}
+	return NewClientWithHTTPClient(httpClient, DefaultEndpoint, subscription), nil
+}
+
+// NewClientWithHTTPClient returns a client sending its requests to the given endpoint.
+func NewClientWithHTTPClient(client *http.Client, endpoint, subscription string) *Client {
+	return &Client{
+		client:       client,
+		endpoint:     strings.TrimSuffix(endpoint, "/"),
+		subscription: subscription,
+	}
+}
+
+// Providers returns the providers of every Azure resource kind of the subscription.
+func (c *Client) Providers() []resource.Provider {
+	return []resource.Provider{
+		&VirtualMachineProvider{client: c},
+		&ScaleSetProvider{client: c},
+	}
+}
+
+// tokenTransport adds a bearer token to the requests.
+type tokenTransport struct {
+	credential azcore.TokenCredential
+	base       http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.credential.GetToken(req.Context(), policy.TokenRequestOptions{Scopes: []string{managementScope}})
+	if err != nil {
+		return nil, fmt.Errorf("error getting azure token: %v", err)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	return t.base.RoundTrip(req)
+}
+
+type virtualMachineList struct {
+	Value []struct {
+		ID         string            `json:"id"`
+		Name       string            `json:"name"`
+		Location   string            `json:"location"`
+		Tags       map[string]string `json:"tags"`
+		Properties struct {
+			TimeCreated  string `json:"timeCreated"`
+			InstanceView struct {
+				Statuses []struct {
+					Code string `json:"code"`
+				} `json:"statuses"`
+			} `json:"instanceView"`
+		} `json:"properties"`
+	} `json:"value"`
+	NextLink string `json:"nextLink"`
+}
+
+// DiscoverVirtualMachines returns the virtual machines of the subscription carrying a cloudoff tag.
+func (c *Client) DiscoverVirtualMachines(ctx context.Context) ([]VirtualMachine, error) {
+	var listVMs []VirtualMachine
+
+	// statusOnly returns the power state of each virtual machine with the list
+	next := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.Compute/virtualMachines?api-version=%s&statusOnly=true", c.endpoint, c.subscription, computeAPIVersion)
+	for next != "" {
+		var page virtualMachineList
+		if err := c.do(ctx, http.MethodGet, next, &page); err != nil {
+			return nil, fmt.Errorf("failed to list virtual machines of subscription %s: %v", c.subscription, err)
+		}
+
+		for _, vm := range page.Value {
+			tags := convertTags(vm.Tags)
+			if !hasCloudoffTag(tags) {
+				continue
+			}
+
+			powerState := ""
+			for _, status := range vm.Properties.InstanceView.Statuses {
+				if strings.HasPrefix(status.Code, "PowerState/") {
+					powerState = strings.TrimPrefix(status.Code, "PowerState/")
+				}
+			}
+
+			created, _ := time.Parse(time.RFC3339, vm.Properties.TimeCreated)
+			listVMs = append(listVMs, VirtualMachine{
+				ID:           vm.ID,
+				Name:         vm.Name,
+				Subscription: c.subscription,
+				Location:     vm.Location,
+				PowerState:   powerState,
+				TimeCreated:  created,
+				Tags:         tags,
+			})
+		}
+		next = page.NextLink
+	}
+
+	return listVMs, nil
+}
+
+type scaleSetList struct {
+	Value []struct {
+		ID       string            `json:"id"`
+		Name     string            `json:"name"`
+		Location string            `json:"location"`
+		Tags     map[string]string `json:"tags"`
+		Sku      struct {
+			Capacity int64 `json:"capacity"`
+		} `json:"sku"`
+		Properties struct {
+			TimeCreated string `json:"timeCreated"`
+		} `json:"properties"`
+	} `json:"value"`
+	NextLink string `json:"nextLink"`
+}
+
+type scaleSetInstanceView struct {
+	VirtualMachine struct {
+		StatusesSummary []struct {
+			Code  string `json:"code"`
+			Count int    `json:"count"`
+		} `json:"statusesSummary"`
+	} `json:"virtualMachine"`
+}
+
+// DiscoverScaleSets returns the virtual machine scale sets of the subscription carrying a
+// cloudoff tag, with the number of instances in each power state.
+func (c *Client) DiscoverScaleSets(ctx context.Context) ([]ScaleSet, error) {
+	var listScaleSets []ScaleSet
+
+	next := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.Compute/virtualMachineScaleSets?api-version=%s", c.endpoint, c.subscription, computeAPIVersion)
+	for next != "" {
+		var page scaleSetList
+		if err := c.do(ctx, http.MethodGet, next, &page); err != nil {
+			return nil, fmt.Errorf("failed to list scale sets of subscription %s: %v", c.subscription, err)
+		}
+
+		for _, scaleSet := range page.Value {
+			tags := convertTags(scaleSet.Tags)
+			if !hasCloudoffTag(tags) {
+				continue
+			}
+
+			var view scaleSetInstanceView
+			err := c.do(ctx, http.MethodGet, c.url(scaleSet.ID, "/instanceView"), &view)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get instance view of scale set %s: %v", scaleSet.Name, err)
+			}
+
+			powerStates := map[string]int{}
+			for _, status := range view.VirtualMachine.StatusesSummary {
+				if strings.HasPrefix(status.Code, "PowerState/") {
+					powerStates[strings.TrimPrefix(status.Code, "PowerState/")] += status.Count
+				}
+			}
+
+			created, _ := time.Parse(time.RFC3339, scaleSet.Properties.TimeCreated)
+			listScaleSets = append(listScaleSets, ScaleSet{
+				ID:           scaleSet.ID,
+				Name:         scaleSet.Name,
+				Subscription: c.subscription,
+				Location:     scaleSet.Location,
+				Capacity:     scaleSet.Sku.Capacity,
+				PowerStates:  powerStates,
+				TimeCreated:  created,
+				Tags:         tags,
+			})
+		}
+		next = page.NextLink
+	}
+
+	return listScaleSets, nil
+}
+
+// Deallocate stops a virtual machine or every instance of a scale set and releases its
+// compute resources. A virtual machine only powered off is still billed.
+func (c *Client) Deallocate(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, c.url(id, "/deallocate"), nil)
+}
+
+// Start starts a virtual machine or every instance of a scale set.
+func (c *Client) Start(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, c.url(id, "/start"), nil)
+}
+
+// Delete deletes a virtual machine or a scale set.
+func (c *Client) Delete(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, c.url(id, ""), nil)
+}
+
+func (c *Client) url(id, action string) string {
+	return fmt.Sprintf("%s%s%s?api-version=%s", c.endpoint, id, action, computeAPIVersion)
+}
+
+// do sends a request to the Azure Resource Manager API and decodes the response in out
+// when not nil. Actions are asynchronous and are not waited for.
+func (c *Client) do(ctx context.Context, method, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("azure api returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func convertTags(azureTags map[string]string) []resource.Tag {
+	var tags []resource.Tag
+	for key, value := range azureTags {
+		tags = append(tags, resource.Tag{Key: key, Value: value})
+	}
+	return tags
+}
+
+func hasCloudoffTag(tags []resource.Tag) bool {
+	for _, tag := range tags {
+		if tag.Key == "cloudoff:uptime" || tag.Key == "cloudoff:downtime" || tag.Key == "cloudoff:ttl" {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+}
@@ -0,0 +1,134 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+const (
+	vmID   = "/subscriptions/sub/resourceGroups/dev/providers/Microsoft.Compute/virtualMachines/web"
+	vmssID = "/subscriptions/sub/resourceGroups/dev/providers/Microsoft.Compute/virtualMachineScaleSets/workers"
+)
+
+func newTestClient(t *testing.T, requests *[]string) *Client {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api-version") == "" {
+			http.Error(w, "missing api-version", http.StatusBadRequest)
+			return
+		}
+		*requests = append(*requests, r.Method+" "+r.URL.Path)
+
+		switch r.URL.Path {
+		case "/subscriptions/sub/providers/Microsoft.Compute/virtualMachines":
+			if r.URL.Query().Get("page") == "" {
+				w.Write([]byte(`{"value":[{"id":"` + vmID + `","name":"web","location":"westeurope","tags":{"cloudoff:downtime":"Mon-Fri_20:00-23:59"},"properties":{"timeCreated":"2024-05-01T10:00:00Z","instanceView":{"statuses":[{"code":"ProvisioningState/succeeded"},{"code":"PowerState/deallocated"}]}}}],"nextLink":"` + server.URL + r.URL.Path + `?api-version=2024-07-01&page=2"}`)) //nolint:errcheck
+				return
+			}
+			w.Write([]byte(`{"value":[{"id":"/subscriptions/sub/resourceGroups/dev/providers/Microsoft.Compute/virtualMachines/untagged","name":"untagged","location":"westeurope"}]}`)) //nolint:errcheck
+		case "/subscriptions/sub/providers/Microsoft.Compute/virtualMachineScaleSets":
+			w.Write([]byte(`{"value":[{"id":"` + vmssID + `","name":"workers","location":"westeurope","sku":{"capacity":3},"tags":{"cloudoff:ttl":"7d"},"properties":{"timeCreated":"2024-05-01T10:00:00Z"}}]}`)) //nolint:errcheck
+		case vmssID + "/instanceView":
+			w.Write([]byte(`{"virtualMachine":{"statusesSummary":[{"code":"ProvisioningState/succeeded","count":3},{"code":"PowerState/running","count":2},{"code":"PowerState/deallocated","count":1}]}}`)) //nolint:errcheck
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return NewClientWithHTTPClient(server.Client(), server.URL, "sub")
+}
+
+func TestDiscover(t *testing.T) {
+	var requests []string
+	client := newTestClient(t, &requests)
+	providers := client.Providers()
+
+	vms, err := providers[0].Discover(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vms) != 1 {
+		t.Fatalf("expected the tagged virtual machine only, got %d", len(vms))
+	}
+	if vms[0].ID != vmID || vms[0].Region != "westeurope" || vms[0].Account != "sub" || vms[0].State != resource.StateStopped {
+		t.Errorf("unexpected virtual machine %+v", vms[0])
+	}
+	if vms[0].TTLStart.IsZero() {
+		t.Errorf("expected the creation time as ttl start")
+	}
+
+	scaleSets, err := providers[1].Discover(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(scaleSets) != 1 || scaleSets[0].State != resource.StateRunning {
+		t.Fatalf("expected a running scale set, got %+v", scaleSets)
+	}
+}
+
+func TestActions(t *testing.T) {
+	var requests []string
+	client := newTestClient(t, &requests)
+
+	for _, provider := range client.Providers() {
+		r := resource.Resource{ID: vmID}
+		if provider.Kind() == KindScaleSet {
+			r.ID = vmssID
+		}
+		if err := provider.(resource.Stopper).Stop(context.Background(), r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := provider.(resource.Starter).Start(context.Background(), r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := provider.(resource.Deleter).Delete(context.Background(), r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := []string{
+		"POST " + vmID + "/deallocate",
+		"POST " + vmID + "/start",
+		"DELETE " + vmID,
+		"POST " + vmssID + "/deallocate",
+		"POST " + vmssID + "/start",
+		"DELETE " + vmssID,
+	}
+	if len(requests) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, requests)
+	}
+	for i := range expected {
+		if requests[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], requests[i])
+		}
+	}
+}
+
+func TestScaleSetState(t *testing.T) {
+	tests := []struct {
+		name        string
+		powerStates map[string]int
+		expected    resource.State
+	}{
+		{"All running", map[string]int{"running": 3}, resource.StateRunning},
+		{"All deallocated", map[string]int{"deallocated": 3}, resource.StateStopped},
+		{"Partially deallocated", map[string]int{"running": 1, "deallocated": 2}, resource.StateRunning},
+		{"Powered off", map[string]int{"stopped": 3}, resource.StateRunning},
+		{"In transition", map[string]int{"running": 1, "deallocating": 2}, resource.StateUnknown},
+		{"No instance", map[string]int{}, resource.StateUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := scaleSetState(tt.powerStates)
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
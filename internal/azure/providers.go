@@ -0,0 +1,157 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+// VirtualMachineProvider manages the virtual machines of a subscription.
+type VirtualMachineProvider struct {
+	client *Client
+}
+
+func (p *VirtualMachineProvider) Kind() string { return KindVirtualMachine }
+
+func (p *VirtualMachineProvider) Discover(ctx context.Context) ([]resource.Resource, error) {
+	vms, err := p.client.DiscoverVirtualMachines(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []resource.Resource
+	for _, vm := range vms {
+		resources = append(resources, resource.Resource{
+			ID:       vm.ID,
+			Kind:     KindVirtualMachine,
+			Region:   vm.Location,
+			Account:  vm.Subscription,
+			Tags:     vm.Tags,
+			State:    powerStateToState(vm.PowerState),
+			TTLStart: vm.TimeCreated,
+			Object:   vm,
+		})
+	}
+	return resources, nil
+}
+
+func (p *VirtualMachineProvider) Stop(ctx context.Context, r resource.Resource) error {
+	if err := p.client.Deallocate(ctx, r.ID); err != nil {
+		return fmt.Errorf("error deallocating virtual machine %s: %v", r.ID, err)
+	}
+	logger.Info("virtual machine deallocated successfully", "vm", r.ID)
+	return nil
+}
+
+func (p *VirtualMachineProvider) Start(ctx context.Context, r resource.Resource) error {
+	if err := p.client.Start(ctx, r.ID); err != nil {
+		return fmt.Errorf("error starting virtual machine %s: %v", r.ID, err)
+	}
+	logger.Info("virtual machine started successfully", "vm", r.ID)
+	return nil
+}
+
+func (p *VirtualMachineProvider) Delete(ctx context.Context, r resource.Resource) error {
+	if err := p.client.Delete(ctx, r.ID); err != nil {
+		return fmt.Errorf("error deleting virtual machine %s: %v", r.ID, err)
+	}
+	logger.Info("virtual machine deleted successfully", "vm", r.ID)
+	return nil
+}
+
+// ScaleSetProvider manages the virtual machine scale sets of a subscription. All the
+// instances of a scale set are deallocated and started together.
+type ScaleSetProvider struct {
+	client *Client
+}
+
+func (p *ScaleSetProvider) Kind() string { return KindScaleSet }
+
+func (p *ScaleSetProvider) Discover(ctx context.Context) ([]resource.Resource, error) {
+	scaleSets, err := p.client.DiscoverScaleSets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []resource.Resource
+	for _, scaleSet := range scaleSets {
+		resources = append(resources, resource.Resource{
+			ID:       scaleSet.ID,
+			Kind:     KindScaleSet,
+			Region:   scaleSet.Location,
+			Account:  scaleSet.Subscription,
+			Tags:     scaleSet.Tags,
+			State:    scaleSetState(scaleSet.PowerStates),
+			TTLStart: scaleSet.TimeCreated,
+			Object:   scaleSet,
+		})
+	}
+	return resources, nil
+}
+
+func (p *ScaleSetProvider) Stop(ctx context.Context, r resource.Resource) error {
+	if err := p.client.Deallocate(ctx, r.ID); err != nil {
+		return fmt.Errorf("error deallocating scale set %s: %v", r.ID, err)
+	}
+	logger.Info("scale set deallocated successfully", "vmss", r.ID)
+	return nil
+}
+
+func (p *ScaleSetProvider) Start(ctx context.Context, r resource.Resource) error {
+	if err := p.client.Start(ctx, r.ID); err != nil {
+		return fmt.Errorf("error starting scale set %s: %v", r.ID, err)
+	}
+	logger.Info("scale set started successfully", "vmss", r.ID)
+	return nil
+}
+
+func (p *ScaleSetProvider) Delete(ctx context.Context, r resource.Resource) error {
+	if err := p.client.Delete(ctx, r.ID); err != nil {
+		return fmt.Errorf("error deleting scale set %s: %v", r.ID, err)
+	}
+	logger.Info("scale set deleted successfully", "vmss", r.ID)
+	return nil
+}
+
+// powerStateToState returns the state of a virtual machine. A powered off virtual
+// machine is still billed, so it is considered running to be deallocated during downtime.
+func powerStateToState(powerState string) resource.State {
+	switch powerState {
+	case "running", "stopped":
+		return resource.StateRunning
+	case "deallocated":
+		return resource.StateStopped
+	default:
+		return resource.StateUnknown
+	}
+}
+
+// scaleSetState returns the state of a scale set from the power states of its instances.
+// A scale set with instances in transition is left untouched, a scale set with some
+// instances still running is considered running to be deallocated entirely.
+func scaleSetState(powerStates map[string]int) resource.State {
+	running, stopped := false, false
+	for powerState, count := range powerStates {
+		if count == 0 {
+			continue
+		}
+		switch powerStateToState(powerState) {
+		case resource.StateRunning:
+			running = true
+		case resource.StateStopped:
+			stopped = true
+		default:
+			return resource.StateUnknown
+		}
+	}
+
+	switch {
+	case running:
+		return resource.StateRunning
+	case stopped:
+		return resource.StateStopped
+	default:
+		return resource.StateUnknown
+	}
+}
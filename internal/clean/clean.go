@@ -1,6 +1,7 @@
 package clean
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 	"os"
@@ -8,58 +9,97 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bananaops/cloudoff/internal/audit"
 	ec2 "github.com/bananaops/cloudoff/internal/aws"
+	"github.com/bananaops/cloudoff/internal/resource"
 )
 
 var logger *slog.Logger
 
-// CleanEC2Instance cleans up EC2 instances based on the provided schedule.
-func CleanEC2Instance() {
-	ec2List := ec2.DiscoverEC2Instances()
-
-	for _, instance := range ec2List {
-		for _, tag := range instance.Tags {
-			if tag.Key == "cloudoff:ttl" {
-				if DurationExceeded(instance) {
-					// Perform cleanup action (e.g., terminate the instance)
-					err := ec2.TerminateInstance(instance.InstanceId, instance.Region)
-					if err != nil {
-						logger.Error("error terminating instance", "instance", instance.ID, "region", instance.Region, "error", err)
-						continue
-					}
-					// Log the termination action
-					logger.Info("Instance terminated", "instance", instance.ID, "region", instance.Region, "AttachTime", instance.AttachTime, "ttl", tag.Value)
-					continue
-				}
-
-			}
-		}
+// CleanResource deletes the resource if its cloudoff:ttl has elapsed, and the extension
+// of its ttl if any.
+func CleanResource(ctx context.Context, deleter resource.Deleter, r resource.Resource) {
+	ttl, _ := r.TagValue("cloudoff:ttl")
+	expiration, ok := Expiration(ctx, r)
+	if !ok || !time.Now().After(expiration) {
+		return
+	}
+
+	event := audit.NewEvent(audit.ActorCleaner, string(resource.CapabilityDelete), audit.ReasonTTL, r).WithTag("cloudoff:ttl", ttl)
+	if os.Getenv("DRYRUN") == "true" {
+		event.DryRun = true
+		audit.Record(event, nil)
+		return
+	}
+
+	done := resource.StartAction(r, resource.CapabilityDelete)
+	err := deleter.Delete(ctx, r)
+	done()
+	resource.RecordAction(ctx, r, resource.CapabilityDelete, err)
+	audit.Record(event, err)
+	if err != nil {
+		logger.Error("error deleting resource", "kind", r.Kind, "resource", r.ID, "region", r.Region, "error", err)
 	}
 }
 
+// Expiration returns the time at which the cloudoff:ttl of a resource elapses, postponed
+// by a manual extend. It returns false without ttl, for "infinity" and invalid values.
+func Expiration(ctx context.Context, r resource.Resource) (time.Time, bool) {
+	ttl, ok := r.TagValue("cloudoff:ttl")
+	if !ok {
+		return time.Time{}, false
+	}
+	expiration, ok := TTLExpiration(ttl, r.TTLStart)
+	if !ok {
+		return time.Time{}, false
+	}
+	if extension, ok := resource.ActiveTTLExtension(ctx, r); ok && extension.Until.After(expiration) {
+		expiration = extension.Until
+	}
+	return expiration, true
+}
+
 // Duration Exceeded Function
 func DurationExceeded(instance ec2.Instance) bool {
 	// Check if the instance has a "cloudoff:ttl" tag
 	for _, tag := range instance.Tags {
 
 		if tag.Key == "cloudoff:ttl" {
-			if tag.Value == "infinity" {
-				return false // If the tag value is "infinity", do not consider it for cleanup
-			}
-			// Parse the duration from the tag value
-			duration, err := parseDuration(tag.Value)
-			if err != nil {
-				// Handle error (e.g., log it)
-				return false
-			}
-
 			// Check if the instance's launch time exceeds the specified duration
-			return isDurationExceeded(instance.AttachTime, duration)
+			return TTLExceeded(tag.Value, instance.TTLStart())
 		}
 	}
 	return false
 }
 
+// TTLExceeded checks if a ttl value (ex. : "3d") has elapsed since the given time.
+func TTLExceeded(ttl string, t time.Time) bool {
+	if ttl == "infinity" {
+		return false // If the ttl value is "infinity", do not consider it for cleanup
+	}
+	// Parse the duration from the ttl value
+	duration, err := parseDuration(ttl)
+	if err != nil {
+		// Handle error (e.g., log it)
+		return false
+	}
+
+	return isDurationExceeded(t, duration)
+}
+
+// TTLExpiration returns the time at which a ttl value (ex. : "3d") counted from the given
+// time elapses. It returns false for "infinity" and invalid values.
+func TTLExpiration(ttl string, t time.Time) (time.Time, bool) {
+	if ttl == "infinity" {
+		return time.Time{}, false
+	}
+	duration, err := parseDuration(ttl)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t.Add(duration), true
+}
+
 // isDurationExceeded checks if the duration between a given time and the current time exceeds a specified duration.
 func isDurationExceeded(t time.Time, d time.Duration) bool {
 	// Calculate the elapsed time between the given time and now
@@ -69,6 +109,11 @@ func isDurationExceeded(t time.Time, d time.Duration) bool {
 	return elapsed > d
 }
 
+// ParseDuration parses a duration in the format of the cloudoff:ttl tag (ex. : "2w").
+func ParseDuration(input string) (time.Duration, error) {
+	return parseDuration(input)
+}
+
 func parseDuration(input string) (time.Duration, error) {
 	// Check that the input string is not empty
 	if len(input) < 2 {
@@ -1,10 +1,12 @@
 package clean
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	ec2 "github.com/bananaops/cloudoff/internal/aws"
+	"github.com/bananaops/cloudoff/internal/resource"
 )
 
 func TestParseDuration(t *testing.T) {
@@ -105,3 +107,193 @@ func TestDurationExceeded(t *testing.T) {
 		})
 	}
 }
+
+func TestCleanupReason(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		tags     []ec2.Tag
+		since    time.Time
+		unused   bool
+		maxAge   time.Duration
+		expected string
+	}{
+		{
+			name:     "TTL exceeded",
+			tags:     []ec2.Tag{{Key: "cloudoff:ttl", Value: "2h"}},
+			since:    now.Add(-3 * time.Hour),
+			expected: reasonTTL,
+		},
+		{
+			name:     "TTL not exceeded on old unused resource",
+			tags:     []ec2.Tag{{Key: "cloudoff:ttl", Value: "1w"}},
+			since:    now.Add(-72 * time.Hour),
+			unused:   true,
+			maxAge:   24 * time.Hour,
+			expected: "",
+		},
+		{
+			name:     "Orphaned resource",
+			since:    now.Add(-72 * time.Hour),
+			unused:   true,
+			maxAge:   24 * time.Hour,
+			expected: reasonOrphaned,
+		},
+		{
+			name:     "Recent unused resource",
+			since:    now.Add(-2 * time.Hour),
+			unused:   true,
+			maxAge:   24 * time.Hour,
+			expected: "",
+		},
+		{
+			name:     "Orphan detection disabled",
+			since:    now.Add(-72 * time.Hour),
+			unused:   true,
+			expected: "",
+		},
+		{
+			name:     "Old resource in use",
+			since:    now.Add(-72 * time.Hour),
+			maxAge:   24 * time.Hour,
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := cleanupReason(tt.tags, tt.since, tt.unused, tt.maxAge, now)
+			if result != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestUnusedSnapshot(t *testing.T) {
+	existingVolumes := map[string]bool{"vol-1": true}
+
+	tests := []struct {
+		name     string
+		snapshot ec2.Snapshot
+		expected bool
+	}{
+		{"Existing volume", ec2.Snapshot{ID: "snap-1", VolumeID: "vol-1"}, false},
+		{"Deleted volume", ec2.Snapshot{ID: "snap-2", VolumeID: "vol-2"}, true},
+		{"Registered to an AMI", ec2.Snapshot{ID: "snap-3", VolumeID: "vol-2", ImageID: "ami-1"}, false},
+		{"AWS Backup", ec2.Snapshot{ID: "snap-4", VolumeID: "vol-2", Tags: []ec2.Tag{{Key: "aws:backup:source-resource", Value: "vol-2"}}}, false},
+		{"Copy", ec2.Snapshot{ID: "snap-5", VolumeID: "vol-ffffffff"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := unusedSnapshot(tt.snapshot, existingVolumes); result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestOrphanDelete(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected map[string]bool
+	}{
+		{"Unset", "", map[string]bool{}},
+		{"Volumes and addresses", "volume, address", map[string]bool{orphanVolume: true, orphanAddress: true}},
+		{"Snapshots", "snapshot", map[string]bool{orphanSnapshot: true}},
+		{"Former boolean", "true", map[string]bool{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ORPHAN_DELETE", tt.value)
+			for _, orphanType := range []string{orphanVolume, orphanSnapshot, orphanAddress} {
+				if result := orphanDelete(orphanType); result != tt.expected[orphanType] {
+					t.Errorf("expected %v for %s, got %v", tt.expected[orphanType], orphanType, result)
+				}
+			}
+		})
+	}
+}
+
+type fakeDeleter struct {
+	deleted []string
+}
+
+func (d *fakeDeleter) Delete(ctx context.Context, r resource.Resource) error {
+	d.deleted = append(d.deleted, r.ID)
+	return nil
+}
+
+func TestCleanResource(t *testing.T) {
+	resource.ResetStore()
+	defer resource.ResetStore()
+
+	tests := []struct {
+		name     string
+		resource resource.Resource
+		// extendedUntil is the end of a manual extension of the ttl
+		extendedUntil time.Time
+		expected      bool
+	}{
+		{
+			name: "TTL exceeded",
+			resource: resource.Resource{
+				ID:       "r",
+				Tags:     []resource.Tag{{Key: "cloudoff:ttl", Value: "2h"}},
+				TTLStart: time.Now().Add(-3 * time.Hour),
+			},
+			expected: true,
+		},
+		{
+			name: "TTL not exceeded",
+			resource: resource.Resource{
+				ID:       "r",
+				Tags:     []resource.Tag{{Key: "cloudoff:ttl", Value: "1d"}},
+				TTLStart: time.Now().Add(-3 * time.Hour),
+			},
+			expected: false,
+		},
+		{
+			name: "TTL extended",
+			resource: resource.Resource{
+				ID:       "r-extended",
+				Tags:     []resource.Tag{{Key: "cloudoff:ttl", Value: "2h"}},
+				TTLStart: time.Now().Add(-3 * time.Hour),
+			},
+			extendedUntil: time.Now().Add(time.Hour),
+			expected:      false,
+		},
+		{
+			name: "TTL extension elapsed",
+			resource: resource.Resource{
+				ID:       "r-elapsed",
+				Tags:     []resource.Tag{{Key: "cloudoff:ttl", Value: "2h"}},
+				TTLStart: time.Now().Add(-3 * time.Hour),
+			},
+			extendedUntil: time.Now().Add(-time.Minute),
+			expected:      true,
+		},
+		{
+			name:     "No TTL tag",
+			resource: resource.Resource{ID: "r", TTLStart: time.Now().Add(-3 * time.Hour)},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.extendedUntil.IsZero() {
+				resource.ExtendTTL(context.Background(), tt.resource, resource.TTLExtension{Until: tt.extendedUntil})
+			}
+			deleter := &fakeDeleter{}
+			CleanResource(context.Background(), deleter, tt.resource)
+			if (len(deleter.deleted) == 1) != tt.expected {
+				t.Errorf("expected deleted %v, got %v", tt.expected, deleter.deleted)
+			}
+		})
+	}
+}
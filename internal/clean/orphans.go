@@ -0,0 +1,284 @@
+package clean
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/audit"
+	ec2 "github.com/bananaops/cloudoff/internal/aws"
+	"github.com/bananaops/cloudoff/internal/resource"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Types of orphaned resources, listed in ORPHAN_DELETE to delete them
+const (
+	orphanVolume   = "volume"
+	orphanSnapshot = "snapshot"
+	orphanAddress  = "address"
+)
+
+// Reasons returned by cleanupReason
+const (
+	reasonTTL      = audit.ReasonTTL
+	reasonOrphaned = audit.ReasonOrphaned
+)
+
+var orphanedResources = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "cloudoff_orphaned_resources",
+	Help: "Number of unattached or unassociated resources older than ORPHAN_MAX_AGE.",
+}, []string{"type"})
+
+// CleanVolumes deletes the unattached EBS volumes whose cloudoff:ttl has elapsed
+// since their creation, and flags the unattached volumes older than ORPHAN_MAX_AGE.
+func CleanVolumes(ctx context.Context) {
+	volumes, err := ec2.DiscoverVolumes(ctx)
+	if err != nil {
+		logger.Error("error discovering volumes", "error", err)
+		return
+	}
+
+	maxAge := orphanMaxAge()
+	orphaned := 0
+
+	for _, volume := range volumes {
+		reason := cleanupReason(volume.Tags, volume.CreateTime, !volume.Attached, maxAge, time.Now())
+		if reason == "" {
+			continue
+		}
+
+		if volume.Attached {
+			logger.Warn("ttl exceeded but volume is attached, skipping", "volume", volume.ID, "region", volume.Region, "state", volume.State)
+			continue
+		}
+
+		if reason == reasonOrphaned {
+			orphaned++
+			logger.Warn("orphaned volume", "volume", volume.ID, "region", volume.Region, "createTime", volume.CreateTime)
+			if !orphanDelete(orphanVolume) {
+				continue
+			}
+		}
+
+		r := resource.Resource{Kind: ec2.KindVolume, ID: volume.ID, Region: volume.Region}
+		event := cleanupEvent("delete", reason, r, volume.Tags)
+		if os.Getenv("DRYRUN") == "true" {
+			audit.Record(event, nil)
+			continue
+		}
+
+		done := resource.StartAction(r, resource.CapabilityDelete)
+		err := ec2.DeleteVolume(ctx, volume.ID, volume.Region)
+		done()
+		if err != nil {
+			logger.Error("error deleting volume", "volume", volume.ID, "region", volume.Region, "error", err)
+		}
+		audit.Record(event, err)
+	}
+
+	orphanedResources.WithLabelValues("volume").Set(float64(orphaned))
+}
+
+// CleanSnapshots deletes the EBS snapshots whose cloudoff:ttl has elapsed since their
+// creation, and flags the snapshots of deleted volumes older than ORPHAN_MAX_AGE. The
+// backups, the snapshots of AMIs and the copies are never orphaned.
+func CleanSnapshots(ctx context.Context) {
+	snapshots, err := ec2.DiscoverSnapshots(ctx)
+	if err != nil {
+		logger.Error("error discovering snapshots", "error", err)
+		return
+	}
+
+	volumes, err := ec2.DiscoverVolumes(ctx)
+	if err != nil {
+		logger.Error("error discovering volumes", "error", err)
+		return
+	}
+
+	existingVolumes := map[string]bool{}
+	for _, volume := range volumes {
+		existingVolumes[volume.ID] = true
+	}
+
+	maxAge := orphanMaxAge()
+	orphaned := 0
+
+	for _, snapshot := range snapshots {
+		reason := cleanupReason(snapshot.Tags, snapshot.StartTime, unusedSnapshot(snapshot, existingVolumes), maxAge, time.Now())
+		if reason == "" {
+			continue
+		}
+
+		if reason == reasonOrphaned {
+			orphaned++
+			logger.Warn("orphaned snapshot", "snapshot", snapshot.ID, "region", snapshot.Region, "volume", snapshot.VolumeID, "startTime", snapshot.StartTime)
+			if !orphanDelete(orphanSnapshot) {
+				continue
+			}
+		}
+
+		r := resource.Resource{Kind: ec2.KindSnapshot, ID: snapshot.ID, Region: snapshot.Region}
+		event := cleanupEvent("delete", reason, r, snapshot.Tags)
+		if os.Getenv("DRYRUN") == "true" {
+			audit.Record(event, nil)
+			continue
+		}
+
+		done := resource.StartAction(r, resource.CapabilityDelete)
+		err := ec2.DeleteSnapshot(ctx, snapshot.ID, snapshot.Region)
+		done()
+		if err != nil {
+			logger.Error("error deleting snapshot", "snapshot", snapshot.ID, "region", snapshot.Region, "error", err)
+		}
+		audit.Record(event, err)
+	}
+
+	orphanedResources.WithLabelValues("snapshot").Set(float64(orphaned))
+}
+
+// CleanAddresses releases the unassociated Elastic IPs whose cloudoff:ttl has elapsed
+// since cloudoff first saw them unassociated, and flags the ones unassociated for
+// longer than ORPHAN_MAX_AGE.
+func CleanAddresses(ctx context.Context) {
+	addresses, err := ec2.DiscoverAddresses(ctx)
+	if err != nil {
+		logger.Error("error discovering addresses", "error", err)
+		return
+	}
+
+	maxAge := orphanMaxAge()
+	orphaned := 0
+
+	for _, address := range addresses {
+		// Reserved for a NAT gateway deleted by the scheduler during downtime
+		if _, _, reserved := address.ReservedNatGateway(); reserved {
+			continue
+		}
+
+		since, marked := address.UnassociatedSince()
+
+		if address.Associated {
+			// Reset the age of addresses associated again
+			if marked && os.Getenv("DRYRUN") != "true" {
+				if err := ec2.UnmarkAddressUnassociated(ctx, address); err != nil {
+					logger.Error("error untagging address", "address", address.AllocationID, "region", address.Region, "error", err)
+				}
+			}
+			continue
+		}
+
+		if !marked {
+			if os.Getenv("DRYRUN") != "true" {
+				if err := ec2.MarkAddressUnassociated(ctx, address, time.Now()); err != nil {
+					logger.Error("error tagging address", "address", address.AllocationID, "region", address.Region, "error", err)
+				}
+			}
+			continue
+		}
+
+		reason := cleanupReason(address.Tags, since, true, maxAge, time.Now())
+		if reason == "" {
+			continue
+		}
+
+		if reason == reasonOrphaned {
+			orphaned++
+			logger.Warn("orphaned address", "address", address.AllocationID, "publicIp", address.PublicIP, "region", address.Region, "unassociatedSince", since)
+			if !orphanDelete(orphanAddress) {
+				continue
+			}
+		}
+
+		r := resource.Resource{Kind: ec2.KindAddress, ID: address.AllocationID, Region: address.Region}
+		event := cleanupEvent("release", reason, r, address.Tags)
+		if os.Getenv("DRYRUN") == "true" {
+			audit.Record(event, nil)
+			continue
+		}
+
+		done := resource.StartAction(r, resource.CapabilityDelete)
+		err := ec2.ReleaseAddress(ctx, address.AllocationID, address.Region)
+		done()
+		if err != nil {
+			logger.Error("error releasing address", "address", address.AllocationID, "region", address.Region, "error", err)
+		}
+		audit.Record(event, err)
+	}
+
+	orphanedResources.WithLabelValues("address").Set(float64(orphaned))
+}
+
+// unusedSnapshot reports whether a snapshot can be orphaned: its volume is deleted and it
+// is neither a backup taken by AWS Backup, the snapshot of an AMI nor a copy, whose
+// volume is unknown. The snapshots taken before deleting a volume are kept with a ttl.
+func unusedSnapshot(snapshot ec2.Snapshot, existingVolumes map[string]bool) bool {
+	if snapshot.ImageID != "" || snapshot.Backup() || snapshot.Copy() {
+		return false
+	}
+	return !existingVolumes[snapshot.VolumeID]
+}
+
+// cleanupReason returns why a resource must be cleaned up, or an empty string when it
+// must be kept. A cloudoff:ttl tag always takes precedence over the orphan detection.
+func cleanupReason(tags []ec2.Tag, since time.Time, unused bool, maxAge time.Duration, currentTime time.Time) string {
+	for _, tag := range tags {
+		if tag.Key == "cloudoff:ttl" {
+			if TTLExceeded(tag.Value, since) {
+				return reasonTTL
+			}
+			return ""
+		}
+	}
+
+	if unused && maxAge > 0 && currentTime.Sub(since) > maxAge {
+		return reasonOrphaned
+	}
+
+	return ""
+}
+
+// cleanupEvent returns the audit event of the cleanup of a resource for the reason
+// returned by cleanupReason.
+func cleanupEvent(action, reason string, r resource.Resource, tags []ec2.Tag) audit.Event {
+	event := audit.NewEvent(audit.ActorCleaner, action, reason, r)
+	event.DryRun = os.Getenv("DRYRUN") == "true"
+	for _, tag := range tags {
+		if tag.Key == "cloudoff:ttl" {
+			return event.WithTag(tag.Key, tag.Value)
+		}
+	}
+	return event
+}
+
+// orphanMaxAge returns the age from which unused resources without cloudoff:ttl are
+// flagged, read from ORPHAN_MAX_AGE (ex. : "30d"). Zero disables the detection.
+func orphanMaxAge() time.Duration {
+	value := os.Getenv("ORPHAN_MAX_AGE")
+	if value == "" {
+		return 0
+	}
+
+	maxAge, err := parseDuration(value)
+	if err != nil {
+		logger.Error("invalid ORPHAN_MAX_AGE, orphan detection disabled", "value", value, "error", err)
+		return 0
+	}
+	return maxAge
+}
+
+// orphanDelete reports whether the orphaned resources of a type are deleted, read from
+// ORPHAN_DELETE (ex. : "volume,address"). They are only flagged by default.
+func orphanDelete(orphanType string) bool {
+	for _, value := range strings.Split(os.Getenv("ORPHAN_DELETE"), ",") {
+		switch value = strings.TrimSpace(value); value {
+		case orphanType:
+			return true
+		case "", orphanVolume, orphanSnapshot, orphanAddress:
+		default:
+			logger.Error("invalid ORPHAN_DELETE type, ignored", "value", value, "types", orphanVolume+","+orphanSnapshot+","+orphanAddress)
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package digest
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	ec2 "github.com/bananaops/cloudoff/internal/aws"
+	"github.com/bananaops/cloudoff/internal/clean"
+)
+
+var logger *slog.Logger
+
+// Options configures the content and the recipients of the digests.
+type Options struct {
+	// ExpiringWithin lists the instances whose ttl expires within this duration
+	ExpiringWithin time.Duration
+	// StoppedFor lists the instances stopped for longer than this duration
+	StoppedFor time.Duration
+	// EmailDomain is appended to the owners which are not email addresses
+	EmailDomain string
+	// DefaultRecipient receives the instances without owner tag, they are not reported when empty
+	DefaultRecipient string
+}
+
+// Item is an instance listed in a digest.
+type Item struct {
+	ID      string
+	Name    string
+	Region  string
+	Account string
+	State   string
+	// Since is the expiration of the ttl or the time the instance was stopped
+	Since time.Time
+	TTL   string
+	// Spot is how a spot instance is stopped (stop, recreate or unsupported), empty for
+	// the on-demand instances
+	Spot string
+	// Reason explains why the schedule of an unsupported spot instance is ignored
+	Reason string
+}
+
+// Report lists the instances of an owner which need attention.
+type Report struct {
+	Recipient string
+	Expiring  []Item
+	Stopped   []Item
+	Untagged  []Item
+	// Unsupported are the scheduled spot instances cloudoff can't stop
+	Unsupported []Item
+}
+
+// Count returns the number of instances of the report.
+func (r Report) Count() int {
+	return len(r.Expiring) + len(r.Stopped) + len(r.Untagged) + len(r.Unsupported)
+}
+
+// BuildReports groups by owner the instances whose ttl expires soon, the instances
+// stopped for a long time, the instances without any cloudoff tag and the scheduled
+// spot instances which can't be stopped.
+func BuildReports(instances []ec2.Instance, now time.Time, options Options) []Report {
+	reports := map[string]*Report{}
+
+	for _, instance := range instances {
+		recipient := options.recipient(instance.Tags)
+		if recipient == "" {
+			continue
+		}
+		report, ok := reports[recipient]
+		if !ok {
+			report = &Report{Recipient: recipient}
+			reports[recipient] = report
+		}
+
+		item := Item{
+			ID:      instance.ID,
+			Name:    instance.Name,
+			Region:  instance.Region,
+			Account: instance.Account,
+			State:   instance.State,
+		}
+
+		mode, reason := instance.SpotMode()
+		if instance.Spot {
+			item.Spot = string(mode)
+		}
+
+		if !hasCloudoffTag(instance.Tags) {
+			report.Untagged = append(report.Untagged, item)
+			continue
+		}
+
+		if mode == ec2.SpotModeUnsupported && hasSchedule(instance.Tags) {
+			item.Reason = reason
+			report.Unsupported = append(report.Unsupported, item)
+			continue
+		}
+
+		if ttl, ok := tagValue(instance.Tags, "cloudoff:ttl"); ok {
+			expiration, ok := clean.TTLExpiration(ttl, instance.TTLStart())
+			if ok && expiration.After(now) && expiration.Sub(now) <= options.ExpiringWithin {
+				item.TTL = ttl
+				item.Since = expiration
+				report.Expiring = append(report.Expiring, item)
+				continue
+			}
+		}
+
+		if instance.State == "stopped" && !instance.StoppedAt.IsZero() && now.Sub(instance.StoppedAt) >= options.StoppedFor {
+			item.Since = instance.StoppedAt
+			report.Stopped = append(report.Stopped, item)
+		}
+	}
+
+	var result []Report
+	for _, report := range reports {
+		if report.Count() > 0 {
+			result = append(result, *report)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Recipient < result[j].Recipient })
+	return result
+}
+
+// recipient returns the email address of the owner of an instance.
+func (o Options) recipient(tags []ec2.Tag) string {
+	owner, ok := tagValue(tags, "owner")
+	owner = strings.TrimSpace(owner)
+	if !ok || owner == "" {
+		return o.DefaultRecipient
+	}
+	if !strings.Contains(owner, "@") && o.EmailDomain != "" {
+		return fmt.Sprintf("%s@%s", owner, o.EmailDomain)
+	}
+	return owner
+}
+
+func hasCloudoffTag(tags []ec2.Tag) bool {
+	for _, tag := range tags {
+		if tag.Key == "cloudoff:uptime" || tag.Key == "cloudoff:downtime" || tag.Key == "cloudoff:ttl" {
+			return true
+		}
+	}
+	return false
+}
+
+func hasSchedule(tags []ec2.Tag) bool {
+	for _, tag := range tags {
+		if tag.Key == "cloudoff:uptime" || tag.Key == "cloudoff:downtime" {
+			return true
+		}
+	}
+	return false
+}
+
+func tagValue(tags []ec2.Tag, key string) (string, bool) {
+	for _, tag := range tags {
+		if tag.Key == key {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+func init() {
+	logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+}
@@ -0,0 +1,60 @@
+package digest
+
+import (
+	"testing"
+	"time"
+
+	ec2 "github.com/bananaops/cloudoff/internal/aws"
+)
+
+func TestBuildReports(t *testing.T) {
+	now := time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC)
+	options := Options{
+		ExpiringWithin:   48 * time.Hour,
+		StoppedFor:       14 * 24 * time.Hour,
+		EmailDomain:      "example.com",
+		DefaultRecipient: "platform@example.com",
+	}
+
+	instances := []ec2.Instance{
+		{ID: "i-expiring", State: "running", AttachTime: now.Add(-24 * time.Hour), Tags: []ec2.Tag{{Key: "cloudoff:ttl", Value: "2d"}, {Key: "owner", Value: "alice"}}},
+		{ID: "i-later", State: "running", AttachTime: now, Tags: []ec2.Tag{{Key: "cloudoff:ttl", Value: "1w"}, {Key: "owner", Value: "alice"}}},
+		{ID: "i-stopped", State: "stopped", StoppedAt: now.AddDate(0, 0, -21), Tags: []ec2.Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"}, {Key: "owner", Value: "bob@example.org"}}},
+		{ID: "i-stopped-yesterday", State: "stopped", StoppedAt: now.AddDate(0, 0, -1), Tags: []ec2.Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"}, {Key: "owner", Value: "bob@example.org"}}},
+		{ID: "i-untagged", State: "running", Tags: []ec2.Tag{{Key: "owner", Value: "alice"}}},
+		{ID: "i-orphan", State: "running"},
+		{ID: "i-spot", State: "running", Spot: true, SpotRequestType: "one-time", Tags: []ec2.Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"}, {Key: "owner", Value: "bob@example.org"}}},
+	}
+
+	reports := BuildReports(instances, now, options)
+	if len(reports) != 3 {
+		t.Fatalf("expected 3 reports, got %+v", reports)
+	}
+
+	alice := reports[0]
+	if alice.Recipient != "alice@example.com" || len(alice.Expiring) != 1 || alice.Expiring[0].ID != "i-expiring" || len(alice.Untagged) != 1 {
+		t.Errorf("unexpected report %+v", alice)
+	}
+	if !alice.Expiring[0].Since.Equal(now.Add(24 * time.Hour)) {
+		t.Errorf("unexpected expiration %v", alice.Expiring[0].Since)
+	}
+
+	bob := reports[1]
+	if bob.Recipient != "bob@example.org" || len(bob.Stopped) != 1 || bob.Stopped[0].ID != "i-stopped" || bob.Count() != 2 {
+		t.Errorf("unexpected report %+v", bob)
+	}
+	if len(bob.Unsupported) != 1 || bob.Unsupported[0].Spot != "unsupported" || bob.Unsupported[0].Reason == "" {
+		t.Errorf("expected the one-time spot instance reported as unsupported, got %+v", bob.Unsupported)
+	}
+
+	platform := reports[2]
+	if platform.Recipient != "platform@example.com" || len(platform.Untagged) != 1 || platform.Untagged[0].ID != "i-orphan" {
+		t.Errorf("unexpected report %+v", platform)
+	}
+
+	// Instances without owner are not reported without a default recipient
+	options.DefaultRecipient = ""
+	if reports := BuildReports(instances[5:6], now, options); len(reports) != 0 {
+		t.Errorf("expected no report, got %+v", reports)
+	}
+}
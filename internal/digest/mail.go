@@ -0,0 +1,92 @@
+package digest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	ec2 "github.com/bananaops/cloudoff/internal/aws"
+)
+
+var bodyTemplate = template.Must(template.New("digest").Parse(`Hello,
+
+cloudoff found {{.Count}} instances owned by you which need attention.
+{{with .Expiring}}
+Instances deleted soon, extend their cloudoff:ttl tag to keep them:
+{{range .}}  - {{.Name}} ({{.ID}}, {{.Region}}{{with .Spot}}, spot {{.}}{{end}}) expires at {{.Since.Format "2006-01-02 15:04 MST"}} (ttl {{.TTL}})
+{{end}}{{end}}{{with .Stopped}}
+Instances stopped for a long time, terminate them if they are no longer needed:
+{{range .}}  - {{.Name}} ({{.ID}}, {{.Region}}{{with .Spot}}, spot {{.}}{{end}}) stopped since {{.Since.Format "2006-01-02"}}
+{{end}}{{end}}{{with .Untagged}}
+Instances without cloudoff tag, add a cloudoff:uptime, cloudoff:downtime or cloudoff:ttl tag to save costs:
+{{range .}}  - {{.Name}} ({{.ID}}, {{.Region}}{{with .Spot}}, spot {{.}}{{end}}) {{.State}}
+{{end}}{{end}}{{with .Unsupported}}
+Spot instances whose schedule is ignored, cloudoff can't stop them:
+{{range .}}  - {{.Name}} ({{.ID}}, {{.Region}}) {{.Reason}}
+{{end}}{{end}}`))
+
+// Mailer sends the digests through an SMTP server.
+type Mailer struct {
+	// Addr is the host:port of the SMTP server
+	Addr string
+	// Auth is nil when the server doesn't require authentication
+	Auth smtp.Auth
+	From string
+}
+
+// NewMailer returns a mailer using the PLAIN authentication when a username is given.
+func NewMailer(host, port, username, password, from string) *Mailer {
+	mailer := &Mailer{Addr: net.JoinHostPort(host, port), From: from}
+	if username != "" {
+		mailer.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer
+}
+
+// Send sends a report to its recipient.
+func (m *Mailer) Send(report Report, now time.Time) error {
+	var body bytes.Buffer
+	if err := bodyTemplate.Execute(&body, report); err != nil {
+		return fmt.Errorf("error rendering digest: %v", err)
+	}
+
+	subject := fmt.Sprintf("cloudoff digest: %d instances need attention", report.Count())
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", m.From)
+	fmt.Fprintf(&message, "To: %s\r\n", report.Recipient)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+
+	if err := smtp.SendMail(m.Addr, m.Auth, m.From, []string{report.Recipient}, []byte(message.String())); err != nil {
+		return fmt.Errorf("error sending digest to %s: %v", report.Recipient, err)
+	}
+	return nil
+}
+
+// SendDigests discovers the EC2 instances and sends a digest to each owner with
+// instances which need attention.
+func SendDigests(ctx context.Context, mailer *Mailer, options Options) {
+	instances, err := ec2.DiscoverEC2Instances(ctx)
+	if err != nil {
+		logger.Error("error discovering instances", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, report := range BuildReports(instances, now, options) {
+		if err := mailer.Send(report, now); err != nil {
+			logger.Error("error sending digest", "recipient", report.Recipient, "error", err)
+			continue
+		}
+		logger.Info("digest sent", "recipient", report.Recipient, "instances", report.Count())
+	}
+}
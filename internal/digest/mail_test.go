@@ -0,0 +1,133 @@
+package digest
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpServer is a local SMTP stand-in accepting any message.
+type smtpServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	messages []smtpMessage
+}
+
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &smtpServer{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	var message smtpMessage
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message.from = strings.Trim(strings.TrimSpace(line)[10:], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			message.to = append(message.to, strings.Trim(strings.TrimSpace(line)[8:], "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			message.data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+			message = smtpMessage{}
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestMailerSend(t *testing.T) {
+	server := newSMTPServer(t)
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	mailer := NewMailer(host, port, "", "", "cloudoff@example.com")
+
+	now := time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC)
+	report := Report{
+		Recipient:   "alice@example.com",
+		Expiring:    []Item{{ID: "i-expiring", Name: "review-42", Region: "eu-west-1", TTL: "2d", Since: now.Add(24 * time.Hour)}},
+		Untagged:    []Item{{ID: "i-untagged", Name: "sandbox", Region: "eu-west-1", State: "running", Spot: "stop"}},
+		Unsupported: []Item{{ID: "i-spot", Name: "batch", Region: "eu-west-1", Spot: "unsupported", Reason: "spot instance managed by fleet sfr-1, schedule the fleet instead"}},
+	}
+	if err := mailer.Send(report, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.messages) != 1 {
+		t.Fatalf("expected one message, got %d", len(server.messages))
+	}
+	message := server.messages[0]
+	if message.from != "cloudoff@example.com" || len(message.to) != 1 || message.to[0] != "alice@example.com" {
+		t.Errorf("unexpected envelope %+v", message)
+	}
+	for _, expected := range []string{
+		"Subject: cloudoff digest: 3 instances need attention",
+		"review-42 (i-expiring, eu-west-1) expires at 2025-01-07 08:00 UTC (ttl 2d)",
+		"sandbox (i-untagged, eu-west-1, spot stop) running",
+		"batch (i-spot, eu-west-1) spot instance managed by fleet sfr-1, schedule the fleet instead",
+	} {
+		if !strings.Contains(message.data, expected) {
+			t.Errorf("expected %q in %q", expected, message.data)
+		}
+	}
+	if strings.Contains(message.data, "stopped for a long time") {
+		t.Errorf("expected no stopped section in %q", message.data)
+	}
+}
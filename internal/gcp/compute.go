@@ -0,0 +1,233 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/resource"
+	"golang.org/x/oauth2/google"
+)
+
+var logger *slog.Logger
+
+// KindInstance is the kind of the resources discovered by ComputeProvider
+const KindInstance = "gce-instance"
+
+// DefaultEndpoint is the endpoint of the Compute Engine API
+const DefaultEndpoint = "https://compute.googleapis.com"
+
+const computeScope = "https://www.googleapis.com/auth/compute"
+
+type Instance struct {
+	ID                string
+	Name              string
+	Project           string
+	Zone              string
+	Status            string
+	Spot              bool
+	CreationTimestamp time.Time
+	Labels            map[string]string
+}
+
+// ComputeProvider manages the Compute Engine instances of a project.
+type ComputeProvider struct {
+	client   *http.Client
+	endpoint string
+	project  string
+}
+
+// NewComputeProvider returns a provider authenticated with the Application Default Credentials.
+func NewComputeProvider(ctx context.Context, project string) (*ComputeProvider, error) {
+	client, err := google.DefaultClient(ctx, computeScope)
+	if err != nil {
+		return nil, fmt.Errorf("error loading google credentials: %v", err)
+	}
+	return NewComputeProviderWithClient(client, DefaultEndpoint, project), nil
+}
+
+// NewComputeProviderWithClient returns a provider sending its requests to the given endpoint.
+func NewComputeProviderWithClient(client *http.Client, endpoint, project string) *ComputeProvider {
+	return &ComputeProvider{
+		client:   client,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		project:  project,
+	}
+}
+
+func (p *ComputeProvider) Kind() string { return KindInstance }
+
+type instanceList struct {
+	Items map[string]struct {
+		Instances []struct {
+			ID                string            `json:"id"`
+			Name              string            `json:"name"`
+			Zone              string            `json:"zone"`
+			Status            string            `json:"status"`
+			CreationTimestamp string            `json:"creationTimestamp"`
+			Labels            map[string]string `json:"labels"`
+			Scheduling        struct {
+				Preemptible       bool   `json:"preemptible"`
+				ProvisioningModel string `json:"provisioningModel"`
+			} `json:"scheduling"`
+		} `json:"instances"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// DiscoverInstances returns the instances of every zone of the project.
+func (p *ComputeProvider) DiscoverInstances(ctx context.Context) ([]Instance, error) {
+	var listInstances []Instance
+
+	pageToken := ""
+	for {
+		query := url.Values{}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var page instanceList
+		err := p.do(ctx, http.MethodGet, fmt.Sprintf("/compute/v1/projects/%s/aggregated/instances?%s", p.project, query.Encode()), &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list instances of project %s: %v", p.project, err)
+		}
+
+		for _, scope := range page.Items {
+			for _, instance := range scope.Instances {
+				creation, _ := time.Parse(time.RFC3339, instance.CreationTimestamp)
+				listInstances = append(listInstances, Instance{
+					ID:                instance.ID,
+					Name:              instance.Name,
+					Project:           p.project,
+					Zone:              path.Base(instance.Zone),
+					Status:            instance.Status,
+					Spot:              instance.Scheduling.Preemptible || instance.Scheduling.ProvisioningModel == "SPOT",
+					CreationTimestamp: creation,
+					Labels:            instance.Labels,
+				})
+			}
+		}
+
+		if page.NextPageToken == "" {
+			return listInstances, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+func (p *ComputeProvider) Discover(ctx context.Context) ([]resource.Resource, error) {
+	instances, err := p.DiscoverInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []resource.Resource
+	for _, instance := range instances {
+		tags, err := LabelsToTags(instance.Labels)
+		if err != nil {
+			logger.Error("error reading labels", "instance", instance.Name, "zone", instance.Zone, "error", err)
+			continue
+		}
+
+		// TERMINATED is the status of a stopped instance, other statuses are transitions
+		state := resource.StateUnknown
+		switch instance.Status {
+		case "RUNNING":
+			state = resource.StateRunning
+		case "TERMINATED":
+			state = resource.StateStopped
+		}
+
+		resources = append(resources, resource.Resource{
+			ID:       instance.Zone + "/" + instance.Name,
+			Kind:     KindInstance,
+			Region:   zoneRegion(instance.Zone),
+			Account:  instance.Project,
+			Tags:     tags,
+			State:    state,
+			TTLStart: instance.CreationTimestamp,
+			Object:   instance,
+		})
+	}
+	return resources, nil
+}
+
+func (p *ComputeProvider) Stop(ctx context.Context, r resource.Resource) error {
+	instance := r.Object.(Instance)
+	err := p.do(ctx, http.MethodPost, p.instancePath(instance)+"/stop", nil)
+	if err != nil {
+		return fmt.Errorf("error stopping instance %s: %v", instance.Name, err)
+	}
+	logger.Info("instance stopped successfully", "instance", instance.Name, "zone", instance.Zone, "project", instance.Project)
+	return nil
+}
+
+func (p *ComputeProvider) Start(ctx context.Context, r resource.Resource) error {
+	instance := r.Object.(Instance)
+	err := p.do(ctx, http.MethodPost, p.instancePath(instance)+"/start", nil)
+	if err != nil {
+		return fmt.Errorf("error starting instance %s: %v", instance.Name, err)
+	}
+	logger.Info("instance started successfully", "instance", instance.Name, "zone", instance.Zone, "project", instance.Project)
+	return nil
+}
+
+func (p *ComputeProvider) Delete(ctx context.Context, r resource.Resource) error {
+	instance := r.Object.(Instance)
+	err := p.do(ctx, http.MethodDelete, p.instancePath(instance), nil)
+	if err != nil {
+		return fmt.Errorf("error deleting instance %s: %v", instance.Name, err)
+	}
+	logger.Info("instance deleted successfully", "instance", instance.Name, "zone", instance.Zone, "project", instance.Project)
+	return nil
+}
+
+func (p *ComputeProvider) instancePath(instance Instance) string {
+	return fmt.Sprintf("/compute/v1/projects/%s/zones/%s/instances/%s", instance.Project, instance.Zone, instance.Name)
+}
+
+// do sends a request to the Compute API and decodes the response in out when not nil.
+// Actions return an operation which is not waited for.
+func (p *ComputeProvider) do(ctx context.Context, method, requestPath string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, p.endpoint+requestPath, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("compute api returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// zoneRegion returns the region of a zone ("europe-west1-b" is in "europe-west1").
+func zoneRegion(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+func init() {
+	logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+}
@@ -0,0 +1,131 @@
+package gcp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+const aggregatedInstances = `{
+  "items": {
+    "zones/europe-west1-b": {
+      "instances": [
+        {
+          "id": "1",
+          "name": "web",
+          "zone": "https://www.googleapis.com/compute/v1/projects/demo/zones/europe-west1-b",
+          "status": "RUNNING",
+          "creationTimestamp": "2024-05-01T10:00:00.000-07:00",
+          "labels": {"cloudoff-downtime": "mon-fri_2000-2359"}
+        },
+        {
+          "id": "2",
+          "name": "batch",
+          "zone": "https://www.googleapis.com/compute/v1/projects/demo/zones/europe-west1-b",
+          "status": "TERMINATED",
+          "creationTimestamp": "2024-05-01T10:00:00.000-07:00",
+          "labels": {"cloudoff-ttl": "1d"},
+          "scheduling": {"provisioningModel": "SPOT"}
+        }
+      ]
+    },
+    "zones/us-east1-c": {
+      "warning": {"code": "NO_RESULTS_ON_PAGE"}
+    }
+  }
+}`
+
+func newTestProvider(t *testing.T, requests *[]string) *ComputeProvider {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/compute/v1/projects/demo/aggregated/instances":
+			w.Write([]byte(aggregatedInstances)) //nolint:errcheck
+		case r.URL.Path == "/compute/v1/projects/demo/zones/europe-west1-b/instances/missing":
+			http.Error(w, `{"error":{"code":404}}`, http.StatusNotFound)
+		default:
+			w.Write([]byte(`{"kind":"compute#operation","status":"RUNNING"}`)) //nolint:errcheck
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return NewComputeProviderWithClient(server.Client(), server.URL, "demo")
+}
+
+func TestComputeProviderDiscover(t *testing.T) {
+	var requests []string
+	provider := newTestProvider(t, &requests)
+
+	resources, err := provider.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resources) != 2 {
+		t.Fatalf("expected 2 resources, got %d", len(resources))
+	}
+
+	byName := map[string]resource.Resource{}
+	for _, r := range resources {
+		byName[r.Object.(Instance).Name] = r
+	}
+
+	web := byName["web"]
+	if web.ID != "europe-west1-b/web" || web.Region != "europe-west1" || web.Account != "demo" {
+		t.Errorf("unexpected resource %+v", web)
+	}
+	if web.State != resource.StateRunning {
+		t.Errorf("expected web to be running, got %v", web.State)
+	}
+	if value, _ := web.TagValue("cloudoff:downtime"); value != "Mon-Fri_20:00-23:59" {
+		t.Errorf("unexpected downtime %q", value)
+	}
+	if web.TTLStart.IsZero() {
+		t.Errorf("expected the creation timestamp as ttl start")
+	}
+
+	batch := byName["batch"]
+	if batch.State != resource.StateStopped {
+		t.Errorf("expected batch to be stopped, got %v", batch.State)
+	}
+	if !batch.Object.(Instance).Spot {
+		t.Errorf("expected batch to be a spot instance")
+	}
+}
+
+func TestComputeProviderActions(t *testing.T) {
+	var requests []string
+	provider := newTestProvider(t, &requests)
+
+	r := resource.Resource{Object: Instance{Name: "web", Project: "demo", Zone: "europe-west1-b"}}
+	if err := provider.Stop(context.Background(), r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := provider.Start(context.Background(), r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := provider.Delete(context.Background(), r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"POST /compute/v1/projects/demo/zones/europe-west1-b/instances/web/stop",
+		"POST /compute/v1/projects/demo/zones/europe-west1-b/instances/web/start",
+		"DELETE /compute/v1/projects/demo/zones/europe-west1-b/instances/web",
+	}
+	if len(requests) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, requests)
+	}
+	for i := range expected {
+		if requests[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], requests[i])
+		}
+	}
+
+	missing := resource.Resource{Object: Instance{Name: "missing", Project: "demo", Zone: "europe-west1-b"}}
+	if err := provider.Delete(context.Background(), missing); err == nil {
+		t.Errorf("expected an error for a missing instance")
+	}
+}
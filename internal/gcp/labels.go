@@ -0,0 +1,106 @@
+package gcp
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+// Labels read by cloudoff on Compute Engine instances
+const (
+	UptimeLabel   = "cloudoff-uptime"
+	DowntimeLabel = "cloudoff-downtime"
+	TTLLabel      = "cloudoff-ttl"
+)
+
+// DecodeSchedule converts a schedule stored in a label value to the format of the
+// cloudoff:uptime and cloudoff:downtime tags. Label values only accept lowercase
+// letters, digits, "-" and "_", so schedules are encoded as follows:
+//
+//   - hours are written without colon ("0800-2000" for "08:00-20:00")
+//   - the "/" of the timezone is written "--" ("europe--paris" for "Europe/Paris")
+//   - entries are separated by "__" instead of ","
+//
+// For example "mon-fri_0800-2000_europe--paris__sat_1000-1200" is decoded to
+// "Mon-Fri_08:00-20:00_Europe/Paris,Sat_10:00-12:00".
+func DecodeSchedule(value string) (string, error) {
+	var entries []string
+
+	for _, entry := range strings.Split(value, "__") {
+		if entry == "infinity" {
+			entries = append(entries, entry)
+			continue
+		}
+
+		parts := strings.SplitN(entry, "_", 3)
+		if len(parts) < 2 {
+			return "", fmt.Errorf("invalid schedule label : %s", entry)
+		}
+
+		days := titleWords(parts[0])
+
+		hours := strings.Split(parts[1], "-")
+		if len(hours) != 2 || len(hours[0]) != 4 || len(hours[1]) != 4 {
+			return "", fmt.Errorf("invalid hours in schedule label : %s", parts[1])
+		}
+		decoded := fmt.Sprintf("%s_%s:%s-%s:%s", days, hours[0][:2], hours[0][2:], hours[1][:2], hours[1][2:])
+
+		if len(parts) == 3 {
+			decoded += "_" + decodeTimezone(parts[2])
+		}
+		entries = append(entries, decoded)
+	}
+
+	return strings.Join(entries, ","), nil
+}
+
+// decodeTimezone restores the case of an IANA timezone name ("america--new_york" for
+// "America/New_York"). Names with lowercase words such as "America/Port-au-Prince"
+// can't be restored and must be replaced by an equivalent timezone.
+func decodeTimezone(value string) string {
+	if value == "utc" {
+		return "UTC"
+	}
+	return titleWords(strings.ReplaceAll(value, "--", "/"))
+}
+
+// titleWords capitalizes the first letter of each word separated by "/", "_" or "-".
+func titleWords(value string) string {
+	runes := []rune(value)
+	for i := range runes {
+		if i == 0 || runes[i-1] == '/' || runes[i-1] == '_' || runes[i-1] == '-' {
+			runes[i] = unicode.ToUpper(runes[i])
+		}
+	}
+	return string(runes)
+}
+
+// LabelsToTags converts the labels of an instance to the tags understood by the
+// scheduler and the cleaner. Labels with an invalid schedule are skipped.
+func LabelsToTags(labels map[string]string) ([]resource.Tag, error) {
+	var tags []resource.Tag
+	var errs []string
+
+	for key, value := range labels {
+		switch key {
+		case UptimeLabel, DowntimeLabel:
+			schedule, err := DecodeSchedule(value)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", key, err))
+				continue
+			}
+			tags = append(tags, resource.Tag{Key: "cloudoff:" + strings.TrimPrefix(key, "cloudoff-"), Value: schedule})
+		case TTLLabel:
+			tags = append(tags, resource.Tag{Key: "cloudoff:ttl", Value: value})
+		default:
+			tags = append(tags, resource.Tag{Key: key, Value: value})
+		}
+	}
+
+	if len(errs) > 0 {
+		return tags, fmt.Errorf("invalid labels : %s", strings.Join(errs, ", "))
+	}
+	return tags, nil
+}
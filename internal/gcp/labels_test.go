@@ -0,0 +1,65 @@
+package gcp
+
+import (
+	"testing"
+)
+
+func TestDecodeSchedule(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		expected  string
+		expectErr bool
+	}{
+		{"Days and hours", "mon-fri_0800-2000", "Mon-Fri_08:00-20:00", false},
+		{"With timezone", "mon-fri_0800-2000_europe--paris", "Mon-Fri_08:00-20:00_Europe/Paris", false},
+		{"Timezone with underscore", "sat_1000-1200_america--new_york", "Sat_10:00-12:00_America/New_York", false},
+		{"UTC", "sun_0000-2359_utc", "Sun_00:00-23:59_UTC", false},
+		{"Several entries", "mon-fri_0800-2000_europe--paris__sat_1000-1200", "Mon-Fri_08:00-20:00_Europe/Paris,Sat_10:00-12:00", false},
+		{"Infinity", "infinity", "infinity", false},
+		{"Missing hours", "mon-fri", "", true},
+		{"Hours with colon encoding", "mon_800-2000", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := DecodeSchedule(tt.input)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("expected error: %v, got: %v", tt.expectErr, err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestLabelsToTags(t *testing.T) {
+	tags, err := LabelsToTags(map[string]string{
+		"cloudoff-downtime": "mon-fri_2000-2359",
+		"cloudoff-ttl":      "7d",
+		"team":              "data",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"cloudoff:downtime": "Mon-Fri_20:00-23:59",
+		"cloudoff:ttl":      "7d",
+		"team":              "data",
+	}
+	if len(tags) != len(expected) {
+		t.Fatalf("expected %d tags, got %v", len(expected), tags)
+	}
+	for _, tag := range tags {
+		if expected[tag.Key] != tag.Value {
+			t.Errorf("unexpected tag %s=%s", tag.Key, tag.Value)
+		}
+	}
+
+	_, err = LabelsToTags(map[string]string{"cloudoff-uptime": "invalid"})
+	if err == nil {
+		t.Errorf("expected an error for an invalid schedule")
+	}
+}
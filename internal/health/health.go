@@ -0,0 +1,182 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var logger *slog.Logger
+
+var (
+	mu                  sync.RWMutex
+	lastCycle           time.Time
+	lastSuccess         time.Time
+	consecutiveFailures int
+	lastError           string
+)
+
+// RecordCycle records the result of a reconcile cycle, which fails when the resources
+// of a provider can't be discovered.
+func RecordCycle(err error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	lastCycle = time.Now()
+	if err != nil {
+		consecutiveFailures++
+		lastError = err.Error()
+		return
+	}
+	lastSuccess = lastCycle
+	consecutiveFailures = 0
+	lastError = ""
+}
+
+// ResetCycles removes the recorded cycles.
+func ResetCycles() {
+	mu.Lock()
+	defer mu.Unlock()
+	lastCycle, lastSuccess = time.Time{}, time.Time{}
+	consecutiveFailures = 0
+	lastError = ""
+}
+
+// Status is the body of the health and readiness endpoints.
+type Status struct {
+	Status              string     `json:"status"`
+	LastCycle           *time.Time `json:"lastCycle,omitempty"`
+	LastSuccess         *time.Time `json:"lastSuccess,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastError           string     `json:"lastError,omitempty"`
+	CredentialsError    string     `json:"credentialsError,omitempty"`
+}
+
+// Checker serves the health and readiness of cloudoff.
+type Checker struct {
+	// threshold is the number of consecutive failed cycles after which cloudoff isn't ready
+	threshold int
+	// stallTimeout is the duration without cycle after which cloudoff isn't healthy
+	stallTimeout time.Duration
+	// credentials checks the cloud credentials, at most once per credentialsInterval
+	credentials         func(ctx context.Context) error
+	credentialsInterval time.Duration
+
+	started time.Time
+	now     func() time.Time
+
+	credentialsMu      sync.Mutex
+	credentialsChecked time.Time
+	credentialsErr     error
+}
+
+func NewChecker(threshold int, stallTimeout time.Duration, credentials func(ctx context.Context) error) *Checker {
+	return &Checker{
+		threshold:           threshold,
+		stallTimeout:        stallTimeout,
+		credentials:         credentials,
+		credentialsInterval: 5 * time.Minute,
+		started:             time.Now(),
+		now:                 time.Now,
+	}
+}
+
+// Handler returns the /healthz and /readyz endpoints.
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", c.healthz)
+	mux.HandleFunc("GET /readyz", c.readyz)
+	return mux
+}
+
+// healthz fails when no cycle has completed for stallTimeout, the cycles are then stuck
+// and restarting cloudoff is the only way out.
+func (c *Checker) healthz(w http.ResponseWriter, r *http.Request) {
+	status := c.status()
+
+	since := c.started
+	if status.LastCycle != nil {
+		since = *status.LastCycle
+	}
+	if c.now().Sub(since) > c.stallTimeout {
+		status.Status = "stalled"
+		writeStatus(w, http.StatusServiceUnavailable, status)
+		return
+	}
+
+	status.Status = "ok"
+	writeStatus(w, http.StatusOK, status)
+}
+
+// readyz fails when the last cycles failed or the credentials are invalid.
+func (c *Checker) readyz(w http.ResponseWriter, r *http.Request) {
+	status := c.status()
+
+	if err := c.checkCredentials(r.Context()); err != nil {
+		status.CredentialsError = err.Error()
+	}
+
+	if status.CredentialsError != "" || (c.threshold > 0 && status.ConsecutiveFailures >= c.threshold) {
+		status.Status = "not ready"
+		writeStatus(w, http.StatusServiceUnavailable, status)
+		return
+	}
+
+	status.Status = "ready"
+	writeStatus(w, http.StatusOK, status)
+}
+
+func (c *Checker) status() Status {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	status := Status{ConsecutiveFailures: consecutiveFailures, LastError: lastError}
+	if !lastCycle.IsZero() {
+		cycle := lastCycle
+		status.LastCycle = &cycle
+	}
+	if !lastSuccess.IsZero() {
+		success := lastSuccess
+		status.LastSuccess = &success
+	}
+	return status
+}
+
+// checkCredentials returns the result of the last credentials check, refreshed every
+// credentialsInterval so probes don't call the cloud APIs on each request.
+func (c *Checker) checkCredentials(ctx context.Context) error {
+	if c.credentials == nil {
+		return nil
+	}
+
+	c.credentialsMu.Lock()
+	defer c.credentialsMu.Unlock()
+
+	if !c.credentialsChecked.IsZero() && c.now().Sub(c.credentialsChecked) < c.credentialsInterval {
+		return c.credentialsErr
+	}
+
+	c.credentialsErr = c.credentials(ctx)
+	c.credentialsChecked = c.now()
+	if c.credentialsErr != nil {
+		logger.Error("error checking credentials", "error", c.credentialsErr)
+	}
+	return c.credentialsErr
+}
+
+func writeStatus(w http.ResponseWriter, code int, status Status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logger.Error("error writing response", "error", err)
+	}
+}
+
+func init() {
+	logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+}
@@ -0,0 +1,106 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, c *Checker, path string) (int, Status) {
+	recorder := httptest.NewRecorder()
+	c.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var status Status
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	return recorder.Code, status
+}
+
+func TestReadyz(t *testing.T) {
+	ResetCycles()
+	defer ResetCycles()
+
+	checker := NewChecker(2, 10*time.Minute, nil)
+
+	if code, _ := get(t, checker, "/readyz"); code != http.StatusOK {
+		t.Errorf("expected ready before the first cycle, got %d", code)
+	}
+
+	RecordCycle(nil)
+	RecordCycle(errors.New("access denied"))
+	code, status := get(t, checker, "/readyz")
+	if code != http.StatusOK || status.ConsecutiveFailures != 1 || status.LastSuccess == nil {
+		t.Errorf("expected ready after one failure, got %d %+v", code, status)
+	}
+
+	RecordCycle(errors.New("access denied"))
+	code, status = get(t, checker, "/readyz")
+	if code != http.StatusServiceUnavailable || status.LastError != "access denied" {
+		t.Errorf("expected not ready after two failures, got %d %+v", code, status)
+	}
+
+	RecordCycle(nil)
+	if code, _ := get(t, checker, "/readyz"); code != http.StatusOK {
+		t.Errorf("expected ready after a successful cycle, got %d", code)
+	}
+}
+
+func TestReadyzCredentials(t *testing.T) {
+	ResetCycles()
+	defer ResetCycles()
+
+	calls := 0
+	credentialsErr := errors.New("expired token")
+	checker := NewChecker(3, 10*time.Minute, func(ctx context.Context) error {
+		calls++
+		return credentialsErr
+	})
+	now := time.Now()
+	checker.now = func() time.Time { return now }
+
+	code, status := get(t, checker, "/readyz")
+	if code != http.StatusServiceUnavailable || status.CredentialsError != "expired token" {
+		t.Errorf("expected not ready with invalid credentials, got %d %+v", code, status)
+	}
+
+	// The result is cached until the next check
+	credentialsErr = nil
+	if code, _ := get(t, checker, "/readyz"); code != http.StatusServiceUnavailable || calls != 1 {
+		t.Errorf("expected the cached result, got %d after %d calls", code, calls)
+	}
+
+	now = now.Add(5 * time.Minute)
+	if code, _ := get(t, checker, "/readyz"); code != http.StatusOK || calls != 2 {
+		t.Errorf("expected ready after a new check, got %d after %d calls", code, calls)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	ResetCycles()
+	defer ResetCycles()
+
+	checker := NewChecker(3, 10*time.Minute, nil)
+	now := time.Now()
+	checker.now = func() time.Time { return now }
+
+	if code, _ := get(t, checker, "/healthz"); code != http.StatusOK {
+		t.Errorf("expected healthy at startup, got %d", code)
+	}
+
+	// A failed cycle still proves the cycles are running
+	RecordCycle(errors.New("access denied"))
+	now = now.Add(9 * time.Minute)
+	if code, _ := get(t, checker, "/healthz"); code != http.StatusOK {
+		t.Errorf("expected healthy after a recent cycle, got %d", code)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if code, status := get(t, checker, "/healthz"); code != http.StatusServiceUnavailable || status.Status != "stalled" {
+		t.Errorf("expected stalled without cycle, got %d %+v", code, status)
+	}
+}
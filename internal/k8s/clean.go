@@ -0,0 +1,56 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/bananaops/cloudoff/internal/audit"
+	"github.com/bananaops/cloudoff/internal/clean"
+	"github.com/bananaops/cloudoff/internal/resource"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// protectedNamespaces are never deleted, whatever their annotations.
+var protectedNamespaces = []string{"default", "kube-system", "kube-public", "kube-node-lease"}
+
+// Clean deletes the namespaces whose cloudoff/ttl annotation has elapsed since their creation.
+func (s *Scheduler) Clean(ctx context.Context) error {
+
+	namespaces, err := s.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %v", err)
+	}
+
+	for _, namespace := range namespaces.Items {
+		ttl, ok := namespace.Annotations[TTLAnnotation]
+		if !ok {
+			continue
+		}
+
+		if slices.Contains(protectedNamespaces, namespace.Name) || namespace.Status.Phase == corev1.NamespaceTerminating {
+			continue
+		}
+
+		if !clean.TTLExceeded(ttl, namespace.CreationTimestamp.Time) {
+			continue
+		}
+
+		event := audit.NewEvent(audit.ActorCleaner, string(resource.CapabilityDelete), audit.ReasonTTL, resource.Resource{Kind: KindNamespace, ID: namespace.Name})
+		event = event.WithTag(TTLAnnotation, ttl)
+		if s.dryRun {
+			event.DryRun = true
+			audit.Record(event, nil)
+			continue
+		}
+
+		err := s.client.CoreV1().Namespaces().Delete(ctx, namespace.Name, metav1.DeleteOptions{})
+		if err != nil {
+			logger.Error("error deleting namespace", "namespace", namespace.Name, "error", err)
+		}
+		audit.Record(event, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package k8s
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	ec2 "github.com/bananaops/cloudoff/internal/aws"
+	"github.com/bananaops/cloudoff/internal/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var logger *slog.Logger
+
+// Annotations read and written by cloudoff on Kubernetes objects
+const (
+	UptimeAnnotation        = "cloudoff/uptime"
+	DowntimeAnnotation      = "cloudoff/downtime"
+	TTLAnnotation           = "cloudoff/ttl"
+	SavedReplicasAnnotation = "cloudoff/saved-replicas"
+	SuspendedAnnotation     = "cloudoff/suspended"
+)
+
+// Kinds of the Kubernetes objects in the audit events
+const (
+	KindDeployment  = "k8s-deployment"
+	KindStatefulSet = "k8s-statefulset"
+	KindCronJob     = "k8s-cronjob"
+	KindNamespace   = "k8s-namespace"
+)
+
+// Scheduler applies cloudoff annotations to the workloads and namespaces of a cluster.
+type Scheduler struct {
+	client kubernetes.Interface
+	dryRun bool
+}
+
+// NewScheduler returns a Scheduler using the given clientset. When dryRun is true,
+// no object is modified.
+func NewScheduler(client kubernetes.Interface, dryRun bool) *Scheduler {
+	return &Scheduler{
+		client: client,
+		dryRun: dryRun,
+	}
+}
+
+// NewClient returns a clientset for the cluster cloudoff runs in, or for the
+// current context of the kubeconfig when running outside of a cluster.
+func NewClient() (kubernetes.Interface, error) {
+	config, err := restConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating kubernetes client: %v", err)
+	}
+	return client, nil
+}
+
+// NewDynamicClient returns a client for the custom resources of the cluster cloudoff runs in.
+func NewDynamicClient() (dynamic.Interface, error) {
+	config, err := restConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating kubernetes dynamic client: %v", err)
+	}
+	return client, nil
+}
+
+func restConfig() (*rest.Config, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{}).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("error loading kubernetes configuration: %v", err)
+		}
+	}
+	return config, nil
+}
+
+// annotationsToTags converts cloudoff annotations to the tags understood by the scheduler
+// (ex. : "cloudoff/uptime" becomes "cloudoff:uptime").
+func annotationsToTags(annotations map[string]string) []ec2.Tag {
+	var tags []ec2.Tag
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, "cloudoff/") {
+			continue
+		}
+		tags = append(tags, ec2.Tag{
+			Key:   strings.Replace(key, "cloudoff/", "cloudoff:", 1),
+			Value: value,
+		})
+	}
+	return tags
+}
+
+// objectResource returns the resource of a Kubernetes object in the audit events, with
+// its owner and team labels.
+func objectResource(kind string, meta metav1.ObjectMeta, tags []ec2.Tag) resource.Resource {
+	tags = slices.Clip(tags)
+	for _, label := range []string{"owner", "team"} {
+		if value, ok := meta.Labels[label]; ok {
+			tags = append(tags, ec2.Tag{Key: label, Value: value})
+		}
+	}
+	return resource.Resource{Kind: kind, ID: meta.Namespace + "/" + meta.Name, Tags: tags}
+}
+
+func init() {
+	logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+}
@@ -0,0 +1,180 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	monday10 = time.Date(2023, 10, 2, 10, 0, 0, 0, time.UTC)
+	monday22 = time.Date(2023, 10, 2, 22, 0, 0, 0, time.UTC)
+)
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func TestScheduleDeployment(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev"}},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "web",
+				Namespace:   "dev",
+				Annotations: map[string]string{UptimeAnnotation: "Mon-Fri 08:00-20:00"},
+			},
+			Spec: appsv1.DeploymentSpec{Replicas: int32Ptr(3)},
+		},
+	)
+	s := NewScheduler(client, false)
+
+	if err := s.Schedule(ctx, monday22); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deployment, _ := client.AppsV1().Deployments("dev").Get(ctx, "web", metav1.GetOptions{})
+	if *deployment.Spec.Replicas != 0 || deployment.Annotations[SavedReplicasAnnotation] != "3" {
+		t.Errorf("expected deployment scaled to 0 with 3 saved replicas, got %d (%v)", *deployment.Spec.Replicas, deployment.Annotations)
+	}
+
+	if err := s.Schedule(ctx, monday10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deployment, _ = client.AppsV1().Deployments("dev").Get(ctx, "web", metav1.GetOptions{})
+	if *deployment.Spec.Replicas != 3 {
+		t.Errorf("expected 3 replicas restored, got %d", *deployment.Spec.Replicas)
+	}
+	if _, ok := deployment.Annotations[SavedReplicasAnnotation]; ok {
+		t.Errorf("expected saved replicas annotation removed")
+	}
+}
+
+func TestScheduleStatefulSetFromNamespace(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "dev",
+			Annotations: map[string]string{DowntimeAnnotation: "Mon-Fri 20:00-23:59"},
+		}},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "dev"},
+			Spec:       appsv1.StatefulSetSpec{Replicas: int32Ptr(2)},
+		},
+	)
+	s := NewScheduler(client, false)
+
+	if err := s.Schedule(ctx, monday22); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	statefulSet, _ := client.AppsV1().StatefulSets("dev").Get(ctx, "db", metav1.GetOptions{})
+	if *statefulSet.Spec.Replicas != 0 || statefulSet.Annotations[SavedReplicasAnnotation] != "2" {
+		t.Errorf("expected statefulset scaled to 0 with 2 saved replicas, got %d (%v)", *statefulSet.Spec.Replicas, statefulSet.Annotations)
+	}
+}
+
+func TestScheduleCronJob(t *testing.T) {
+	ctx := context.Background()
+	suspended := true
+	client := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev"}},
+		&batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "report",
+				Namespace:   "dev",
+				Annotations: map[string]string{UptimeAnnotation: "Mon-Fri 08:00-20:00"},
+			},
+		},
+		&batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "manual",
+				Namespace:   "dev",
+				Annotations: map[string]string{UptimeAnnotation: "Mon-Fri 08:00-20:00"},
+			},
+			Spec: batchv1.CronJobSpec{Suspend: &suspended},
+		},
+	)
+	s := NewScheduler(client, false)
+
+	if err := s.Schedule(ctx, monday22); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cronJob, _ := client.BatchV1().CronJobs("dev").Get(ctx, "report", metav1.GetOptions{})
+	if cronJob.Spec.Suspend == nil || !*cronJob.Spec.Suspend {
+		t.Errorf("expected cronjob suspended")
+	}
+
+	if err := s.Schedule(ctx, monday10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cronJob, _ = client.BatchV1().CronJobs("dev").Get(ctx, "report", metav1.GetOptions{})
+	if cronJob.Spec.Suspend == nil || *cronJob.Spec.Suspend {
+		t.Errorf("expected cronjob resumed")
+	}
+
+	// Suspended by someone else, must stay suspended
+	cronJob, _ = client.BatchV1().CronJobs("dev").Get(ctx, "manual", metav1.GetOptions{})
+	if cronJob.Spec.Suspend == nil || !*cronJob.Spec.Suspend {
+		t.Errorf("expected cronjob suspended manually to stay suspended")
+	}
+}
+
+func TestScheduleDryRun(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "web",
+				Namespace:   "dev",
+				Annotations: map[string]string{UptimeAnnotation: "Mon-Fri 08:00-20:00"},
+			},
+			Spec: appsv1.DeploymentSpec{Replicas: int32Ptr(3)},
+		},
+	)
+	s := NewScheduler(client, true)
+
+	if err := s.Schedule(ctx, monday22); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deployment, _ := client.AppsV1().Deployments("dev").Get(ctx, "web", metav1.GetOptions{})
+	if *deployment.Spec.Replicas != 3 {
+		t.Errorf("expected deployment untouched in dry run, got %d replicas", *deployment.Spec.Replicas)
+	}
+}
+
+func TestCleanNamespaces(t *testing.T) {
+	ctx := context.Background()
+	old := metav1.NewTime(time.Now().Add(-72 * time.Hour))
+	client := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name: "review-42", CreationTimestamp: old,
+			Annotations: map[string]string{TTLAnnotation: "2d"},
+		}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name: "review-43", CreationTimestamp: old,
+			Annotations: map[string]string{TTLAnnotation: "1w"},
+		}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name: "kube-system", CreationTimestamp: old,
+			Annotations: map[string]string{TTLAnnotation: "1h"},
+		}},
+	)
+	s := NewScheduler(client, false)
+
+	if err := s.Clean(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	namespaces, _ := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	var names []string
+	for _, namespace := range namespaces.Items {
+		names = append(names, namespace.Name)
+	}
+	if len(names) != 2 || names[0] != "kube-system" || names[1] != "review-43" {
+		t.Errorf("expected only review-42 deleted, got %v", names)
+	}
+}
@@ -0,0 +1,392 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/audit"
+	"github.com/bananaops/cloudoff/internal/clean"
+	"github.com/bananaops/cloudoff/internal/resource"
+	"github.com/bananaops/cloudoff/internal/scheduler"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ScheduleResource is the cluster-scoped custom resource defining a schedule for the
+// resources matching a selector, as an alternative to tagging each resource.
+var ScheduleResource = schema.GroupVersionResource{Group: "cloudoff.bananaops.io", Version: "v1alpha1", Resource: "cloudoffschedules"}
+
+// ScheduleTag is added to the resources matched by a CloudoffSchedule, with its name
+const ScheduleTag = "cloudoff:schedule"
+
+// Ready condition of the CloudoffSchedule status
+const (
+	ConditionReady    = "Ready"
+	ReasonReconciled  = "Reconciled"
+	ReasonInvalidSpec = "InvalidSpec"
+)
+
+const (
+	scheduleKind = "CloudoffSchedule"
+	// maxStatusResources and maxStatusActions limit the size of the status
+	maxStatusResources = 50
+	maxStatusActions   = 10
+)
+
+// CloudoffSchedule is a named schedule applied to the resources matching its selector.
+type CloudoffSchedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ScheduleSpec   `json:"spec"`
+	Status ScheduleStatus `json:"status,omitempty"`
+}
+
+// ScheduleSpec holds the values of the cloudoff:uptime, cloudoff:downtime and
+// cloudoff:ttl tags applied to the matched resources.
+type ScheduleSpec struct {
+	Selector ScheduleSelector `json:"selector"`
+	Uptime   string           `json:"uptime,omitempty"`
+	Downtime string           `json:"downtime,omitempty"`
+	TTL      string           `json:"ttl,omitempty"`
+}
+
+// ScheduleSelector matches the resources of any of the kinds, accounts and regions
+// carrying all the tags. Empty lists match everything, as do tags with an empty value.
+type ScheduleSelector struct {
+	Kinds    []string          `json:"kinds,omitempty"`
+	Accounts []string          `json:"accounts,omitempty"`
+	Regions  []string          `json:"regions,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+// ScheduleStatus reports the resources matched by a schedule and their last actions.
+type ScheduleStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	MatchedResources   int                `json:"matchedResources"`
+	// Resources lists the first matched resources as "kind/id"
+	Resources   []string         `json:"resources,omitempty"`
+	LastActions []ScheduleAction `json:"lastActions,omitempty"`
+}
+
+// ScheduleAction is the last action performed on a matched resource.
+type ScheduleAction struct {
+	Resource string      `json:"resource"`
+	Action   string      `json:"action"`
+	Time     metav1.Time `json:"time"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// ScheduleController applies the CloudoffSchedule objects to the discovered resources
+// as a resource.TagSource, reports the matched resources in their status and records
+// the actions on the matched resources as Kubernetes events, as an audit.Sink.
+type ScheduleController struct {
+	client dynamic.Interface
+	events kubernetes.Interface
+
+	mu sync.RWMutex
+	// schedules are the valid schedules sorted by name, the first matching one applies
+	schedules []CloudoffSchedule
+	// owners is the name of the schedule applied to each resource, by resource key
+	owners map[string]string
+}
+
+func NewScheduleController(client dynamic.Interface, events kubernetes.Interface) *ScheduleController {
+	return &ScheduleController{client: client, events: events, owners: map[string]string{}}
+}
+
+// Load reads the schedules and keeps the valid ones. Invalid schedules are reported by
+// UpdateStatus.
+func (c *ScheduleController) Load(ctx context.Context) error {
+	schedules, _, err := c.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	var valid []CloudoffSchedule
+	for _, schedule := range schedules {
+		if err := schedule.validate(); err != nil {
+			logger.Error("invalid cloudoff schedule", "schedule", schedule.Name, "error", err)
+			continue
+		}
+		valid = append(valid, schedule)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schedules = valid
+	return nil
+}
+
+// UpdateStatus reports in the status of each schedule whether it is valid, the
+// resources it matched during the last discovery and their last actions.
+func (c *ScheduleController) UpdateStatus(ctx context.Context) error {
+	schedules, objects, err := c.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	inventory := resource.Inventory()
+	for i, schedule := range schedules {
+		status := scheduleStatus(ctx, schedule, inventory)
+		if equality.Semantic.DeepEqual(status, schedule.Status) {
+			continue
+		}
+
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+		if err != nil {
+			return fmt.Errorf("error converting status of cloudoff schedule %s: %v", schedule.Name, err)
+		}
+		objects[i].Object["status"] = content
+		_, err = c.client.Resource(ScheduleResource).UpdateStatus(ctx, &objects[i], metav1.UpdateOptions{})
+		if err != nil {
+			logger.Error("error updating cloudoff schedule status", "schedule", schedule.Name, "error", err)
+		}
+	}
+	return nil
+}
+
+// list returns the schedules sorted by name, with the objects they were read from.
+func (c *ScheduleController) list(ctx context.Context) ([]CloudoffSchedule, []unstructured.Unstructured, error) {
+	list, err := c.client.Resource(ScheduleResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list cloudoff schedules: %v", err)
+	}
+
+	objects := list.Items
+	sort.Slice(objects, func(i, j int) bool { return objects[i].GetName() < objects[j].GetName() })
+
+	schedules := make([]CloudoffSchedule, len(objects))
+	for i, object := range objects {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &schedules[i]); err != nil {
+			return nil, nil, fmt.Errorf("error reading cloudoff schedule %s: %v", object.GetName(), err)
+		}
+	}
+	return schedules, objects, nil
+}
+
+// Tags returns the tags of the first schedule matching a resource. Resources carrying
+// their own cloudoff:uptime, cloudoff:downtime or cloudoff:ttl tag keep their schedule.
+func (c *ScheduleController) Tags(r resource.Resource) []resource.Tag {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := resource.Key(r)
+	delete(c.owners, key)
+	for _, tag := range []string{"cloudoff:uptime", "cloudoff:downtime", "cloudoff:ttl"} {
+		if _, ok := r.TagValue(tag); ok {
+			return nil
+		}
+	}
+
+	for _, schedule := range c.schedules {
+		if schedule.Spec.Selector.matches(r) {
+			c.owners[key] = schedule.Name
+			return schedule.tags()
+		}
+	}
+	return nil
+}
+
+// Write records a Kubernetes event on the schedule applied to the resource of an audit
+// event. The events of the other resources and of dry runs are ignored.
+func (c *ScheduleController) Write(event audit.Event) error {
+	if event.DryRun {
+		return nil
+	}
+
+	c.mu.RLock()
+	name, ok := c.owners[resource.Key(resource.Resource{Kind: event.Kind, Account: event.Account, Region: event.Region, ID: event.Resource})]
+	var uid types.UID
+	for _, schedule := range c.schedules {
+		if schedule.Name == name {
+			uid = schedule.UID
+		}
+	}
+	c.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	eventType, reason := corev1.EventTypeNormal, actionReason(event.Action)
+	message := fmt.Sprintf("%s %s %s: %s", event.Action, event.Kind, event.Resource, event.Reason)
+	if event.Result == audit.ResultFailure {
+		eventType, reason = corev1.EventTypeWarning, "ActionFailed"
+		message += ": " + event.Error
+	}
+
+	timestamp := metav1.NewTime(event.Time)
+	k8sEvent := &corev1.Event{
+		// Events of cluster-scoped objects are created in the default namespace
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", name, event.Time.UnixNano()),
+			Namespace: metav1.NamespaceDefault,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: ScheduleResource.GroupVersion().String(),
+			Kind:       scheduleKind,
+			Name:       name,
+			UID:        uid,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: "cloudoff"},
+		FirstTimestamp: timestamp,
+		LastTimestamp:  timestamp,
+		Count:          1,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.events.CoreV1().Events(metav1.NamespaceDefault).Create(ctx, k8sEvent, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("error creating event of cloudoff schedule %s: %v", name, err)
+	}
+	return nil
+}
+
+func (c *ScheduleController) Close() error { return nil }
+
+// validate checks the schedules and ttl of a schedule. An empty selector is refused
+// so a schedule never applies to every resource by mistake.
+func (s CloudoffSchedule) validate() error {
+	spec := s.Spec
+	if spec.Uptime == "" && spec.Downtime == "" && spec.TTL == "" {
+		return fmt.Errorf("one of uptime, downtime or ttl is required")
+	}
+	selector := spec.Selector
+	if len(selector.Kinds) == 0 && len(selector.Accounts) == 0 && len(selector.Regions) == 0 && len(selector.Tags) == 0 {
+		return fmt.Errorf("selector is empty")
+	}
+	if spec.Uptime != "" {
+		if _, err := scheduler.ParseSchedule(spec.Uptime); err != nil {
+			return fmt.Errorf("invalid uptime: %v", err)
+		}
+	}
+	if spec.Downtime != "" {
+		if _, err := scheduler.ParseSchedule(spec.Downtime); err != nil {
+			return fmt.Errorf("invalid downtime: %v", err)
+		}
+	}
+	if spec.TTL != "" {
+		if _, err := clean.ParseDuration(spec.TTL); err != nil {
+			return fmt.Errorf("invalid ttl: %v", err)
+		}
+	}
+	return nil
+}
+
+// tags returns the tags applied to the resources matched by a schedule.
+func (s CloudoffSchedule) tags() []resource.Tag {
+	tags := []resource.Tag{{Key: ScheduleTag, Value: s.Name}}
+	if s.Spec.Uptime != "" {
+		tags = append(tags, resource.Tag{Key: "cloudoff:uptime", Value: s.Spec.Uptime})
+	}
+	if s.Spec.Downtime != "" {
+		tags = append(tags, resource.Tag{Key: "cloudoff:downtime", Value: s.Spec.Downtime})
+	}
+	if s.Spec.TTL != "" {
+		tags = append(tags, resource.Tag{Key: "cloudoff:ttl", Value: s.Spec.TTL})
+	}
+	return tags
+}
+
+func (s ScheduleSelector) matches(r resource.Resource) bool {
+	if len(s.Kinds) > 0 && !slices.Contains(s.Kinds, r.Kind) {
+		return false
+	}
+	if len(s.Accounts) > 0 && !slices.Contains(s.Accounts, r.Account) {
+		return false
+	}
+	if len(s.Regions) > 0 && !slices.Contains(s.Regions, r.Region) {
+		return false
+	}
+	for key, value := range s.Tags {
+		actual, ok := r.TagValue(key)
+		if !ok || (value != "" && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// scheduleStatus computes the status of a schedule from the last discovered resources.
+// The conditions keep their transition time while their status is unchanged.
+func scheduleStatus(ctx context.Context, schedule CloudoffSchedule, inventory []resource.Resource) ScheduleStatus {
+	status := ScheduleStatus{
+		ObservedGeneration: schedule.Generation,
+		Conditions:         slices.Clone(schedule.Status.Conditions),
+	}
+	condition := metav1.Condition{
+		Type:               ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             ReasonReconciled,
+		ObservedGeneration: schedule.Generation,
+	}
+
+	if err := schedule.validate(); err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonInvalidSpec
+		condition.Message = err.Error()
+		meta.SetStatusCondition(&status.Conditions, condition)
+		return status
+	}
+
+	for _, r := range inventory {
+		if name, _ := r.TagValue(ScheduleTag); name != schedule.Name {
+			continue
+		}
+		id := r.Kind + "/" + r.ID
+		status.MatchedResources++
+		if len(status.Resources) < maxStatusResources {
+			status.Resources = append(status.Resources, id)
+		}
+		if action, ok := resource.LastAction(ctx, r); ok {
+			status.LastActions = append(status.LastActions, ScheduleAction{
+				Resource: id,
+				Action:   string(action.Action),
+				// The status is stored with a precision of a second
+				Time:  metav1.NewTime(action.Time.UTC().Truncate(time.Second)),
+				Error: action.Error,
+			})
+		}
+	}
+
+	// Most recent actions first
+	sort.SliceStable(status.LastActions, func(i, j int) bool {
+		return status.LastActions[i].Time.After(status.LastActions[j].Time.Time)
+	})
+	if len(status.LastActions) > maxStatusActions {
+		status.LastActions = status.LastActions[:maxStatusActions]
+	}
+
+	condition.Message = fmt.Sprintf("schedule applied to %d resources", status.MatchedResources)
+	meta.SetStatusCondition(&status.Conditions, condition)
+	return status
+}
+
+func actionReason(action string) string {
+	switch action {
+	case string(resource.CapabilityStop):
+		return "Stopped"
+	case string(resource.CapabilityStart):
+		return "Started"
+	case string(resource.CapabilityDelete):
+		return "Deleted"
+	default:
+		return action
+	}
+}
@@ -0,0 +1,249 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/audit"
+	"github.com/bananaops/cloudoff/internal/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type instances struct{ resources []resource.Resource }
+
+func (p *instances) Kind() string { return "ec2-instance" }
+
+func (p *instances) Discover(ctx context.Context) ([]resource.Resource, error) {
+	return p.resources, nil
+}
+
+func newSchedule(t *testing.T, name string, spec ScheduleSpec) *unstructured.Unstructured {
+	t.Helper()
+	schedule := &CloudoffSchedule{
+		TypeMeta:   metav1.TypeMeta{APIVersion: ScheduleResource.GroupVersion().String(), Kind: scheduleKind},
+		ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID("uid-" + name), Generation: 1},
+		Spec:       spec,
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(schedule)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return &unstructured.Unstructured{Object: content}
+}
+
+func newScheduleController(t *testing.T, objects ...runtime.Object) (*ScheduleController, *dynamicfake.FakeDynamicClient, *fake.Clientset) {
+	t.Helper()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{ScheduleResource: "CloudoffScheduleList"}, objects...)
+	events := fake.NewClientset()
+	controller := NewScheduleController(client, events)
+	if err := controller.Load(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return controller, client, events
+}
+
+func TestScheduleTags(t *testing.T) {
+	controller, _, _ := newScheduleController(t,
+		newSchedule(t, "dev", ScheduleSpec{
+			Selector: ScheduleSelector{Kinds: []string{"ec2-instance"}, Tags: map[string]string{"env": "dev", "team": ""}},
+			Uptime:   "Mon-Fri 08:00-20:00",
+			TTL:      "2w",
+		}),
+		newSchedule(t, "eu", ScheduleSpec{
+			Selector: ScheduleSelector{Regions: []string{"eu-west-1"}},
+			Downtime: "Sat-Sun 00:00-23:59",
+		}),
+		newSchedule(t, "all", ScheduleSpec{Uptime: "Mon-Fri 08:00-20:00"}),
+		newSchedule(t, "invalid", ScheduleSpec{
+			Selector: ScheduleSelector{Regions: []string{"us-east-1"}},
+			Uptime:   "everyday",
+		}),
+	)
+
+	tests := []struct {
+		name     string
+		resource resource.Resource
+		expected map[string]string
+	}{
+		{
+			name: "first matching schedule by name",
+			resource: resource.Resource{ID: "i-1", Kind: "ec2-instance", Region: "eu-west-1", Tags: []resource.Tag{
+				{Key: "env", Value: "dev"}, {Key: "team", Value: "data"},
+			}},
+			expected: map[string]string{ScheduleTag: "dev", "cloudoff:uptime": "Mon-Fri 08:00-20:00", "cloudoff:ttl": "2w"},
+		},
+		{
+			name:     "missing tag",
+			resource: resource.Resource{ID: "i-2", Kind: "ec2-instance", Region: "eu-west-1", Tags: []resource.Tag{{Key: "env", Value: "dev"}}},
+			expected: map[string]string{ScheduleTag: "eu", "cloudoff:downtime": "Sat-Sun 00:00-23:59"},
+		},
+		{
+			name: "own schedule",
+			resource: resource.Resource{ID: "i-3", Kind: "ec2-instance", Region: "eu-west-1", Tags: []resource.Tag{
+				{Key: "cloudoff:ttl", Value: "1d"},
+			}},
+			expected: map[string]string{},
+		},
+		{
+			name:     "empty selector and invalid schedule are ignored",
+			resource: resource.Resource{ID: "i-4", Kind: "ec2-instance", Region: "us-east-1"},
+			expected: map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tags := map[string]string{}
+			for _, tag := range controller.Tags(test.resource) {
+				tags[tag.Key] = tag.Value
+			}
+			if len(tags) != len(test.expected) {
+				t.Fatalf("expected tags %v, got %v", test.expected, tags)
+			}
+			for key, value := range test.expected {
+				if tags[key] != value {
+					t.Errorf("expected %s=%s, got %q", key, value, tags[key])
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleUpdateStatus(t *testing.T) {
+	resource.ResetInventory()
+	resource.ResetStore()
+	defer resource.ResetInventory()
+	defer resource.ResetStore()
+
+	ctx := context.Background()
+	controller, client, _ := newScheduleController(t,
+		newSchedule(t, "dev", ScheduleSpec{
+			Selector: ScheduleSelector{Tags: map[string]string{"env": "dev"}},
+			Uptime:   "Mon-Fri 08:00-20:00",
+		}),
+		newSchedule(t, "invalid", ScheduleSpec{
+			Selector: ScheduleSelector{Tags: map[string]string{"env": "prod"}},
+			TTL:      "forever",
+		}),
+	)
+
+	provider := &instances{resources: []resource.Resource{
+		{ID: "i-1", Kind: "ec2-instance", Tags: []resource.Tag{{Key: "env", Value: "dev"}}},
+		{ID: "i-2", Kind: "ec2-instance", Tags: []resource.Tag{{Key: "env", Value: "dev"}}},
+		{ID: "i-3", Kind: "ec2-instance", Tags: []resource.Tag{{Key: "env", Value: "prod"}}},
+	}}
+	for i, r := range provider.resources {
+		provider.resources[i].Tags = append(r.Tags, controller.Tags(r)...)
+	}
+	resource.UpdateInventory(provider, provider.resources)
+	resource.RecordAction(context.Background(), provider.resources[1], resource.CapabilityStop, errors.New("insufficient capacity"))
+
+	if err := controller.UpdateStatus(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	get := func(name string) CloudoffSchedule {
+		object, err := client.Resource(ScheduleResource).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var schedule CloudoffSchedule
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &schedule); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return schedule
+	}
+
+	dev := get("dev")
+	if dev.Status.MatchedResources != 2 || len(dev.Status.Resources) != 2 || dev.Status.Resources[0] != "ec2-instance/i-1" {
+		t.Errorf("expected 2 matched resources, got %+v", dev.Status)
+	}
+	if len(dev.Status.LastActions) != 1 || dev.Status.LastActions[0].Resource != "ec2-instance/i-2" || dev.Status.LastActions[0].Error != "insufficient capacity" {
+		t.Errorf("expected the failed stop of i-2, got %+v", dev.Status.LastActions)
+	}
+	if len(dev.Status.Conditions) != 1 || dev.Status.Conditions[0].Status != metav1.ConditionTrue || dev.Status.ObservedGeneration != 1 {
+		t.Errorf("expected ready condition, got %+v", dev.Status.Conditions)
+	}
+
+	invalid := get("invalid")
+	if len(invalid.Status.Conditions) != 1 || invalid.Status.Conditions[0].Reason != ReasonInvalidSpec {
+		t.Errorf("expected invalid spec condition, got %+v", invalid.Status.Conditions)
+	}
+
+	// An unchanged status is not updated again
+	client.ClearActions()
+	if err := controller.UpdateStatus(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("expected no status update, got %v", action)
+		}
+	}
+}
+
+func TestScheduleEvents(t *testing.T) {
+	ctx := context.Background()
+	controller, _, events := newScheduleController(t,
+		newSchedule(t, "dev", ScheduleSpec{
+			Selector: ScheduleSelector{Tags: map[string]string{"env": "dev"}},
+			Uptime:   "Mon-Fri 08:00-20:00",
+		}),
+	)
+
+	matched := resource.Resource{ID: "i-1", Kind: "ec2-instance", Tags: []resource.Tag{{Key: "env", Value: "dev"}}}
+	other := resource.Resource{ID: "i-2", Kind: "ec2-instance"}
+	controller.Tags(matched)
+	controller.Tags(other)
+
+	now := time.Date(2023, 10, 2, 20, 0, 0, 0, time.UTC)
+	write := func(r resource.Resource, result, dryRun bool) {
+		event := audit.NewEvent(audit.ActorScheduler, "stop", audit.ReasonDowntime, r)
+		event.Time, event.DryRun, event.Result = now, dryRun, audit.ResultSuccess
+		if !result {
+			event.Result, event.Error = audit.ResultFailure, "insufficient capacity"
+		}
+		now = now.Add(time.Second)
+		if err := controller.Write(event); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	write(matched, true, false)
+	write(matched, false, false)
+	write(matched, true, true)
+	write(other, true, false)
+
+	list, err := events.CoreV1().Events(metav1.NamespaceDefault).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list.Items) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(list.Items))
+	}
+	for _, event := range list.Items {
+		if event.InvolvedObject.Name != "dev" || event.InvolvedObject.Kind != scheduleKind || event.InvolvedObject.UID != "uid-dev" {
+			t.Errorf("expected event on schedule dev, got %+v", event.InvolvedObject)
+		}
+		switch event.Reason {
+		case "Stopped":
+			if event.Type != "Normal" {
+				t.Errorf("expected normal event, got %s", event.Type)
+			}
+		case "ActionFailed":
+			if event.Type != "Warning" {
+				t.Errorf("expected warning event, got %s", event.Type)
+			}
+		default:
+			t.Errorf("unexpected reason %s", event.Reason)
+		}
+	}
+}
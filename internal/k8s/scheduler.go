@@ -0,0 +1,191 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/audit"
+	ec2 "github.com/bananaops/cloudoff/internal/aws"
+	"github.com/bananaops/cloudoff/internal/resource"
+	"github.com/bananaops/cloudoff/internal/scheduler"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Schedule scales Deployments and StatefulSets to zero and suspends CronJobs during
+// downtime, then restores them when uptime begins. Schedule annotations set on a
+// Namespace apply to all its workloads, annotations set on a workload override them.
+func (s *Scheduler) Schedule(ctx context.Context, currentTime time.Time) error {
+
+	namespaces, err := s.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %v", err)
+	}
+
+	namespaceTags := map[string][]ec2.Tag{}
+	for _, namespace := range namespaces.Items {
+		namespaceTags[namespace.Name] = annotationsToTags(namespace.Annotations)
+	}
+
+	deployments, err := s.client.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list deployments: %v", err)
+	}
+	for _, deployment := range deployments.Items {
+		tags := ec2.MergeTags(namespaceTags[deployment.Namespace], annotationsToTags(deployment.Annotations))
+		replicas, changed := s.desiredReplicas("deployment", &deployment.ObjectMeta, deployment.Spec.Replicas, tags, currentTime)
+		if !changed {
+			continue
+		}
+		deployment.Spec.Replicas = replicas
+		err := s.apply(KindDeployment, deployment.ObjectMeta, tags, *replicas == 0, currentTime, func() error {
+			_, err := s.client.AppsV1().Deployments(deployment.Namespace).Update(ctx, &deployment, metav1.UpdateOptions{})
+			return err
+		})
+		if err != nil {
+			logger.Error("error scaling deployment", "namespace", deployment.Namespace, "deployment", deployment.Name, "error", err)
+		}
+	}
+
+	statefulSets, err := s.client.AppsV1().StatefulSets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list statefulsets: %v", err)
+	}
+	for _, statefulSet := range statefulSets.Items {
+		tags := ec2.MergeTags(namespaceTags[statefulSet.Namespace], annotationsToTags(statefulSet.Annotations))
+		replicas, changed := s.desiredReplicas("statefulset", &statefulSet.ObjectMeta, statefulSet.Spec.Replicas, tags, currentTime)
+		if !changed {
+			continue
+		}
+		statefulSet.Spec.Replicas = replicas
+		err := s.apply(KindStatefulSet, statefulSet.ObjectMeta, tags, *replicas == 0, currentTime, func() error {
+			_, err := s.client.AppsV1().StatefulSets(statefulSet.Namespace).Update(ctx, &statefulSet, metav1.UpdateOptions{})
+			return err
+		})
+		if err != nil {
+			logger.Error("error scaling statefulset", "namespace", statefulSet.Namespace, "statefulset", statefulSet.Name, "error", err)
+		}
+	}
+
+	cronJobs, err := s.client.BatchV1().CronJobs(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list cronjobs: %v", err)
+	}
+	for _, cronJob := range cronJobs.Items {
+		tags := ec2.MergeTags(namespaceTags[cronJob.Namespace], annotationsToTags(cronJob.Annotations))
+		suspend, changed := s.desiredSuspend(&cronJob.ObjectMeta, cronJob.Spec.Suspend, tags, currentTime)
+		if !changed {
+			continue
+		}
+		if suspend {
+			setAnnotation(&cronJob.ObjectMeta, SuspendedAnnotation, "true")
+		} else {
+			delete(cronJob.Annotations, SuspendedAnnotation)
+		}
+		cronJob.Spec.Suspend = &suspend
+		err := s.apply(KindCronJob, cronJob.ObjectMeta, tags, suspend, currentTime, func() error {
+			_, err := s.client.BatchV1().CronJobs(cronJob.Namespace).Update(ctx, &cronJob, metav1.UpdateOptions{})
+			return err
+		})
+		if err != nil {
+			logger.Error("error suspending cronjob", "namespace", cronJob.Namespace, "cronjob", cronJob.Name, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// apply records the audit event of a workload stopped or started by its schedule and
+// updates it, unless in dry run.
+func (s *Scheduler) apply(kind string, meta metav1.ObjectMeta, tags []ec2.Tag, downtime bool, currentTime time.Time, update func() error) error {
+	action := resource.CapabilityStart
+	if downtime {
+		action = resource.CapabilityStop
+	}
+	event := scheduler.ScheduledEvent(objectResource(kind, meta, tags), string(action), downtime, currentTime)
+
+	if s.dryRun {
+		event.DryRun = true
+		audit.Record(event, nil)
+		return nil
+	}
+
+	err := update()
+	audit.Record(event, err)
+	return err
+}
+
+// desiredReplicas returns the replicas a workload must be scaled to, and false when
+// it must be left untouched. The annotations are updated to record or forget the
+// replicas to restore, they are not saved in dry run.
+func (s *Scheduler) desiredReplicas(kind string, meta *metav1.ObjectMeta, current *int32, tags []ec2.Tag, currentTime time.Time) (*int32, bool) {
+
+	downtime, err := scheduler.IsDowntime(tags, currentTime)
+	if err != nil {
+		logger.Error("error checking schedule", "namespace", meta.Namespace, kind, meta.Name, "error", err)
+		return nil, false
+	}
+
+	saved, hasSaved := meta.Annotations[SavedReplicasAnnotation]
+
+	// Replicas default to 1 when not set
+	replicas := int32(1)
+	if current != nil {
+		replicas = *current
+	}
+
+	if downtime {
+		// Already scaled down by cloudoff, keep the replicas recorded at that time
+		if hasSaved || replicas == 0 {
+			return nil, false
+		}
+		setAnnotation(meta, SavedReplicasAnnotation, strconv.Itoa(int(replicas)))
+		zero := int32(0)
+		return &zero, true
+	}
+
+	if !hasSaved {
+		return nil, false
+	}
+
+	value, err := strconv.ParseInt(saved, 10, 32)
+	if err != nil || value < 0 {
+		logger.Error("invalid saved replicas", "namespace", meta.Namespace, kind, meta.Name, "value", saved)
+		return nil, false
+	}
+	delete(meta.Annotations, SavedReplicasAnnotation)
+	restored := int32(value)
+	return &restored, true
+}
+
+// desiredSuspend returns whether a CronJob must be suspended, and false when it must
+// be left untouched. CronJobs suspended by someone else are never resumed.
+func (s *Scheduler) desiredSuspend(meta *metav1.ObjectMeta, current *bool, tags []ec2.Tag, currentTime time.Time) (bool, bool) {
+
+	downtime, err := scheduler.IsDowntime(tags, currentTime)
+	if err != nil {
+		logger.Error("error checking schedule", "namespace", meta.Namespace, "cronjob", meta.Name, "error", err)
+		return false, false
+	}
+
+	suspended := current != nil && *current
+	_, suspendedByCloudoff := meta.Annotations[SuspendedAnnotation]
+
+	if downtime && !suspended {
+		return true, true
+	}
+
+	if !downtime && suspendedByCloudoff {
+		return false, true
+	}
+
+	return false, false
+}
+
+func setAnnotation(meta *metav1.ObjectMeta, key, value string) {
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[key] = value
+}
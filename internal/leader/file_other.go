@@ -0,0 +1,21 @@
+//go:build !unix
+
+package leader
+
+import (
+	"context"
+)
+
+// FileLock is not supported on this platform, it never acquires the leadership.
+type FileLock struct {
+	path string
+}
+
+func NewFileLock(path string) *FileLock {
+	return &FileLock{path: path}
+}
+
+func (l *FileLock) Run(ctx context.Context, callbacks Callbacks) {
+	logger.Error("file lock is not supported on this platform", "path", l.path)
+	<-ctx.Done()
+}
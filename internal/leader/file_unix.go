@@ -0,0 +1,59 @@
+//go:build unix
+
+package leader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// FileLock elects the leader with an exclusive lock on a local file, for replicas
+// sharing a host or a volume.
+type FileLock struct {
+	path        string
+	retryPeriod time.Duration
+}
+
+func NewFileLock(path string) *FileLock {
+	return &FileLock{path: path, retryPeriod: 2 * time.Second}
+}
+
+func (l *FileLock) Run(ctx context.Context, callbacks Callbacks) {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		logger.Error("error opening lock file", "path", l.path, "error", err)
+		return
+	}
+	defer file.Close()
+
+	ticker := time.NewTicker(l.retryPeriod)
+	defer ticker.Stop()
+
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			logger.Error("error locking file", "path", l.path, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+
+	// The lock is held until the process exits or ctx is cancelled
+	_ = file.Truncate(0)
+	_, _ = fmt.Fprintf(file, "%d\n", os.Getpid())
+	callbacks.OnStartedLeading(ctx)
+	<-ctx.Done()
+
+	_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	callbacks.OnStoppedLeading()
+}
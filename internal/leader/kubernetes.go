@@ -0,0 +1,52 @@
+package leader
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// KubernetesLock elects the leader with a Lease object.
+type KubernetesLock struct {
+	lock          *resourcelock.LeaseLock
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+}
+
+// NewKubernetesLock returns a lock on the Lease with the given name, held by identity
+// (the name of the pod).
+func NewKubernetesLock(client kubernetes.Interface, namespace, name, identity string) *KubernetesLock {
+	return &KubernetesLock{
+		lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Client:     client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		leaseDuration: 15 * time.Second,
+		renewDeadline: 10 * time.Second,
+		retryPeriod:   2 * time.Second,
+	}
+}
+
+func (l *KubernetesLock) Run(ctx context.Context, callbacks Callbacks) {
+	// RunOrDie returns when the leadership is lost, the election starts again until ctx is cancelled
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            l.lock,
+			LeaseDuration:   l.leaseDuration,
+			RenewDeadline:   l.renewDeadline,
+			RetryPeriod:     l.retryPeriod,
+			ReleaseOnCancel: true,
+			Name:            l.lock.LeaseMeta.Name,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: callbacks.OnStartedLeading,
+				OnStoppedLeading: callbacks.OnStoppedLeading,
+			},
+		})
+	}
+}
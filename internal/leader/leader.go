@@ -0,0 +1,96 @@
+package leader
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var logger *slog.Logger
+
+var leaderGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "cloudoff_leader",
+	Help: "1 when this replica is the leader running the scheduling and cleaning tasks",
+})
+
+// Callbacks are called when the leadership is acquired or lost.
+type Callbacks struct {
+	// OnStartedLeading is called with a context cancelled when the leadership is lost
+	OnStartedLeading func(ctx context.Context)
+	OnStoppedLeading func()
+}
+
+// Lock elects a single leader between the replicas of cloudoff.
+type Lock interface {
+	// Run tries to acquire the lock until ctx is cancelled, and acquires it again
+	// when it is lost. The lock is released when ctx is cancelled.
+	Run(ctx context.Context, callbacks Callbacks)
+}
+
+// Elector tracks whether this replica is the leader.
+type Elector struct {
+	lock    Lock
+	leading atomic.Bool
+	// onLeading are called when the leadership is acquired
+	onLeading []func()
+}
+
+// NewElector returns an elector using the given lock, or an elector which is always
+// the leader when lock is nil.
+func NewElector(lock Lock) *Elector {
+	e := &Elector{lock: lock}
+	if lock == nil {
+		e.setLeading(true)
+	}
+	return e
+}
+
+// Run runs the election until ctx is cancelled.
+func (e *Elector) Run(ctx context.Context) {
+	if e.lock == nil {
+		return
+	}
+
+	e.lock.Run(ctx, Callbacks{
+		OnStartedLeading: func(ctx context.Context) {
+			logger.Info("leadership acquired")
+			e.setLeading(true)
+			for _, f := range e.onLeading {
+				f()
+			}
+		},
+		OnStoppedLeading: func() {
+			logger.Info("leadership lost")
+			e.setLeading(false)
+		},
+	})
+}
+
+// OnLeading registers a function called when this replica becomes the leader. It must
+// be called before Run.
+func (e *Elector) OnLeading(f func()) {
+	e.onLeading = append(e.onLeading, f)
+}
+
+// IsLeader reports whether this replica is the leader.
+func (e *Elector) IsLeader() bool {
+	return e.leading.Load()
+}
+
+func (e *Elector) setLeading(leading bool) {
+	e.leading.Store(leading)
+	if leading {
+		leaderGauge.Set(1)
+	} else {
+		leaderGauge.Set(0)
+	}
+}
+
+func init() {
+	logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+}
@@ -0,0 +1,114 @@
+//go:build unix
+
+package leader
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// waitFor polls the condition until the timeout.
+func waitFor(t *testing.T, condition func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestElectorWithoutLock(t *testing.T) {
+	if !NewElector(nil).IsLeader() {
+		t.Errorf("expected an elector without lock to be the leader")
+	}
+}
+
+func TestFileLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cloudoff.lock")
+
+	first := NewFileLock(path)
+	second := NewFileLock(path)
+	second.retryPeriod = 10 * time.Millisecond
+
+	firstElector, secondElector := NewElector(first), NewElector(second)
+	var acquired atomic.Int32
+	secondElector.OnLeading(func() { acquired.Add(1) })
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	firstDone := make(chan struct{})
+	go func() {
+		firstElector.Run(firstCtx)
+		close(firstDone)
+	}()
+	if !waitFor(t, firstElector.IsLeader) {
+		t.Fatalf("expected the first elector to acquire the lock")
+	}
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	go secondElector.Run(secondCtx)
+
+	time.Sleep(50 * time.Millisecond)
+	if secondElector.IsLeader() {
+		t.Fatalf("expected a single leader")
+	}
+
+	// The second elector takes over once the lock is released
+	stopFirst()
+	<-firstDone
+	if firstElector.IsLeader() {
+		t.Errorf("expected the first elector to lose the leadership")
+	}
+	if !waitFor(t, secondElector.IsLeader) {
+		t.Errorf("expected the second elector to acquire the lock")
+	}
+	if acquired.Load() != 1 {
+		t.Errorf("expected the second elector to be notified once, got %d", acquired.Load())
+	}
+}
+
+func TestKubernetesLock(t *testing.T) {
+	client := fake.NewClientset()
+
+	first := NewKubernetesLock(client, "cloudoff", "cloudoff-leader", "pod-1")
+	second := NewKubernetesLock(client, "cloudoff", "cloudoff-leader", "pod-2")
+	for _, lock := range []*KubernetesLock{first, second} {
+		lock.leaseDuration = time.Second
+		lock.renewDeadline = 500 * time.Millisecond
+		lock.retryPeriod = 100 * time.Millisecond
+	}
+
+	firstElector, secondElector := NewElector(first), NewElector(second)
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	go firstElector.Run(firstCtx)
+	if !waitFor(t, firstElector.IsLeader) {
+		t.Fatalf("expected the first elector to acquire the lease")
+	}
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	go secondElector.Run(secondCtx)
+
+	time.Sleep(300 * time.Millisecond)
+	if secondElector.IsLeader() {
+		t.Fatalf("expected a single leader")
+	}
+
+	// The lease is released on cancel, the second elector takes over
+	stopFirst()
+	if !waitFor(t, secondElector.IsLeader) {
+		t.Errorf("expected the second elector to acquire the lease")
+	}
+	if firstElector.IsLeader() {
+		t.Errorf("expected the first elector to lose the leadership")
+	}
+}
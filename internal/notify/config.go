@@ -0,0 +1,134 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Types of destination
+const (
+	TypeSlack   = "slack"
+	TypeTeams   = "teams"
+	TypeWebhook = "webhook"
+)
+
+// Config is the notification configuration read from NOTIFY_CONFIG.
+type Config struct {
+	Destinations map[string]Destination `json:"destinations"`
+	// Routes send the notifications of the resources to a destination based on their
+	// owner or team tag
+	Routes []Route `json:"routes"`
+	// Default is the destination of the resources matching no route, they are not
+	// notified when empty
+	Default string `json:"default"`
+	// BatchWindow is the time notifications are collected before a digest is sent (5m by default)
+	BatchWindow string `json:"batchWindow"`
+	// TTLWarning is the time before the expiration of a ttl a resource is notified (24h by default)
+	TTLWarning string `json:"ttlWarning"`
+}
+
+// Destination is a Slack or Teams incoming webhook, or a generic webhook.
+type Destination struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	// Template is a text/template rendering a Message, the default template lists the
+	// actions and the expiring resources
+	Template string `json:"template"`
+}
+
+// Route matches the resources whose owner or team tag has the given value, or any value
+// when empty.
+type Route struct {
+	Tag         string `json:"tag"`
+	Value       string `json:"value"`
+	Destination string `json:"destination"`
+}
+
+// LoadConfig reads a YAML or JSON configuration file.
+func LoadConfig(path string) (Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("error reading notification config %s: %v", path, err)
+	}
+
+	var config Config
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return Config{}, fmt.Errorf("error parsing notification config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// parsed is a validated configuration.
+type parsed struct {
+	destinations map[string]destination
+	routes       []Route
+	fallback     string
+	batchWindow  time.Duration
+	ttlWarning   time.Duration
+}
+
+type destination struct {
+	Destination
+	template *template.Template
+}
+
+func (c Config) parse() (parsed, error) {
+	p := parsed{
+		destinations: map[string]destination{},
+		routes:       c.Routes,
+		fallback:     c.Default,
+		batchWindow:  5 * time.Minute,
+		ttlWarning:   24 * time.Hour,
+	}
+
+	var err error
+	if c.BatchWindow != "" {
+		if p.batchWindow, err = time.ParseDuration(c.BatchWindow); err != nil {
+			return parsed{}, fmt.Errorf("invalid batchWindow : %v", err)
+		}
+	}
+	if c.TTLWarning != "" {
+		if p.ttlWarning, err = time.ParseDuration(c.TTLWarning); err != nil {
+			return parsed{}, fmt.Errorf("invalid ttlWarning : %v", err)
+		}
+	}
+
+	for name, d := range c.Destinations {
+		switch d.Type {
+		case TypeSlack, TypeTeams, TypeWebhook:
+		default:
+			return parsed{}, fmt.Errorf("unknown type of destination %s : %s", name, d.Type)
+		}
+		if d.URL == "" {
+			return parsed{}, fmt.Errorf("url is required for destination %s", name)
+		}
+
+		text := d.Template
+		if text == "" {
+			text = defaultTemplate
+		}
+		tmpl, err := template.New(name).Parse(text)
+		if err != nil {
+			return parsed{}, fmt.Errorf("invalid template of destination %s : %v", name, err)
+		}
+		p.destinations[name] = destination{Destination: d, template: tmpl}
+	}
+
+	for _, route := range c.Routes {
+		if route.Tag != "owner" && route.Tag != "team" {
+			return parsed{}, fmt.Errorf("invalid route tag : %q, owner or team is expected", route.Tag)
+		}
+		if _, ok := p.destinations[route.Destination]; !ok {
+			return parsed{}, fmt.Errorf("unknown destination : %s", route.Destination)
+		}
+	}
+	if _, ok := p.destinations[c.Default]; c.Default != "" && !ok {
+		return parsed{}, fmt.Errorf("unknown destination : %s", c.Default)
+	}
+
+	return p, nil
+}
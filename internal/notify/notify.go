@@ -0,0 +1,271 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/audit"
+	"github.com/bananaops/cloudoff/internal/clean"
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+var logger *slog.Logger
+
+// sendTimeout bounds the delivery of a message, sent when its batch window ends or at
+// exit rather than on behalf of a caller.
+const sendTimeout = 30 * time.Second
+
+// defaultTemplate lists the actions and the expiring resources of a message, with the
+// Markdown understood by Slack and Teams.
+const defaultTemplate = `{{with .Events}}*cloudoff actions*
+{{range .}}• {{.Action}} {{.Kind}} {{.Resource}}{{with .Region}} ({{.}}){{end}}: {{.Result}}{{with .Error}}, {{.}}{{end}} [{{.Reason}}]
+{{end}}{{end}}{{with .Expiring}}*Resources deleted soon*
+{{range .}}• {{.Kind}} {{.Resource}}{{with .Region}} ({{.}}){{end}} expires at {{.ExpiresAt.Format "2006-01-02 15:04 MST"}} (ttl {{.TTL}})
+{{end}}{{end}}`
+
+// Message is the digest sent to a destination, rendered by its template.
+type Message struct {
+	Events   []audit.Event `json:"events,omitempty"`
+	Expiring []Expiring    `json:"expiring,omitempty"`
+}
+
+// Expiring is a resource whose ttl expires soon.
+type Expiring struct {
+	Kind      string    `json:"kind"`
+	Resource  string    `json:"resource"`
+	Region    string    `json:"region,omitempty"`
+	Account   string    `json:"account,omitempty"`
+	Owner     string    `json:"owner,omitempty"`
+	Team      string    `json:"team,omitempty"`
+	TTL       string    `json:"ttl"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Notifier sends digests of the actions taken by cloudoff and of the resources about to
+// expire. It receives the actions as an audit sink.
+type Notifier struct {
+	config parsed
+	client *http.Client
+
+	mu      sync.Mutex
+	pending map[string]*Message
+	timers  map[string]*time.Timer
+	// warned keeps the expiration of the resources already notified
+	warned map[string]time.Time
+}
+
+func NewNotifier(config Config, client *http.Client) (*Notifier, error) {
+	p, err := config.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Notifier{
+		config:  p,
+		client:  client,
+		pending: map[string]*Message{},
+		timers:  map[string]*time.Timer{},
+		warned:  map[string]time.Time{},
+	}, nil
+}
+
+// Write adds an action to the digests of its destinations. Actions skipped in dry run
+// are not notified.
+func (n *Notifier) Write(event audit.Event) error {
+	if event.DryRun {
+		return nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, name := range n.route(event.Owner, event.Team) {
+		message := n.message(name)
+		message.Events = append(message.Events, event)
+	}
+	return nil
+}
+
+// WarnExpiring adds the resources of the inventory whose ttl expires within the warning
+// time to the digests of their destinations, with the extension of their ttl. Each
+// expiration is notified once, an extended ttl is notified again before its new expiration.
+func (n *Notifier) WarnExpiring(ctx context.Context, now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for key, expiresAt := range n.warned {
+		if expiresAt.Before(now) {
+			delete(n.warned, key)
+		}
+	}
+
+	for _, r := range resource.Inventory() {
+		ttl, ok := r.TagValue("cloudoff:ttl")
+		if !ok {
+			continue
+		}
+		expiresAt, ok := clean.Expiration(ctx, r)
+		if !ok || expiresAt.Before(now) || expiresAt.Sub(now) > n.config.ttlWarning {
+			continue
+		}
+		key := resource.Key(r)
+		if warned, ok := n.warned[key]; ok && warned.Equal(expiresAt) {
+			continue
+		}
+		n.warned[key] = expiresAt
+
+		owner, _ := r.TagValue("owner")
+		team, _ := r.TagValue("team")
+		for _, name := range n.route(owner, team) {
+			message := n.message(name)
+			message.Expiring = append(message.Expiring, Expiring{
+				Kind:      r.Kind,
+				Resource:  r.ID,
+				Region:    r.Region,
+				Account:   r.Account,
+				Owner:     owner,
+				Team:      team,
+				TTL:       ttl,
+				ExpiresAt: expiresAt,
+			})
+		}
+	}
+}
+
+// route returns the destinations of a resource from its owner and team tags.
+func (n *Notifier) route(owner, team string) []string {
+	tags := map[string]string{"owner": owner, "team": team}
+
+	var names []string
+	seen := map[string]bool{}
+	for _, route := range n.config.routes {
+		value := tags[route.Tag]
+		if value == "" || (route.Value != "" && route.Value != value) || seen[route.Destination] {
+			continue
+		}
+		seen[route.Destination] = true
+		names = append(names, route.Destination)
+	}
+
+	if len(names) == 0 && n.config.fallback != "" {
+		names = append(names, n.config.fallback)
+	}
+	return names
+}
+
+// message returns the pending message of a destination, the digest is sent at the end
+// of the batch window started by its first notification. It must be called with the lock.
+func (n *Notifier) message(name string) *Message {
+	message, ok := n.pending[name]
+	if !ok {
+		message = &Message{}
+		n.pending[name] = message
+		n.timers[name] = time.AfterFunc(n.config.batchWindow, func() {
+			if err := n.flush(name); err != nil {
+				logger.Error("error sending notification", "destination", name, "error", err)
+			}
+		})
+	}
+	return message
+}
+
+// flush sends the pending message of a destination.
+func (n *Notifier) flush(name string) error {
+	n.mu.Lock()
+	message, ok := n.pending[name]
+	delete(n.pending, name)
+	if timer, ok := n.timers[name]; ok {
+		timer.Stop()
+		delete(n.timers, name)
+	}
+	n.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	return n.send(ctx, n.config.destinations[name], *message)
+}
+
+// Close sends the pending messages without waiting for the end of their batch window.
+func (n *Notifier) Close() error {
+	n.mu.Lock()
+	var names []string
+	for name := range n.pending {
+		names = append(names, name)
+	}
+	n.mu.Unlock()
+
+	var errs []error
+	for _, name := range names {
+		if err := n.flush(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// send renders a message with the template of a destination and posts it in the format
+// of the destination.
+func (n *Notifier) send(ctx context.Context, d destination, message Message) error {
+	var text strings.Builder
+	if err := d.template.Execute(&text, message); err != nil {
+		return fmt.Errorf("error rendering template: %v", err)
+	}
+
+	var payload any
+	switch d.Type {
+	case TypeSlack:
+		payload = map[string]string{"text": text.String()}
+	case TypeTeams:
+		payload = map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  "cloudoff",
+			"text":     text.String(),
+		}
+	default:
+		payload = struct {
+			Text string `json:"text"`
+			Message
+		}{Text: text.String(), Message: message}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s webhook returned %s: %s", d.Type, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func init() {
+	logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+}
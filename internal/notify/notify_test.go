@@ -0,0 +1,232 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/audit"
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+// webhooks records the bodies posted to each path.
+type webhooks struct {
+	mu     sync.Mutex
+	bodies map[string][]map[string]any
+}
+
+func newWebhooks(t *testing.T) (*webhooks, *httptest.Server) {
+	w := &webhooks{bodies: map[string][]map[string]any{}}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		content, _ := io.ReadAll(r.Body)
+		var body map[string]any
+		if err := json.Unmarshal(content, &body); err != nil {
+			t.Errorf("invalid body %q: %v", content, err)
+		}
+		w.mu.Lock()
+		w.bodies[r.URL.Path] = append(w.bodies[r.URL.Path], body)
+		w.mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return w, server
+}
+
+func (w *webhooks) received(path string) []map[string]any {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.bodies[path]
+}
+
+func TestNotifierDigest(t *testing.T) {
+	hooks, server := newWebhooks(t)
+
+	notifier, err := NewNotifier(Config{
+		Destinations: map[string]Destination{
+			"data":     {Type: TypeSlack, URL: server.URL + "/data"},
+			"alice":    {Type: TypeTeams, URL: server.URL + "/alice"},
+			"platform": {Type: TypeWebhook, URL: server.URL + "/platform", Template: "{{len .Events}} actions"},
+		},
+		Routes: []Route{
+			{Tag: "team", Value: "data", Destination: "data"},
+			{Tag: "owner", Value: "alice", Destination: "alice"},
+		},
+		Default:     "platform",
+		BatchWindow: "1h",
+	}, server.Client())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A Friday evening shutdown is sent as one digest per destination
+	for _, id := range []string{"i-1", "i-2", "i-3"} {
+		notifier.Write(audit.Event{Action: "stop", Kind: "ec2-instance", Resource: id, Team: "data", Owner: "alice", Result: audit.ResultSuccess, Reason: audit.ReasonDowntime})
+	}
+	notifier.Write(audit.Event{Action: "delete", Kind: "ec2-instance", Resource: "i-4", Result: audit.ResultFailure, Error: "access denied"})
+	notifier.Write(audit.Event{Action: "stop", Kind: "ec2-instance", Resource: "i-5", DryRun: true, Result: audit.ResultDryRun})
+
+	if len(hooks.received("/data")) != 0 {
+		t.Fatalf("expected the notifications to wait for the batch window")
+	}
+	if err := notifier.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data := hooks.received("/data")
+	if len(data) != 1 || strings.Count(data[0]["text"].(string), "• stop ec2-instance") != 3 {
+		t.Errorf("expected one slack digest with 3 actions, got %v", data)
+	}
+
+	alice := hooks.received("/alice")
+	if len(alice) != 1 || alice[0]["@type"] != "MessageCard" || !strings.Contains(alice[0]["text"].(string), "i-3") {
+		t.Errorf("expected one teams card, got %v", alice)
+	}
+
+	platform := hooks.received("/platform")
+	if len(platform) != 1 || platform[0]["text"] != "1 actions" || len(platform[0]["events"].([]any)) != 1 {
+		t.Errorf("expected the unrouted action on the default destination, got %v", platform)
+	}
+}
+
+func TestNotifierBatchWindow(t *testing.T) {
+	hooks, server := newWebhooks(t)
+
+	notifier, err := NewNotifier(Config{
+		Destinations: map[string]Destination{"platform": {Type: TypeSlack, URL: server.URL + "/platform"}},
+		Default:      "platform",
+		BatchWindow:  "10ms",
+	}, server.Client())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer notifier.Close()
+
+	notifier.Write(audit.Event{Action: "start", Kind: "ec2-instance", Resource: "i-1", Result: audit.ResultSuccess})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(hooks.received("/platform")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(hooks.received("/platform")) != 1 {
+		t.Errorf("expected the digest to be sent at the end of the batch window")
+	}
+}
+
+func TestWarnExpiring(t *testing.T) {
+	resource.ResetInventory()
+	resource.ResetStore()
+	defer resource.ResetInventory()
+	defer resource.ResetStore()
+
+	hooks, server := newWebhooks(t)
+	notifier, err := NewNotifier(Config{
+		Destinations: map[string]Destination{"data": {Type: TypeWebhook, URL: server.URL + "/data"}},
+		Routes:       []Route{{Tag: "team", Destination: "data"}},
+		BatchWindow:  "1h",
+		TTLWarning:   "24h",
+	}, server.Client())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Date(2025, 1, 10, 18, 0, 0, 0, time.UTC)
+	extended := resource.Resource{ID: "i-extended", Kind: "ec2-instance", TTLStart: now.Add(-36 * time.Hour), Tags: []resource.Tag{{Key: "cloudoff:ttl", Value: "2d"}, {Key: "team", Value: "data"}}}
+	resource.UpdateInventory(nil, []resource.Resource{
+		extended,
+		{ID: "i-soon", Kind: "ec2-instance", TTLStart: now.Add(-36 * time.Hour), Tags: []resource.Tag{{Key: "cloudoff:ttl", Value: "2d"}, {Key: "team", Value: "data"}}},
+		{ID: "i-later", Kind: "ec2-instance", TTLStart: now, Tags: []resource.Tag{{Key: "cloudoff:ttl", Value: "2d"}, {Key: "team", Value: "data"}}},
+		{ID: "i-unrouted", Kind: "ec2-instance", TTLStart: now.Add(-36 * time.Hour), Tags: []resource.Tag{{Key: "cloudoff:ttl", Value: "2d"}}},
+	})
+
+	// An extended ttl expires later
+	resource.ExtendTTL(context.Background(), extended, resource.TTLExtension{Until: now.Add(72 * time.Hour), Created: now})
+
+	// Each expiration is notified once
+	notifier.WarnExpiring(context.Background(), now)
+	notifier.WarnExpiring(context.Background(), now.Add(time.Hour))
+	if err := notifier.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data := hooks.received("/data")
+	if len(data) != 1 {
+		t.Fatalf("expected one digest, got %v", data)
+	}
+	expiring := data[0]["expiring"].([]any)
+	if len(expiring) != 1 || expiring[0].(map[string]any)["resource"] != "i-soon" {
+		t.Errorf("expected i-soon to expire, got %v", expiring)
+	}
+	if !strings.Contains(data[0]["text"].(string), "i-soon expires at 2025-01-11 06:00 UTC (ttl 2d)") {
+		t.Errorf("unexpected text %q", data[0]["text"])
+	}
+}
+
+func TestSendError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	notifier, err := NewNotifier(Config{Destinations: map[string]Destination{"slack": {Type: TypeSlack, URL: server.URL}}}, server.Client())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = notifier.send(context.Background(), notifier.config.destinations["slack"], Message{})
+	if err == nil || !strings.Contains(err.Error(), "invalid_token") {
+		t.Errorf("expected the error of the webhook, got %v", err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.yaml")
+	content := `destinations:
+  data:
+    type: slack
+    url: https://hooks.slack.com/services/T000/B000/XXXX
+routes:
+  - tag: team
+    value: data
+    destination: data
+batchWindow: 10m
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p, err := config.parse()
+	if err != nil || p.batchWindow != 10*time.Minute || p.ttlWarning != 24*time.Hour {
+		t.Errorf("unexpected config %+v: %v", p, err)
+	}
+
+	slack := Destination{Type: TypeSlack, URL: "https://hooks.slack.com"}
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"Unknown type", Config{Destinations: map[string]Destination{"data": {Type: "email", URL: "x"}}}},
+		{"Missing url", Config{Destinations: map[string]Destination{"data": {Type: TypeSlack}}}},
+		{"Invalid template", Config{Destinations: map[string]Destination{"data": {Type: TypeSlack, URL: "x", Template: "{{.Events"}}}},
+		{"Unknown route destination", Config{Destinations: map[string]Destination{"data": slack}, Routes: []Route{{Tag: "team", Destination: "web"}}}},
+		{"Invalid route tag", Config{Destinations: map[string]Destination{"data": slack}, Routes: []Route{{Tag: "env", Destination: "data"}}}},
+		{"Unknown default", Config{Destinations: map[string]Destination{"data": slack}, Default: "web"}},
+		{"Invalid batch window", Config{BatchWindow: "soon"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.config.parse(); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
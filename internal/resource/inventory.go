@@ -0,0 +1,97 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Action is an action performed by cloudoff on a resource.
+type Action struct {
+	Action Capability `json:"action"`
+	Time   time.Time  `json:"time"`
+	Error  string     `json:"error,omitempty"`
+}
+
+var (
+	inventoryMu sync.RWMutex
+	inventory   = map[Provider][]Resource{}
+)
+
+// UpdateInventory replaces the resources of a provider with the last discovered ones.
+// Several providers can discover the same kind of resources (one per project...).
+func UpdateInventory(p Provider, resources []Resource) {
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+	inventory[p] = append([]Resource{}, resources...)
+}
+
+// Inventory returns the last discovered resources of every provider, sorted by kind and ID.
+func Inventory() []Resource {
+	inventoryMu.RLock()
+	defer inventoryMu.RUnlock()
+
+	var resources []Resource
+	for _, discovered := range inventory {
+		resources = append(resources, discovered...)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].Kind != resources[j].Kind {
+			return resources[i].Kind < resources[j].Kind
+		}
+		return resources[i].ID < resources[j].ID
+	})
+	return resources
+}
+
+// ErrNotFound is returned by Find when no discovered resource matches.
+var ErrNotFound = errors.New("resource not found")
+
+// Find returns the last discovered resource of a kind with the given ID and its provider.
+// An empty account or region matches any, as long as a single resource matches.
+func Find(kind, account, region, id string) (Provider, Resource, error) {
+	inventoryMu.RLock()
+	defer inventoryMu.RUnlock()
+
+	var found []Resource
+	var providers []Provider
+	for p, discovered := range inventory {
+		for _, r := range discovered {
+			if r.Kind == kind && r.ID == id && (account == "" || r.Account == account) && (region == "" || r.Region == region) {
+				found = append(found, r)
+				providers = append(providers, p)
+			}
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, Resource{}, fmt.Errorf("%w: %s %s", ErrNotFound, kind, id)
+	case 1:
+		return providers[0], found[0], nil
+	default:
+		return nil, Resource{}, fmt.Errorf("%d resources %s %s found in several accounts or regions, set the account and the region", len(found), kind, id)
+	}
+}
+
+// SetState records the state of a resource changed by an action, until the next discovery.
+func SetState(r Resource, state State) {
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+	for _, discovered := range inventory {
+		for i := range discovered {
+			if Key(discovered[i]) == Key(r) {
+				discovered[i].State = state
+			}
+		}
+	}
+}
+
+// ResetInventory removes all discovered resources.
+func ResetInventory() {
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+	inventory = map[Provider][]Resource{}
+}
@@ -0,0 +1,61 @@
+package resource
+
+import (
+	"context"
+	"time"
+)
+
+// Override forces the state of a resource until a given time, so the scheduler doesn't
+// revert a manual action.
+type Override struct {
+	// Action is the manual action which created the override (start, stop, skip, snooze)
+	Action  string    `json:"action"`
+	State   State     `json:"state"`
+	Until   time.Time `json:"until"`
+	Created time.Time `json:"created"`
+}
+
+// SetOverride replaces the override of a resource.
+func SetOverride(ctx context.Context, r Resource, override Override) {
+	updateRecord(ctx, r, func(record *Record) { record.Override = &override })
+}
+
+// ClearOverride removes the override of a resource.
+func ClearOverride(ctx context.Context, r Resource) {
+	updateRecord(ctx, r, func(record *Record) { record.Override = nil })
+}
+
+// ActiveOverride returns the override of a resource if it is still active at the given
+// time. Expired overrides are removed.
+func ActiveOverride(ctx context.Context, r Resource, now time.Time) (Override, bool) {
+	record := loadRecord(ctx, r)
+	if record.Override == nil {
+		return Override{}, false
+	}
+	if !now.Before(record.Override.Until) {
+		ClearOverride(ctx, r)
+		return Override{}, false
+	}
+	return *record.Override, true
+}
+
+// TTLExtension postpones the deletion of a resource by its cloudoff:ttl tag until a given
+// time, set by a manual extend.
+type TTLExtension struct {
+	Until   time.Time `json:"until"`
+	Created time.Time `json:"created"`
+}
+
+// ExtendTTL replaces the ttl extension of a resource.
+func ExtendTTL(ctx context.Context, r Resource, extension TTLExtension) {
+	updateRecord(ctx, r, func(record *Record) { record.TTLExtension = &extension })
+}
+
+// ActiveTTLExtension returns the ttl extension of a resource, if any.
+func ActiveTTLExtension(ctx context.Context, r Resource) (TTLExtension, bool) {
+	record := loadRecord(ctx, r)
+	if record.TTLExtension == nil {
+		return TTLExtension{}, false
+	}
+	return *record.TTLExtension, true
+}
@@ -0,0 +1,55 @@
+package resource
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ActionInProgress is an action started on a resource and not completed yet.
+type ActionInProgress struct {
+	Kind    string
+	ID      string
+	Region  string
+	Action  Capability
+	Started time.Time
+}
+
+var (
+	progressMu sync.Mutex
+	inProgress = map[*ActionInProgress]struct{}{}
+)
+
+// StartAction records an action in progress on a resource until the returned function
+// is called, so the actions interrupted by a shutdown can be reported.
+func StartAction(r Resource, action Capability) func() {
+	progress := &ActionInProgress{Kind: r.Kind, ID: r.ID, Region: r.Region, Action: action, Started: time.Now()}
+
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	inProgress[progress] = struct{}{}
+
+	return func() {
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		delete(inProgress, progress)
+	}
+}
+
+// ActionsInProgress returns the actions in progress, oldest first.
+func ActionsInProgress() []ActionInProgress {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+
+	actions := make([]ActionInProgress, 0, len(inProgress))
+	for progress := range inProgress {
+		actions = append(actions, *progress)
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		if !actions[i].Started.Equal(actions[j].Started) {
+			return actions[i].Started.Before(actions[j].Started)
+		}
+		return actions[i].ID < actions[j].ID
+	})
+	return actions
+}
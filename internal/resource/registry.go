@@ -0,0 +1,74 @@
+package resource
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+var (
+	mu         sync.RWMutex
+	providers  []Provider
+	tagSources []TagSource
+)
+
+// TagSource returns the tags applied to a resource without being set on it, such as the
+// schedules defined as Kubernetes objects.
+type TagSource interface {
+	Tags(r Resource) []Tag
+}
+
+// Register adds a provider to the registry. Providers are registered at startup.
+func Register(p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers = append(providers, p)
+}
+
+// Providers returns the registered providers.
+func Providers() []Provider {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]Provider{}, providers...)
+}
+
+// AddTagSource adds a source of tags to the discovered resources.
+func AddTagSource(source TagSource) {
+	mu.Lock()
+	defer mu.Unlock()
+	tagSources = append(tagSources, source)
+}
+
+// Discover returns the resources of a provider with the tags of the tag sources. The
+// tags set on a resource take precedence over the ones of the sources.
+func Discover(ctx context.Context, p Provider) ([]Resource, error) {
+	resources, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mu.RLock()
+	sources := append([]TagSource{}, tagSources...)
+	mu.RUnlock()
+
+	for i := range resources {
+		r := &resources[i]
+		for _, source := range sources {
+			for _, tag := range source.Tags(*r) {
+				if _, ok := r.TagValue(tag.Key); !ok {
+					// Clip the tags so the slice of the provider is never modified
+					r.Tags = append(slices.Clip(r.Tags), tag)
+				}
+			}
+		}
+	}
+	return resources, nil
+}
+
+// Reset removes all registered providers and tag sources.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	providers = nil
+	tagSources = nil
+}
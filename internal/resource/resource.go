@@ -0,0 +1,99 @@
+package resource
+
+import (
+	"context"
+	"time"
+)
+
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// State is the state of a resource as seen by the scheduler.
+type State string
+
+const (
+	// StateRunning resources are stopped during downtime
+	StateRunning State = "running"
+	// StateStopped resources are started during uptime
+	StateStopped State = "stopped"
+	// StateUnknown resources are left untouched (transitions, configurations not supported...)
+	StateUnknown State = "unknown"
+)
+
+// Capability is an action a provider can perform on its resources.
+type Capability string
+
+const (
+	CapabilityStop   Capability = "stop"
+	CapabilityStart  Capability = "start"
+	CapabilityDelete Capability = "delete"
+)
+
+// Resource is a cloud resource managed by cloudoff.
+type Resource struct {
+	ID      string
+	Kind    string
+	Region  string
+	Account string
+	Tags    []Tag
+	State   State
+	// StoppedByCloudoff is true when the resource was stopped by cloudoff, it is then
+	// started again even if its schedule tags were removed
+	StoppedByCloudoff bool
+	// TTLStart is the time from which the cloudoff:ttl tag is counted
+	TTLStart time.Time
+	// Warning explains why a resource in StateUnknown is left untouched
+	Warning string
+	// Object is the provider specific representation of the resource
+	Object any
+}
+
+// Provider discovers the resources of one kind. Actions are provided by implementing
+// Stopper, Starter and Deleter. Providers identify their resources in the inventory, so
+// they must be comparable (pointers or structs without slices or maps).
+type Provider interface {
+	Kind() string
+	Discover(ctx context.Context) ([]Resource, error)
+}
+
+// Stopper stops a resource, or scales it to zero.
+type Stopper interface {
+	Stop(ctx context.Context, r Resource) error
+}
+
+// Starter starts a resource, or restores its capacity.
+type Starter interface {
+	Start(ctx context.Context, r Resource) error
+}
+
+// Deleter deletes a resource.
+type Deleter interface {
+	Delete(ctx context.Context, r Resource) error
+}
+
+// Capabilities returns the actions supported by the provider.
+func Capabilities(p Provider) []Capability {
+	var capabilities []Capability
+	if _, ok := p.(Stopper); ok {
+		capabilities = append(capabilities, CapabilityStop)
+	}
+	if _, ok := p.(Starter); ok {
+		capabilities = append(capabilities, CapabilityStart)
+	}
+	if _, ok := p.(Deleter); ok {
+		capabilities = append(capabilities, CapabilityDelete)
+	}
+	return capabilities
+}
+
+// TagValue returns the value of the tag with the given key.
+func (r Resource) TagValue(key string) (string, bool) {
+	for _, tag := range r.Tags {
+		if tag.Key == key {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,358 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type discoverOnly struct{}
+
+func (discoverOnly) Kind() string                                     { return "discover-only" }
+func (discoverOnly) Discover(ctx context.Context) ([]Resource, error) { return nil, nil }
+
+type stopStart struct{ discoverOnly }
+
+func (stopStart) Stop(ctx context.Context, r Resource) error  { return nil }
+func (stopStart) Start(ctx context.Context, r Resource) error { return nil }
+
+type full struct{ stopStart }
+
+func (full) Delete(ctx context.Context, r Resource) error { return nil }
+
+func TestCapabilities(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		expected []Capability
+	}{
+		{"Discovery only", discoverOnly{}, nil},
+		{"Stop and start", stopStart{}, []Capability{CapabilityStop, CapabilityStart}},
+		{"All actions", full{}, []Capability{CapabilityStop, CapabilityStart, CapabilityDelete}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Capabilities(tt.provider)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	Reset()
+	defer Reset()
+
+	Register(discoverOnly{})
+	Register(full{})
+
+	providers := Providers()
+	if len(providers) != 2 || providers[0].Kind() != "discover-only" {
+		t.Errorf("unexpected providers %v", providers)
+	}
+}
+
+type twoInstances struct{ discoverOnly }
+
+func (twoInstances) Discover(ctx context.Context) ([]Resource, error) {
+	return []Resource{
+		{ID: "i-1", Kind: "ec2-instance", Tags: []Tag{{Key: "team", Value: "data"}}},
+		{ID: "i-2", Kind: "ec2-instance", Tags: []Tag{{Key: "team", Value: "data"}, {Key: "cloudoff:uptime", Value: "Mon-Fri 09:00-18:00"}}},
+	}, nil
+}
+
+type tagSourceFunc func(r Resource) []Tag
+
+func (f tagSourceFunc) Tags(r Resource) []Tag { return f(r) }
+
+func TestDiscoverTagSources(t *testing.T) {
+	Reset()
+	defer Reset()
+
+	AddTagSource(tagSourceFunc(func(r Resource) []Tag {
+		if value, _ := r.TagValue("team"); value == "data" {
+			return []Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"}, {Key: "cloudoff:ttl", Value: "1w"}}
+		}
+		return nil
+	}))
+
+	resources, err := Discover(context.Background(), twoInstances{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if uptime, _ := resources[0].TagValue("cloudoff:uptime"); uptime != "Mon-Fri 08:00-20:00" {
+		t.Errorf("expected the uptime of the source, got %q", uptime)
+	}
+	// The tags of the resource take precedence
+	if uptime, _ := resources[1].TagValue("cloudoff:uptime"); uptime != "Mon-Fri 09:00-18:00" {
+		t.Errorf("expected the uptime of the resource, got %q", uptime)
+	}
+	if ttl, _ := resources[1].TagValue("cloudoff:ttl"); ttl != "1w" {
+		t.Errorf("expected the ttl of the source, got %q", ttl)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	ResetInventory()
+	ResetStore()
+	defer ResetInventory()
+	defer ResetStore()
+
+	UpdateInventory(full{}, []Resource{{ID: "2", Kind: "b"}, {ID: "1", Kind: "b"}})
+	UpdateInventory(discoverOnly{}, []Resource{{ID: "3", Kind: "a"}})
+	UpdateInventory(full{}, []Resource{{ID: "1", Kind: "b"}})
+
+	resources := Inventory()
+	if len(resources) != 2 || resources[0].ID != "3" || resources[1].ID != "1" {
+		t.Errorf("unexpected inventory %v", resources)
+	}
+
+	provider, found, err := Find("b", "", "", "1")
+	if err != nil || provider != (full{}) || found.ID != "1" {
+		t.Errorf("unexpected resource %v from %v: %v", found, provider, err)
+	}
+	if _, _, err := Find("b", "", "", "2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a removed resource not to be found, got %v", err)
+	}
+
+	// Resources sharing an ID in several regions are told apart by their region
+	UpdateInventory(stopStart{}, []Resource{{ID: "db", Kind: "c", Region: "eu-west-1"}, {ID: "db", Kind: "c", Region: "us-east-1"}})
+	if _, _, err := Find("c", "", "", "db"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("expected an ambiguous resource, got %v", err)
+	}
+	if _, found, err := Find("c", "", "us-east-1", "db"); err != nil || found.Region != "us-east-1" {
+		t.Errorf("unexpected resource %v: %v", found, err)
+	}
+
+	if _, ok := LastAction(context.Background(), resources[0]); ok {
+		t.Errorf("expected no action")
+	}
+	RecordAction(context.Background(), resources[0], CapabilityStop, nil)
+	action, ok := LastAction(context.Background(), resources[0])
+	if !ok || action.Action != CapabilityStop || action.Error != "" {
+		t.Errorf("unexpected action %+v", action)
+	}
+}
+
+func TestActiveOverride(t *testing.T) {
+	ResetStore()
+	defer ResetStore()
+
+	now := time.Date(2025, 1, 6, 19, 0, 0, 0, time.UTC)
+	r := Resource{ID: "i-1", Kind: "ec2-instance"}
+
+	if _, ok := ActiveOverride(context.Background(), r, now); ok {
+		t.Errorf("expected no override")
+	}
+
+	SetOverride(context.Background(), r, Override{Action: "start", State: StateRunning, Until: now.Add(time.Hour)})
+	if override, ok := ActiveOverride(context.Background(), r, now); !ok || override.State != StateRunning {
+		t.Errorf("unexpected override %+v", override)
+	}
+	if _, ok := ActiveOverride(context.Background(), Resource{ID: "i-1", Kind: "rds-instance"}, now); ok {
+		t.Errorf("expected overrides to be scoped by kind")
+	}
+	if _, ok := ActiveOverride(context.Background(), Resource{ID: "i-1", Kind: "ec2-instance", Region: "us-east-1"}, now); ok {
+		t.Errorf("expected overrides to be scoped by region")
+	}
+
+	if _, ok := ActiveOverride(context.Background(), r, now.Add(time.Hour)); ok {
+		t.Errorf("expected the override to expire")
+	}
+	if _, ok := ActiveOverride(context.Background(), r, now); ok {
+		t.Errorf("expected the expired override to be removed")
+	}
+}
+
+// slowStore blocks the loads of a key until it is released.
+type slowStore struct {
+	*MemoryStore
+	key     string
+	release chan struct{}
+}
+
+func (s *slowStore) Load(ctx context.Context, key string) (Record, bool, error) {
+	if key == s.key {
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return Record{}, false, ctx.Err()
+		}
+	}
+	return s.MemoryStore.Load(ctx, key)
+}
+
+func TestStoreConcurrency(t *testing.T) {
+	slow := Resource{ID: "i-slow", Kind: "ec2-instance"}
+	fast := Resource{ID: "i-fast", Kind: "ec2-instance"}
+
+	store := &slowStore{MemoryStore: NewMemoryStore(), key: Key(slow), release: make(chan struct{})}
+	SetStore(store)
+	defer ResetStore()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		SaveOriginalCapacity(context.Background(), slow, "desired=2")
+	}()
+
+	// A slow resource doesn't delay the others
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		SaveOriginalCapacity(context.Background(), fast, "desired=1")
+		LastAction(context.Background(), fast)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the other resources not to wait for the slow one")
+	}
+
+	// A cancelled caller stops waiting for the store
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := OriginalCapacity(ctx, slow); ok {
+		t.Errorf("expected no capacity with a cancelled context")
+	}
+
+	close(store.release)
+	<-done
+	if capacity, ok := OriginalCapacity(context.Background(), slow); !ok || capacity != "desired=2" {
+		t.Errorf("unexpected capacity %q", capacity)
+	}
+}
+
+func TestActionsInProgress(t *testing.T) {
+	stopDone := StartAction(Resource{ID: "i-1", Kind: "ec2-instance"}, CapabilityStop)
+	deleteDone := StartAction(Resource{ID: "i-2", Kind: "ec2-instance"}, CapabilityDelete)
+
+	actions := ActionsInProgress()
+	if len(actions) != 2 || actions[0].ID != "i-1" || actions[1].Action != CapabilityDelete {
+		t.Fatalf("expected 2 actions in progress, got %+v", actions)
+	}
+
+	stopDone()
+	actions = ActionsInProgress()
+	if len(actions) != 1 || actions[0].ID != "i-2" {
+		t.Errorf("expected the delete in progress, got %+v", actions)
+	}
+
+	deleteDone()
+	if actions := ActionsInProgress(); len(actions) != 0 {
+		t.Errorf("expected no action in progress, got %+v", actions)
+	}
+}
+
+func TestFailTransition(t *testing.T) {
+	ResetStore()
+	ResetInventory()
+	defer ResetStore()
+	defer ResetInventory()
+
+	now := time.Date(2023, 10, 2, 8, 0, 0, 0, time.UTC)
+	r := Resource{ID: "i-1", Kind: "ec2-instance", Account: "123456789012", Region: "eu-west-3"}
+	UpdateInventory(discoverOnly{}, []Resource{r})
+
+	tests := []struct {
+		name       string
+		retryLater string
+		attempts   int
+		retryAfter time.Duration
+		stuck      bool
+	}{
+		{name: "first failure", attempts: 1, retryAfter: 5 * time.Minute},
+		{name: "second failure", attempts: 2, retryAfter: 10 * time.Minute},
+		{name: "stuck", attempts: 3, retryAfter: 20 * time.Minute, stuck: true},
+		{name: "stuck without retry", retryLater: "false", attempts: 4, stuck: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("START_RETRY_LATER", tt.retryLater)
+			transition := FailTransition(context.Background(), r, CapabilityStart, errors.New("insufficient capacity"), now)
+			if transition.Attempts != tt.attempts || transition.Stuck != tt.stuck || transition.Error != "insufficient capacity" {
+				t.Fatalf("unexpected transition %+v", transition)
+			}
+			if tt.retryAfter == 0 {
+				if transition.RetryAt != nil || transition.RetryDue(now.Add(24*time.Hour)) {
+					t.Errorf("expected no retry, got %v", transition.RetryAt)
+				}
+				return
+			}
+			if !transition.RetryAt.Equal(now.Add(tt.retryAfter)) {
+				t.Errorf("expected a retry after %v, got %v", tt.retryAfter, transition.RetryAt)
+			}
+			if transition.RetryDue(now) || !transition.RetryDue(now.Add(tt.retryAfter)) {
+				t.Errorf("expected the retry due after %v", tt.retryAfter)
+			}
+		})
+	}
+
+	// A successful start keeps the failed attempts until the instance runs
+	BeginTransition(context.Background(), r, CapabilityStart, now)
+	if transition, _ := PendingTransition(context.Background(), r); transition.Attempts != 4 || transition.Stuck || transition.RetryDue(now.Add(24*time.Hour)) {
+		t.Errorf("expected a start in progress, got %+v", transition)
+	}
+
+	// The transition is kept in the record of the resource, with the other replicas
+	record, _, _ := currentStore().Load(context.Background(), Key(r))
+	if record.Transition == nil || record.Transition.Action != CapabilityStart || record.Transition.Account != r.Account {
+		t.Errorf("expected the transition stored, got %+v", record.Transition)
+	}
+	if transitions := Transitions(context.Background()); len(transitions) != 1 || transitions[0].ID != r.ID {
+		t.Errorf("expected the transition listed, got %+v", transitions)
+	}
+
+	CompleteTransition(context.Background(), r)
+	if transitions := Transitions(context.Background()); len(transitions) != 0 {
+		t.Errorf("expected no transition, got %+v", transitions)
+	}
+}
+
+func TestAbortTransition(t *testing.T) {
+	ResetStore()
+	defer ResetStore()
+
+	now := time.Date(2023, 10, 2, 8, 0, 0, 0, time.UTC)
+	r := Resource{ID: "i-1", Kind: "ec2-instance", Region: "eu-west-3"}
+
+	tests := []struct {
+		action   Capability
+		attempts int
+		stuck    bool
+	}{
+		{action: CapabilityStart, attempts: 1},
+		{action: CapabilityStop, stuck: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.action), func(t *testing.T) {
+			BeginTransition(context.Background(), r, tt.action, now)
+			transition, _ := PendingTransition(context.Background(), r)
+			if transition.Expired(now.Add(TransitionTimeout)) || !transition.Expired(now.Add(TransitionTimeout+time.Second)) {
+				t.Errorf("expected the transition expired after %v", TransitionTimeout)
+			}
+
+			// The waiter and the scheduler abort the transition once
+			later := now.Add(TransitionTimeout + time.Second)
+			if !AbortTransition(context.Background(), r, errors.New("timeout"), later) {
+				t.Fatalf("expected the transition aborted")
+			}
+			if AbortTransition(context.Background(), r, errors.New("timeout"), later) {
+				t.Errorf("expected the transition aborted once")
+			}
+
+			transition, _ = PendingTransition(context.Background(), r)
+			if transition.Attempts != tt.attempts || transition.Stuck != tt.stuck || transition.InProgress() || transition.Expired(later) {
+				t.Errorf("unexpected transition %+v", transition)
+			}
+			CompleteTransition(context.Background(), r)
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package resource
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var logger *slog.Logger
+
+// HistorySize is the number of actions kept per resource.
+const HistorySize = 100
+
+// Record is the state cloudoff remembers about a resource between its runs.
+type Record struct {
+	LastAction *Action `json:"lastAction,omitempty"`
+	// OriginalCapacity is the capacity to restore on a resource scaled to zero by cloudoff,
+	// in the format of the provider
+	OriginalCapacity string      `json:"originalCapacity,omitempty"`
+	Override         *Override   `json:"override,omitempty"`
+	Transition       *Transition `json:"transition,omitempty"`
+	// TTLExtension postpones the expiration of the cloudoff:ttl tag
+	TTLExtension *TTLExtension `json:"ttlExtension,omitempty"`
+}
+
+// Store persists the records and the action history of the resources. The stores are
+// safe for concurrent use, the network stores honor the deadline of the context.
+type Store interface {
+	Load(ctx context.Context, key string) (Record, bool, error)
+	Save(ctx context.Context, key string, record Record) error
+	AppendHistory(ctx context.Context, key string, action Action) error
+	// History returns the last actions performed on a resource, most recent first.
+	History(ctx context.Context, key string, limit int) ([]Action, error)
+	Close() error
+}
+
+var (
+	storeMu sync.RWMutex
+	store   Store = NewMemoryStore()
+
+	// recordLocks serializes the updates of each record, so a slow store only delays
+	// the callers of the same resource
+	recordLocks sync.Map
+)
+
+// SetStore replaces the store, the previous one is closed.
+func SetStore(s Store) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if err := store.Close(); err != nil {
+		logger.Error("error closing state store", "error", err)
+	}
+	store = s
+}
+
+// ResetStore replaces the store with an empty memory store.
+func ResetStore() {
+	SetStore(NewMemoryStore())
+}
+
+// currentStore returns the store, the lock is only held to read it.
+func currentStore() Store {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	return store
+}
+
+// lockRecord locks the record of a key until the returned function is called.
+func lockRecord(key string) func() {
+	value, _ := recordLocks.LoadOrStore(key, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// Key identifies a resource in the store. The names of many resources (Auto Scaling
+// groups, RDS, EKS...) are only unique in an account and a region, and the store can be
+// shared by the deployments of several accounts.
+func Key(r Resource) string {
+	return r.Kind + "/" + r.Account + "/" + r.Region + "/" + r.ID
+}
+
+// loadRecord returns the record of a resource, or an empty record when it can't be read.
+func loadRecord(ctx context.Context, r Resource) Record {
+	record, _, err := currentStore().Load(ctx, Key(r))
+	if err != nil {
+		logger.Error("error loading state", "kind", r.Kind, "resource", r.ID, "error", err)
+	}
+	return record
+}
+
+// updateRecord applies a change to the record of a resource.
+func updateRecord(ctx context.Context, r Resource, change func(record *Record)) {
+	key := Key(r)
+	unlock := lockRecord(key)
+	defer unlock()
+
+	s := currentStore()
+	record, _, err := s.Load(ctx, key)
+	if err != nil {
+		logger.Error("error loading state", "kind", r.Kind, "resource", r.ID, "error", err)
+		return
+	}
+	change(&record)
+	if err := s.Save(ctx, key, record); err != nil {
+		logger.Error("error saving state", "kind", r.Kind, "resource", r.ID, "error", err)
+	}
+}
+
+// RecordAction records the result of an action performed on a resource. It is recorded
+// even when the context of the action was cancelled, within the deadline of the store.
+func RecordAction(ctx context.Context, r Resource, action Capability, err error) {
+	ctx = context.WithoutCancel(ctx)
+	recorded := Action{Action: action, Time: time.Now()}
+	if err != nil {
+		recorded.Error = err.Error()
+	}
+
+	updateRecord(ctx, r, func(record *Record) { record.LastAction = &recorded })
+
+	if err := currentStore().AppendHistory(ctx, Key(r), recorded); err != nil {
+		logger.Error("error saving action history", "kind", r.Kind, "resource", r.ID, "error", err)
+	}
+}
+
+// LastAction returns the last action performed on a resource.
+func LastAction(ctx context.Context, r Resource) (Action, bool) {
+	record := loadRecord(ctx, r)
+	if record.LastAction == nil {
+		return Action{}, false
+	}
+	return *record.LastAction, true
+}
+
+// History returns the last actions performed on a resource, most recent first.
+func History(ctx context.Context, r Resource, limit int) ([]Action, error) {
+	return currentStore().History(ctx, Key(r), limit)
+}
+
+// SaveOriginalCapacity remembers the capacity to restore on a resource, an empty value
+// removes it.
+func SaveOriginalCapacity(ctx context.Context, r Resource, capacity string) {
+	updateRecord(ctx, r, func(record *Record) { record.OriginalCapacity = capacity })
+}
+
+// OriginalCapacity returns the capacity remembered for a resource.
+func OriginalCapacity(ctx context.Context, r Resource) (string, bool) {
+	record := loadRecord(ctx, r)
+	return record.OriginalCapacity, record.OriginalCapacity != ""
+}
+
+// MemoryStore keeps the records in memory, they are lost when cloudoff restarts.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+	history map[string][]Action
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]Record{}, history: map[string][]Action{}}
+}
+
+func (s *MemoryStore) Load(ctx context.Context, key string) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[key]
+	return record, ok, nil
+}
+
+func (s *MemoryStore) Save(ctx context.Context, key string, record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = record
+	return nil
+}
+
+func (s *MemoryStore) AppendHistory(ctx context.Context, key string, action Action) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	history := append(s.history[key], action)
+	if len(history) > HistorySize {
+		history = history[len(history)-HistorySize:]
+	}
+	s.history[key] = history
+	return nil
+}
+
+func (s *MemoryStore) History(ctx context.Context, key string, limit int) ([]Action, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	history := s.history[key]
+
+	var actions []Action
+	for i := len(history) - 1; i >= 0 && (limit <= 0 || len(actions) < limit); i-- {
+		actions = append(actions, history[i])
+	}
+	return actions, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func init() {
+	logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+}
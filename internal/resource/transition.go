@@ -0,0 +1,220 @@
+package resource
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Retries of the failed starts: the delay doubles after each attempt, up to maxRetryDelay.
+const (
+	firstRetryDelay         = 5 * time.Minute
+	maxRetryDelay           = time.Hour
+	defaultStartMaxAttempts = 3
+)
+
+// TransitionTimeout is how long a stop or a start is waited for before it is failed.
+var TransitionTimeout = 10 * time.Minute
+
+// Transition is a stop or a start of a resource which has not reached its new state yet:
+// accepted by the provider and being waited for, or failed and waiting for a retry. It is
+// kept in the record of the resource, so it survives a restart or a change of leader.
+type Transition struct {
+	Kind    string     `json:"kind"`
+	ID      string     `json:"id"`
+	Account string     `json:"account,omitempty"`
+	Region  string     `json:"region"`
+	Action  Capability `json:"action"`
+	Started time.Time  `json:"started"`
+	// Attempts is the number of failed attempts, the next one is not made before RetryAt
+	Attempts int        `json:"attempts,omitempty"`
+	RetryAt  *time.Time `json:"retryAt,omitempty"`
+	// Stuck is set when the resource didn't reach its state in time or the starts keep
+	// failing, the resource needs to be looked at
+	Stuck bool   `json:"stuck"`
+	Error string `json:"error,omitempty"`
+}
+
+// RetryDue reports whether a failed action can be attempted again at the given time.
+// A transition still in progress, or stuck without retry, is never due.
+func (t Transition) RetryDue(now time.Time) bool {
+	return t.Attempts > 0 && t.RetryAt != nil && !now.Before(*t.RetryAt)
+}
+
+// InProgress reports whether the action was accepted and is still waited for.
+func (t Transition) InProgress() bool {
+	return t.RetryAt == nil && !t.Stuck
+}
+
+// Expired reports whether an action in progress exceeded TransitionTimeout at the given
+// time. Its waiter failed it already, unless it was lost with a restart or a change of
+// leader.
+func (t Transition) Expired(now time.Time) bool {
+	return t.InProgress() && now.Sub(t.Started) > TransitionTimeout
+}
+
+var stuckResources = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "cloudoff_stuck_resources",
+	Help: "Number of resources which didn't reach their state after a stop or a start.",
+}, []string{"kind", "action"})
+
+// BeginTransition records a stop or a start accepted by the provider at the given time.
+// The failed attempts of the same action are kept so the retries keep backing off.
+func BeginTransition(ctx context.Context, r Resource, action Capability, now time.Time) {
+	updateTransition(ctx, r, func(record *Record) {
+		transition := newTransition(r, action, now)
+		if record.Transition != nil && record.Transition.Action == action {
+			transition.Attempts = record.Transition.Attempts
+		}
+		record.Transition = &transition
+	})
+}
+
+// CompleteTransition forgets the transition of a resource once its state is reached.
+func CompleteTransition(ctx context.Context, r Resource) {
+	updateTransition(ctx, r, func(record *Record) { record.Transition = nil })
+}
+
+// FailTransition records a failed attempt of an action and returns the transition. The
+// action is retried with an increasing delay and the resource is flagged as stuck after
+// START_MAX_ATTEMPTS attempts (3 by default). Stuck resources are retried every hour,
+// unless START_RETRY_LATER is false.
+func FailTransition(ctx context.Context, r Resource, action Capability, err error, now time.Time) Transition {
+	var transition Transition
+	updateTransition(ctx, r, func(record *Record) {
+		transition = failedAttempt(r, record.Transition, action, err, now)
+		record.Transition = &transition
+	})
+
+	if transition.Stuck {
+		logger.Error("resource stuck, action keeps failing", "kind", r.Kind, "resource", r.ID, "region", r.Region, "action", action, "attempts", transition.Attempts, "error", err)
+	}
+	return transition
+}
+
+// AbortTransition fails the transition of a resource still in progress: a start is
+// retried like a failed one and a stop is flagged as stuck. It reports whether the
+// transition was aborted, so its waiter and the scheduler noticing its timeout abort it
+// once.
+func AbortTransition(ctx context.Context, r Resource, err error, now time.Time) bool {
+	var transition Transition
+	var aborted bool
+	updateTransition(ctx, r, func(record *Record) {
+		if record.Transition == nil || !record.Transition.InProgress() {
+			return
+		}
+		aborted = true
+		transition = *record.Transition
+		if transition.Action == CapabilityStart {
+			transition = failedAttempt(r, record.Transition, transition.Action, err, now)
+		} else {
+			transition.Stuck = true
+			transition.Error = err.Error()
+		}
+		record.Transition = &transition
+	})
+
+	if aborted {
+		logger.Error("resource transition failed", "kind", r.Kind, "resource", r.ID, "region", r.Region, "action", transition.Action, "attempts", transition.Attempts, "stuck", transition.Stuck, "error", err)
+	}
+	return aborted
+}
+
+// PendingTransition returns the transition of a resource, if any.
+func PendingTransition(ctx context.Context, r Resource) (Transition, bool) {
+	record := loadRecord(ctx, r)
+	if record.Transition == nil {
+		return Transition{}, false
+	}
+	return *record.Transition, true
+}
+
+// Transitions returns the pending transitions of the discovered resources, oldest first.
+// They are read from the store, so every replica returns those of the leader.
+func Transitions(ctx context.Context) []Transition {
+	var list []Transition
+	for _, r := range Inventory() {
+		if transition, ok := PendingTransition(ctx, r); ok {
+			list = append(list, transition)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Started.Equal(list[j].Started) {
+			return list[i].Started.Before(list[j].Started)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// UpdateStuckResources counts the stuck resources among the discovered ones.
+func UpdateStuckResources(ctx context.Context) {
+	transitions := Transitions(ctx)
+
+	stuckResources.Reset()
+	for _, transition := range transitions {
+		if transition.Stuck {
+			stuckResources.WithLabelValues(transition.Kind, string(transition.Action)).Inc()
+		}
+	}
+}
+
+// failedAttempt returns the transition of an action after one more failed attempt,
+// following the previous transition of the resource if any.
+func failedAttempt(r Resource, previous *Transition, action Capability, err error, now time.Time) Transition {
+	transition := newTransition(r, action, now)
+	if previous != nil && previous.Action == action {
+		transition = *previous
+	}
+	transition.Attempts++
+	transition.Error = err.Error()
+	transition.Stuck = transition.Attempts >= startMaxAttempts()
+
+	retryAt := now.Add(retryDelay(transition.Attempts))
+	transition.RetryAt = &retryAt
+	if transition.Stuck && os.Getenv("START_RETRY_LATER") == "false" {
+		transition.RetryAt = nil
+	}
+	return transition
+}
+
+// newTransition returns the transition of an action started at the given time.
+func newTransition(r Resource, action Capability, started time.Time) Transition {
+	return Transition{Kind: r.Kind, ID: r.ID, Account: r.Account, Region: r.Region, Action: action, Started: started}
+}
+
+// updateTransition applies a change to the transition of a resource. The transitions
+// follow actions already accepted by the provider, they are recorded even when the
+// context of the action was cancelled.
+func updateTransition(ctx context.Context, r Resource, change func(record *Record)) {
+	updateRecord(context.WithoutCancel(ctx), r, change)
+}
+
+// retryDelay returns the delay before the next attempt after the given failed attempts.
+func retryDelay(attempts int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// startMaxAttempts returns the failed attempts after which a resource is stuck, read
+// from START_MAX_ATTEMPTS.
+func startMaxAttempts() int {
+	value := os.Getenv("START_MAX_ATTEMPTS")
+	if value == "" {
+		return defaultStartMaxAttempts
+	}
+	attempts, err := strconv.Atoi(value)
+	if err != nil || attempts < 1 {
+		logger.Error("invalid START_MAX_ATTEMPTS, default used", "value", value, "default", defaultStartMaxAttempts)
+		return defaultStartMaxAttempts
+	}
+	return attempts
+}
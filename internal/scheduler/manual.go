@@ -0,0 +1,216 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/audit"
+	"github.com/bananaops/cloudoff/internal/clean"
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+// Manual actions requested through the API
+const (
+	// ActionStart starts a resource and keeps it running until the next transition or for the given hours
+	ActionStart = "start"
+	// ActionStop stops a resource and keeps it stopped until the next transition or for the given hours
+	ActionStop = "stop"
+	// ActionSkip keeps the current state of a resource over its next transition
+	ActionSkip = "skip"
+	// ActionSnooze starts a resource if needed and keeps it running for the given hours
+	ActionSnooze = "snooze"
+	// ActionResume removes the override of a resource so its schedule applies again
+	ActionResume = "resume"
+	// ActionExtend postpones the deletion of a resource by its cloudoff:ttl for the given hours
+	ActionExtend = "extend"
+)
+
+// ApplyManualAction performs a manual action on a resource and records an override so
+// the scheduler doesn't revert it on its next run. It returns the recorded override,
+// or nil when the schedule applies.
+func ApplyManualAction(ctx context.Context, provider resource.Provider, r resource.Resource, action string, hours int, now time.Time) (*resource.Override, error) {
+	if hours < 0 {
+		return nil, fmt.Errorf("invalid hours : %d", hours)
+	}
+
+	var state resource.State
+	var until time.Time
+	var err error
+
+	switch action {
+	case ActionStart, ActionStop:
+		state = resource.StateRunning
+		if action == ActionStop {
+			state = resource.StateStopped
+		}
+		until, err = overrideEnd(r, hours, now)
+	case ActionSnooze:
+		if hours == 0 {
+			return nil, fmt.Errorf("hours is required to snooze downtime")
+		}
+		state = resource.StateRunning
+		until = now.Add(time.Duration(hours) * time.Hour)
+	case ActionSkip:
+		state, until, err = skipNextTransition(r, now)
+	case ActionResume:
+		resource.ClearOverride(ctx, r)
+		return nil, nil
+	case ActionExtend:
+		return nil, extendTTL(ctx, r, hours, now)
+	default:
+		return nil, fmt.Errorf("unknown action : %s", action)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The override is recorded before the action, so a reconcile cycle running meanwhile
+	// doesn't revert it. Unscheduled resources are never changed by the scheduler.
+	var override *resource.Override
+	previous, hadPrevious := resource.ActiveOverride(ctx, r, now)
+	if !until.IsZero() {
+		override = &resource.Override{Action: action, State: state, Until: until, Created: now}
+		resource.SetOverride(ctx, r, *override)
+	}
+
+	if state != r.State {
+		if err := changeState(ctx, provider, r, action, state); err != nil {
+			// The action failed, the previous override applies again
+			if override != nil {
+				restoreOverride(context.WithoutCancel(ctx), r, previous, hadPrevious)
+			}
+			return nil, err
+		}
+	}
+
+	if override != nil {
+		logger.Info("manual action applied", "kind", r.Kind, "resource", r.ID, "action", action, "state", state, "until", until)
+	}
+	return override, nil
+}
+
+// restoreOverride sets back the override a failed manual action replaced, if any.
+func restoreOverride(ctx context.Context, r resource.Resource, previous resource.Override, hadPrevious bool) {
+	if hadPrevious {
+		resource.SetOverride(ctx, r, previous)
+		return
+	}
+	resource.ClearOverride(ctx, r)
+}
+
+// overrideEnd returns when the state forced by a manual start or stop ends: after the
+// given hours, otherwise at the next transition of the schedule.
+func overrideEnd(r resource.Resource, hours int, now time.Time) (time.Time, error) {
+	if hours > 0 {
+		return now.Add(time.Duration(hours) * time.Hour), nil
+	}
+	if !hasSchedule(r.Tags) && !r.StoppedByCloudoff {
+		return time.Time{}, nil
+	}
+
+	next, ok, err := NextTransition(r.Tags, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ok {
+		return time.Time{}, fmt.Errorf("resource %s has no scheduled transition, hours is required", r.ID)
+	}
+	return next, nil
+}
+
+// skipNextTransition returns the current desired state of a resource and the time of
+// the transition following the next one.
+func skipNextTransition(r resource.Resource, now time.Time) (resource.State, time.Time, error) {
+	downtime, err := IsDowntime(r.Tags, now)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	state := resource.StateRunning
+	if downtime {
+		state = resource.StateStopped
+	}
+
+	next, ok, err := NextTransition(r.Tags, now)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("resource %s has no scheduled transition to skip", r.ID)
+	}
+
+	// Without a following transition the state is kept for a week, as schedules repeat weekly
+	following, ok, err := NextTransition(r.Tags, next)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !ok {
+		following = next.AddDate(0, 0, 7)
+	}
+	return state, following, nil
+}
+
+// extendTTL postpones the expiration of the ttl of a resource by the given hours, from its
+// current expiration or from now when it already elapsed.
+func extendTTL(ctx context.Context, r resource.Resource, hours int, now time.Time) error {
+	if hours == 0 {
+		return fmt.Errorf("hours is required to extend the ttl")
+	}
+	ttl, _ := r.TagValue("cloudoff:ttl")
+	expiration, ok := clean.Expiration(ctx, r)
+	if !ok {
+		return fmt.Errorf("resource %s has no cloudoff:ttl to extend", r.ID)
+	}
+
+	until := now.Add(time.Duration(hours) * time.Hour)
+	if expiration.After(now) {
+		until = expiration.Add(time.Duration(hours) * time.Hour)
+	}
+
+	// The extension only changes the state of cloudoff, it is recorded in dry run too
+	resource.ExtendTTL(ctx, r, resource.TTLExtension{Until: until, Created: now})
+	audit.Record(audit.NewEvent(audit.ActorAPI, ActionExtend, audit.ReasonManual+" "+ActionExtend, r).WithTag("cloudoff:ttl", ttl), nil)
+	logger.Info("ttl extended", "kind", r.Kind, "resource", r.ID, "ttl", ttl, "until", until)
+	return nil
+}
+
+// changeState stops or starts a resource for a manual action.
+func changeState(ctx context.Context, provider resource.Provider, r resource.Resource, action string, state resource.State) error {
+	capability := resource.CapabilityStart
+	if state == resource.StateStopped {
+		capability = resource.CapabilityStop
+	}
+	event := audit.NewEvent(audit.ActorAPI, string(capability), audit.ReasonManual+" "+action, r)
+
+	if os.Getenv("DRYRUN") == "true" {
+		event.DryRun = true
+		audit.Record(event, nil)
+		return nil
+	}
+
+	done := resource.StartAction(r, capability)
+	defer done()
+
+	var err error
+	if capability == resource.CapabilityStop {
+		stopper, ok := provider.(resource.Stopper)
+		if !ok {
+			return fmt.Errorf("%s resources can't be stopped", r.Kind)
+		}
+		err = stopper.Stop(ctx, r)
+	} else {
+		starter, ok := provider.(resource.Starter)
+		if !ok {
+			return fmt.Errorf("%s resources can't be started", r.Kind)
+		}
+		err = starter.Start(ctx, r)
+	}
+
+	resource.RecordAction(ctx, r, capability, err)
+	audit.Record(event, err)
+	if err == nil {
+		resource.SetState(r, state)
+	}
+	return err
+}
@@ -0,0 +1,211 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/audit"
+	ec2 "github.com/bananaops/cloudoff/internal/aws"
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+// NatGatewayPlan describes the changes made to a NAT gateway and its routes.
+type NatGatewayPlan struct {
+	Action       string
+	NatGatewayID string
+	SubnetID     string
+	AllocationID string
+	Routes       []ec2.Route
+}
+
+// Changes returns a human readable line for the NAT gateway and for each route.
+func (p NatGatewayPlan) Changes() []string {
+	var changes []string
+	switch p.Action {
+	case "delete":
+		changes = append(changes, fmt.Sprintf("delete nat gateway %s (subnet %s, address %s)", p.NatGatewayID, p.SubnetID, p.AllocationID))
+		for _, route := range p.Routes {
+			changes = append(changes, fmt.Sprintf("route %s %s: %s -> blackhole", route.RouteTableID, route.DestinationCidrBlock, p.NatGatewayID))
+		}
+	case "create":
+		changes = append(changes, fmt.Sprintf("create nat gateway (subnet %s, address %s)", p.SubnetID, p.AllocationID))
+		for _, route := range p.Routes {
+			changes = append(changes, fmt.Sprintf("route %s %s: blackhole -> new nat gateway", route.RouteTableID, route.DestinationCidrBlock))
+		}
+	}
+	return changes
+}
+
+func logPlan(plan NatGatewayPlan, dryRun bool) {
+	for _, change := range plan.Changes() {
+		logger.Info("nat gateway plan", "dryrun", dryRun, "change", change)
+	}
+}
+
+// ScheduleNatGateway deletes NAT gateways during downtime and recreates them with the
+// same subnet, Elastic IP and routes when uptime begins. The recreation metadata is kept
+// in tags on the Elastic IP and the route tables while the NAT gateway doesn't exist.
+func ScheduleNatGateway(ctx context.Context) {
+
+	natGateways, err := ec2.DiscoverNatGateways(ctx)
+	if err != nil {
+		logger.Error("error discovering nat gateways", "error", err)
+		return
+	}
+
+	addresses, err := ec2.DiscoverAddresses(ctx)
+	if err != nil {
+		logger.Error("error discovering addresses", "error", err)
+		return
+	}
+
+	dryRun := os.Getenv("DRYRUN") == "true"
+	currentTime := time.Now()
+
+	natGatewaysByAllocation := map[string]ec2.NatGateway{}
+	for _, natGateway := range natGateways {
+		if natGateway.AllocationID != "" {
+			natGatewaysByAllocation[natGateway.AllocationID] = natGateway
+		}
+	}
+
+	for _, natGateway := range natGateways {
+		downscaleNatGateway(ctx, natGateway, currentTime, dryRun)
+	}
+
+	for _, address := range addresses {
+		upscaleNatGateway(ctx, address, natGatewaysByAllocation, currentTime, dryRun)
+	}
+}
+
+func downscaleNatGateway(ctx context.Context, natGateway ec2.NatGateway, currentTime time.Time, dryRun bool) {
+
+	if !hasSchedule(natGateway.Tags) || natGateway.State != "available" {
+		return
+	}
+
+	if natGateway.Private || natGateway.AllocationID == "" {
+		logger.Warn("schedule ignored, private nat gateways are not supported", "natgateway", natGateway.ID)
+		return
+	}
+
+	if natGateway.SecondaryAddresses {
+		logger.Warn("schedule ignored, nat gateways with secondary addresses are not supported", "natgateway", natGateway.ID)
+		return
+	}
+
+	downtime, err := IsDowntime(natGateway.Tags, currentTime)
+	if err != nil {
+		logger.Error("error checking schedule", "natgateway", natGateway.ID, "error", err)
+		return
+	}
+	if !downtime {
+		return
+	}
+
+	routes, err := ec2.NatGatewayRoutes(ctx, natGateway)
+	if err != nil {
+		logger.Error("error reading routes", "natgateway", natGateway.ID, "error", err)
+		return
+	}
+
+	logPlan(NatGatewayPlan{
+		Action:       "delete",
+		NatGatewayID: natGateway.ID,
+		SubnetID:     natGateway.SubnetID,
+		AllocationID: natGateway.AllocationID,
+		Routes:       routes,
+	}, dryRun)
+
+	event := natGatewayEvent("delete", natGateway.ID, natGateway.Region, natGateway.Tags, currentTime)
+	event.DryRun = dryRun
+	if dryRun {
+		audit.Record(event, nil)
+		return
+	}
+
+	done := resource.StartAction(resource.Resource{Kind: ec2.KindNatGateway, ID: natGateway.ID, Region: natGateway.Region}, resource.CapabilityDelete)
+	err = ec2.DeleteNatGateway(ctx, natGateway, routes)
+	done()
+	if err != nil {
+		logger.Error("error deleting nat gateway", "natgateway", natGateway.ID, "error", err)
+	}
+	audit.Record(event, err)
+}
+
+func upscaleNatGateway(ctx context.Context, address ec2.Address, natGatewaysByAllocation map[string]ec2.NatGateway, currentTime time.Time, dryRun bool) {
+
+	subnetID, tags, ok := address.ReservedNatGateway()
+	if !ok {
+		return
+	}
+
+	// Recreated on a previous run, the routes are restored once it is available
+	if natGateway, exists := natGatewaysByAllocation[address.AllocationID]; exists {
+		if natGateway.State != "available" || dryRun {
+			return
+		}
+		routes, err := ec2.SavedNatGatewayRoutes(ctx, address)
+		if err != nil {
+			logger.Error("error reading saved routes", "natgateway", natGateway.ID, "error", err)
+			return
+		}
+		if err := ec2.RestoreNatGatewayRoutes(ctx, natGateway, address, routes); err != nil {
+			logger.Error("error restoring routes", "natgateway", natGateway.ID, "error", err)
+		}
+		return
+	}
+
+	// Still used by the NAT gateway being deleted
+	if address.Associated {
+		return
+	}
+
+	downtime, err := IsDowntime(tags, currentTime)
+	if err != nil {
+		logger.Error("error checking schedule", "address", address.AllocationID, "error", err)
+		return
+	}
+	if downtime {
+		return
+	}
+
+	routes, err := ec2.SavedNatGatewayRoutes(ctx, address)
+	if err != nil {
+		logger.Error("error reading saved routes", "address", address.AllocationID, "error", err)
+		return
+	}
+
+	logPlan(NatGatewayPlan{
+		Action:       "create",
+		SubnetID:     subnetID,
+		AllocationID: address.AllocationID,
+		Routes:       routes,
+	}, dryRun)
+
+	// The NAT gateway is identified by its Elastic IP until it is created
+	event := natGatewayEvent("create", address.AllocationID, address.Region, tags, currentTime)
+	event.DryRun = dryRun
+	if dryRun {
+		audit.Record(event, nil)
+		return
+	}
+
+	done := resource.StartAction(resource.Resource{Kind: ec2.KindNatGateway, ID: address.AllocationID, Region: address.Region}, resource.CapabilityStart)
+	natGatewayID, err := ec2.CreateNatGateway(ctx, address)
+	done()
+	if err != nil {
+		logger.Error("error creating nat gateway", "address", address.AllocationID, "error", err)
+	} else {
+		event.Resource = natGatewayID
+	}
+	audit.Record(event, err)
+}
+
+// natGatewayEvent returns the audit event of the deletion or the creation of a NAT gateway.
+func natGatewayEvent(action, id, region string, tags []ec2.Tag, currentTime time.Time) audit.Event {
+	r := resource.Resource{Kind: ec2.KindNatGateway, ID: id, Region: region, Tags: tags}
+	return ScheduledEvent(r, action, action == "delete", currentTime)
+}
@@ -0,0 +1,156 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/clean"
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+// Reconcile discovers the resources of every registered provider once, applies their
+// schedule and deletes the ones whose ttl has elapsed. It returns the time of the next
+// scheduled change among the resources, and false when none is planned.
+func Reconcile(ctx context.Context, now time.Time) (time.Time, bool) {
+	discovered := discoverResources(ctx)
+
+	var next time.Time
+	for _, provider := range resource.Providers() {
+		deleter, canDelete := provider.(resource.Deleter)
+		for i, r := range discovered[provider] {
+			// The actions are cancelled at shutdown, the remaining resources are skipped
+			if ctx.Err() != nil {
+				logger.Warn("reconcile cycle interrupted", "kind", provider.Kind(), "skipped", len(discovered[provider])-i, "error", ctx.Err())
+				return time.Time{}, false
+			}
+
+			ScheduleResource(ctx, provider, r, now)
+			if canDelete {
+				clean.CleanResource(ctx, deleter, r)
+			}
+
+			if change, ok := nextChange(ctx, r, now); ok && (next.IsZero() || change.Before(next)) {
+				next = change
+			}
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// nextChange returns the next time the desired state of a resource changes: the end of
+// its override, its next transition or the expiration of its ttl, or the next retry of
+// a failed start.
+func nextChange(ctx context.Context, r resource.Resource, now time.Time) (time.Time, bool) {
+	var changes []time.Time
+	if override, ok := resource.ActiveOverride(ctx, r, now); ok {
+		changes = append(changes, override.Until)
+	}
+	if transition, ok := resource.PendingTransition(ctx, r); ok && transition.RetryAt != nil && transition.RetryAt.After(now) {
+		changes = append(changes, *transition.RetryAt)
+	}
+	if transition, ok, err := NextTransition(r.Tags, now); err == nil && ok {
+		changes = append(changes, transition)
+	}
+	if expiration, ok := clean.Expiration(ctx, r); ok && expiration.After(now) {
+		changes = append(changes, expiration)
+	}
+
+	var next time.Time
+	for _, change := range changes {
+		if next.IsZero() || change.Before(next) {
+			next = change
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// Loop runs the reconcile cycles one after the other, so they never overlap. After a
+// cycle it sleeps until the next scheduled change of the resources, at most for the
+// resync interval so the changes made outside of cloudoff are caught up.
+type Loop struct {
+	resync   time.Duration
+	isLeader func() bool
+	now      func() time.Time
+	// reconcile runs a cycle on the leader, discover refreshes the inventory of the followers
+	reconcile func(ctx context.Context, now time.Time) (time.Time, bool)
+	discover  func(ctx context.Context)
+	wake      chan struct{}
+	// stop is closed by Stop, done when Run returns
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewLoop(resync time.Duration, isLeader func() bool) *Loop {
+	return &Loop{
+		resync:    resync,
+		isLeader:  isLeader,
+		now:       time.Now,
+		reconcile: Reconcile,
+		discover:  func(ctx context.Context) { discoverResources(ctx) },
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Run runs the cycles until Stop is called or ctx is cancelled, which also cancels the
+// actions of the cycle in progress. The replicas which are not the leader only refresh
+// the inventory at every resync.
+func (l *Loop) Run(ctx context.Context) {
+	defer close(l.done)
+	for {
+		select {
+		case <-l.stop:
+			return
+		default:
+		}
+
+		wait := l.resync
+		now := l.now()
+		if l.isLeader() {
+			if next, ok := l.reconcile(ctx, now); ok {
+				wait = min(wait, next.Sub(l.now()))
+			}
+		} else {
+			l.discover(ctx)
+		}
+
+		// Never spin when the next change is already due
+		timer := time.NewTimer(max(wait, time.Second))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-l.stop:
+			timer.Stop()
+			return
+		case <-l.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Wake starts a cycle without waiting for the next scheduled change, for example when
+// this replica becomes the leader.
+func (l *Loop) Wake() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop stops the loop without interrupting the cycle in progress, as cron.Stop does. The
+// returned context is done once Run has returned.
+func (l *Loop) Stop() context.Context {
+	l.stopOnce.Do(func() { close(l.stop) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-l.done
+		cancel()
+	}()
+	return ctx
+}
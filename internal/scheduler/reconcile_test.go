@@ -0,0 +1,222 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/audit"
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+type reconciledProvider struct {
+	fakeProvider
+	resources []resource.Resource
+	deleted   []string
+}
+
+func (p *reconciledProvider) Discover(ctx context.Context) ([]resource.Resource, error) {
+	return p.resources, nil
+}
+
+func (p *reconciledProvider) Delete(ctx context.Context, r resource.Resource) error {
+	p.deleted = append(p.deleted, r.ID)
+	return nil
+}
+
+func TestReconcile(t *testing.T) {
+	resource.Reset()
+	resource.ResetInventory()
+	resource.ResetStore()
+	audit.SetSink(audit.NewWriterSink(&bytes.Buffer{}))
+	defer resource.Reset()
+	defer resource.ResetInventory()
+	defer resource.ResetStore()
+	defer audit.ResetSink()
+
+	monday22 := time.Date(2023, 10, 2, 22, 0, 0, 0, time.UTC)
+	provider := &reconciledProvider{resources: []resource.Resource{
+		{ID: "scheduled", Tags: []resource.Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"}}, State: resource.StateRunning},
+		{ID: "expired", Tags: []resource.Tag{{Key: "cloudoff:ttl", Value: "1h"}}, State: resource.StateRunning, TTLStart: time.Now().Add(-2 * time.Hour)},
+	}}
+	resource.Register(provider)
+
+	next, ok := Reconcile(context.Background(), monday22)
+
+	if len(provider.stopped) != 1 || provider.stopped[0] != "scheduled" {
+		t.Errorf("expected the scheduled resource stopped, got %v", provider.stopped)
+	}
+	if len(provider.deleted) != 1 || provider.deleted[0] != "expired" {
+		t.Errorf("expected the expired resource deleted, got %v", provider.deleted)
+	}
+	if expected := time.Date(2023, 10, 3, 8, 0, 0, 0, time.UTC); !ok || !next.Equal(expected) {
+		t.Errorf("expected next change at %v, got %v (%v)", expected, next, ok)
+	}
+	if len(resource.Inventory()) != 2 {
+		t.Errorf("expected the inventory updated, got %d resources", len(resource.Inventory()))
+	}
+}
+
+func TestNextChange(t *testing.T) {
+	resource.ResetStore()
+	defer resource.ResetStore()
+
+	monday22 := time.Date(2023, 10, 2, 22, 0, 0, 0, time.UTC)
+	uptime := resource.Tag{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"}
+	ttl := resource.Tag{Key: "cloudoff:ttl", Value: "1h"}
+
+	overridden := resource.Resource{ID: "overridden", Tags: []resource.Tag{uptime}}
+	resource.SetOverride(context.Background(), overridden, resource.Override{Action: ActionStart, State: resource.StateRunning, Until: monday22.Add(time.Hour), Created: monday22})
+
+	tests := []struct {
+		name     string
+		resource resource.Resource
+		expected time.Time
+	}{
+		{
+			name:     "next transition",
+			resource: resource.Resource{ID: "scheduled", Tags: []resource.Tag{uptime}},
+			expected: time.Date(2023, 10, 3, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "end of override",
+			resource: overridden,
+			expected: monday22.Add(time.Hour),
+		},
+		{
+			name:     "ttl expiration",
+			resource: resource.Resource{ID: "expiring", Tags: []resource.Tag{uptime, ttl}, TTLStart: monday22.Add(-30 * time.Minute)},
+			expected: monday22.Add(30 * time.Minute),
+		},
+		{
+			name:     "expired ttl",
+			resource: resource.Resource{ID: "expired", Tags: []resource.Tag{ttl}, TTLStart: monday22.Add(-2 * time.Hour)},
+		},
+		{
+			name:     "no cloudoff tag",
+			resource: resource.Resource{ID: "untagged"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next, ok := nextChange(context.Background(), test.resource, monday22)
+			if ok != !test.expected.IsZero() || !next.Equal(test.expected) {
+				t.Errorf("expected %v, got %v (%v)", test.expected, next, ok)
+			}
+		})
+	}
+}
+
+func TestLoop(t *testing.T) {
+	var leader atomic.Bool
+	leader.Store(true)
+	loop := NewLoop(time.Hour, leader.Load)
+
+	cycles := make(chan string, 10)
+	loop.reconcile = func(ctx context.Context, now time.Time) (time.Time, bool) {
+		cycles <- "reconcile"
+		return now.Add(1500 * time.Millisecond), true
+	}
+	loop.discover = func(ctx context.Context) {
+		cycles <- "discover"
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		loop.Run(ctx)
+		close(done)
+	}()
+
+	next := func(expected string, within time.Duration) time.Duration {
+		t.Helper()
+		start := time.Now()
+		select {
+		case cycle := <-cycles:
+			if cycle != expected {
+				t.Fatalf("expected %s cycle, got %s", expected, cycle)
+			}
+		case <-time.After(within):
+			t.Fatalf("expected %s cycle within %v", expected, within)
+		}
+		return time.Since(start)
+	}
+
+	next("reconcile", time.Second)
+	// The loop sleeps until the next change, before the resync interval
+	if elapsed := next("reconcile", 5*time.Second); elapsed < time.Second {
+		t.Errorf("expected the loop to sleep until the next change, woke after %v", elapsed)
+	}
+
+	// The followers only discover, and a cycle starts when the loop is woken up
+	leader.Store(false)
+	next("discover", 5*time.Second)
+	loop.Wake()
+	next("discover", time.Second)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the loop to stop when the context is cancelled")
+	}
+}
+
+func TestLoopStop(t *testing.T) {
+	loop := NewLoop(time.Hour, func() bool { return true })
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var cycles atomic.Int32
+	loop.reconcile = func(ctx context.Context, now time.Time) (time.Time, bool) {
+		cycles.Add(1)
+		close(started)
+		<-release
+		return time.Time{}, false
+	}
+	go loop.Run(context.Background())
+	<-started
+
+	// The cycle in progress completes before the loop stops
+	stopped := loop.Stop()
+	select {
+	case <-stopped.Done():
+		t.Fatalf("expected the loop to wait for the cycle in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-stopped.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the loop to stop after the cycle")
+	}
+	if cycles.Load() != 1 {
+		t.Errorf("expected a single cycle, got %d", cycles.Load())
+	}
+}
+
+func TestReconcileCancelled(t *testing.T) {
+	resource.Reset()
+	resource.ResetInventory()
+	audit.SetSink(audit.NewWriterSink(&bytes.Buffer{}))
+	defer resource.Reset()
+	defer resource.ResetInventory()
+	defer audit.ResetSink()
+
+	provider := &reconciledProvider{resources: []resource.Resource{
+		{ID: "scheduled", Tags: []resource.Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"}}, State: resource.StateRunning},
+	}}
+	resource.Register(provider)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := Reconcile(ctx, time.Date(2023, 10, 2, 22, 0, 0, 0, time.UTC)); ok {
+		t.Errorf("expected no next change for an interrupted cycle")
+	}
+	if len(provider.stopped) != 0 {
+		t.Errorf("expected the remaining resources skipped, got %v stopped", provider.stopped)
+	}
+}
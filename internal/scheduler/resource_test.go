@@ -0,0 +1,399 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/audit"
+	ec2 "github.com/bananaops/cloudoff/internal/aws"
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+type fakeProvider struct {
+	stopped []string
+	started []string
+}
+
+func (p *fakeProvider) Kind() string { return "fake" }
+
+func (p *fakeProvider) Discover(ctx context.Context) ([]resource.Resource, error) {
+	return nil, nil
+}
+
+func (p *fakeProvider) Stop(ctx context.Context, r resource.Resource) error {
+	p.stopped = append(p.stopped, r.ID)
+	return nil
+}
+
+func (p *fakeProvider) Start(ctx context.Context, r resource.Resource) error {
+	p.started = append(p.started, r.ID)
+	return nil
+}
+
+func TestScheduleResource(t *testing.T) {
+	monday10 := time.Date(2023, 10, 2, 10, 0, 0, 0, time.UTC)
+	monday22 := time.Date(2023, 10, 2, 22, 0, 0, 0, time.UTC)
+	uptime := []resource.Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"}}
+
+	tests := []struct {
+		name        string
+		resource    resource.Resource
+		currentTime time.Time
+		dryRun      bool
+		stopped     bool
+		started     bool
+	}{
+		{
+			name:        "Running resource in downtime is stopped",
+			resource:    resource.Resource{ID: "r", Tags: uptime, State: resource.StateRunning},
+			currentTime: monday22,
+			stopped:     true,
+		},
+		{
+			name:        "Running resource in uptime is kept",
+			resource:    resource.Resource{ID: "r", Tags: uptime, State: resource.StateRunning},
+			currentTime: monday10,
+		},
+		{
+			name:        "Stopped resource in uptime is started",
+			resource:    resource.Resource{ID: "r", Tags: uptime, State: resource.StateStopped},
+			currentTime: monday10,
+			started:     true,
+		},
+		{
+			name:        "Resource in unknown state is left untouched",
+			resource:    resource.Resource{ID: "r", Tags: uptime, State: resource.StateUnknown},
+			currentTime: monday22,
+		},
+		{
+			name:        "Stopped resource without schedule is kept",
+			resource:    resource.Resource{ID: "r", State: resource.StateStopped},
+			currentTime: monday10,
+		},
+		{
+			name:        "Resource stopped by cloudoff without schedule is started",
+			resource:    resource.Resource{ID: "r", State: resource.StateStopped, StoppedByCloudoff: true},
+			currentTime: monday10,
+			started:     true,
+		},
+		{
+			name: "Stopped resource with ttl exceeded is not started",
+			resource: resource.Resource{
+				ID:       "r",
+				Tags:     append([]resource.Tag{{Key: "cloudoff:ttl", Value: "1h"}}, uptime...),
+				State:    resource.StateStopped,
+				TTLStart: time.Now().Add(-2 * time.Hour),
+			},
+			currentTime: monday10,
+		},
+		{
+			name:        "Dry run",
+			resource:    resource.Resource{ID: "r", Tags: uptime, State: resource.StateRunning},
+			currentTime: monday22,
+			dryRun:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.dryRun {
+				t.Setenv("DRYRUN", "true")
+			}
+			provider := &fakeProvider{}
+			ScheduleResource(context.Background(), provider, tt.resource, tt.currentTime)
+			if (len(provider.stopped) == 1) != tt.stopped {
+				t.Errorf("stopped = %v, expected %v", provider.stopped, tt.stopped)
+			}
+			if (len(provider.started) == 1) != tt.started {
+				t.Errorf("started = %v, expected %v", provider.started, tt.started)
+			}
+		})
+	}
+}
+
+func TestScheduleResourceOverride(t *testing.T) {
+	resource.ResetStore()
+	defer resource.ResetStore()
+
+	monday22 := time.Date(2023, 10, 2, 22, 0, 0, 0, time.UTC)
+	r := resource.Resource{
+		ID:    "i-1",
+		Kind:  "fake",
+		State: resource.StateRunning,
+		Tags:  []resource.Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"}},
+	}
+
+	// A manual start keeps the resource running during downtime
+	resource.SetOverride(context.Background(), r, resource.Override{Action: ActionStart, State: resource.StateRunning, Until: monday22.Add(time.Hour)})
+	provider := &fakeProvider{}
+	ScheduleResource(context.Background(), provider, r, monday22)
+	if len(provider.stopped) != 0 {
+		t.Errorf("expected the override to prevent the stop, got %v", provider.stopped)
+	}
+
+	// The schedule applies again once the override expires
+	ScheduleResource(context.Background(), provider, r, monday22.Add(time.Hour))
+	if len(provider.stopped) != 1 {
+		t.Errorf("expected the resource to be stopped after the override, got %v", provider.stopped)
+	}
+}
+
+func TestScheduleResourceAudit(t *testing.T) {
+	resource.ResetStore()
+	defer resource.ResetStore()
+
+	var output bytes.Buffer
+	audit.SetSink(audit.NewWriterSink(&output))
+	defer audit.ResetSink()
+
+	// Monday 02/10/2023 22:00 UTC
+	monday22 := time.Date(2023, 10, 2, 22, 0, 0, 0, time.UTC)
+	tags := []resource.Tag{
+		{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"},
+		{Key: "cloudoff:downtime", Value: "Sat-Sun 00:00-23:59,Mon-Fri 21:00-23:59"},
+	}
+	r := resource.Resource{ID: "i-web", Kind: "fake", Region: "eu-west-1", Tags: tags, State: resource.StateRunning}
+
+	t.Setenv("DRYRUN", "true")
+	provider := &fakeProvider{}
+	ScheduleResource(context.Background(), provider, r, monday22)
+	if len(provider.stopped) != 0 {
+		t.Fatalf("expected no action in dry run")
+	}
+
+	var event audit.Event
+	if err := json.Unmarshal(output.Bytes(), &event); err != nil {
+		t.Fatalf("invalid audit event %q: %v", output.String(), err)
+	}
+	if event.Actor != audit.ActorScheduler || event.Action != "stop" || event.Reason != audit.ReasonDowntime || !event.DryRun || event.Result != audit.ResultDryRun {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if event.Tag != "cloudoff:downtime=Sat-Sun 00:00-23:59,Mon-Fri 21:00-23:59" || event.Schedule != "Mon-Fri 21:00-23:59" {
+		t.Errorf("unexpected trigger %q %q", event.Tag, event.Schedule)
+	}
+}
+
+func TestMatchingSchedule(t *testing.T) {
+	tags := []resource.Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-12:00,Mon-Fri 14:00-18:00"}}
+
+	tests := []struct {
+		name        string
+		currentTime time.Time
+		expected    string
+	}{
+		{"Second entry", time.Date(2023, 10, 2, 15, 0, 0, 0, time.UTC), "Mon-Fri 14:00-18:00"},
+		{"Outside of uptime", time.Date(2023, 10, 2, 13, 0, 0, 0, time.UTC), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tag, schedule, ok := matchingSchedule(tags, tt.currentTime)
+			if !ok || tag.Key != "cloudoff:uptime" || schedule != tt.expected {
+				t.Errorf("expected %q, got %q %q %v", tt.expected, tag.Key, schedule, ok)
+			}
+		})
+	}
+
+	if _, _, ok := matchingSchedule([]resource.Tag{{Key: "cloudoff:ttl", Value: "1d"}}, time.Now()); ok {
+		t.Errorf("expected no schedule")
+	}
+}
+
+func TestScheduleResourceFailedStart(t *testing.T) {
+	resource.ResetStore()
+	audit.SetSink(audit.NewWriterSink(&bytes.Buffer{}))
+	defer resource.ResetStore()
+	defer audit.ResetSink()
+
+	monday10 := time.Date(2023, 10, 2, 10, 0, 0, 0, time.UTC)
+	r := resource.Resource{
+		ID:    "i-1",
+		Kind:  "fake",
+		State: resource.StateStopped,
+		Tags:  []resource.Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"}},
+	}
+	resource.FailTransition(context.Background(), r, resource.CapabilityStart, errors.New("insufficient capacity"), monday10)
+	provider := &fakeProvider{}
+
+	// The start is retried after its delay only
+	ScheduleResource(context.Background(), provider, r, monday10.Add(time.Minute))
+	if len(provider.started) != 0 {
+		t.Errorf("expected no start before the retry delay, got %v", provider.started)
+	}
+	ScheduleResource(context.Background(), provider, r, monday10.Add(5*time.Minute))
+	if len(provider.started) != 1 {
+		t.Errorf("expected the start retried, got %v", provider.started)
+	}
+
+	// The failed start is forgotten once the resource is expected stopped
+	ScheduleResource(context.Background(), provider, r, monday10.Add(12*time.Hour))
+	if _, ok := resource.PendingTransition(context.Background(), r); ok {
+		t.Errorf("expected the failed start forgotten during downtime")
+	}
+}
+
+func TestApplyManualActionUnsupportedSpot(t *testing.T) {
+	resource.ResetStore()
+	audit.SetSink(audit.NewWriterSink(&bytes.Buffer{}))
+	defer resource.ResetStore()
+	defer audit.ResetSink()
+
+	instance := ec2.Instance{ID: "i-1", Region: "eu-west-3", Spot: true, SpotRequestType: "one-time"}
+	_, reason := instance.SpotMode()
+	r := resource.Resource{ID: "i-1", Kind: ec2.KindInstance, Region: "eu-west-3", State: resource.StateUnknown, Object: instance}
+
+	for _, action := range []string{ActionStop, ActionStart} {
+		t.Run(action, func(t *testing.T) {
+			override, err := ApplyManualAction(context.Background(), ec2.InstanceProvider{}, r, action, 1, time.Now())
+			if err == nil || !strings.Contains(err.Error(), reason) {
+				t.Fatalf("expected an error with the reason %q, got %v", reason, err)
+			}
+			if override != nil {
+				t.Errorf("expected no override, got %+v", override)
+			}
+			if _, ok := resource.ActiveOverride(context.Background(), r, time.Now()); ok {
+				t.Errorf("expected no override stored")
+			}
+		})
+	}
+}
+
+// overrideProvider reports whether the override of a resource is recorded while it is
+// stopped, and fails the stops with err.
+type overrideProvider struct {
+	fakeProvider
+	now        time.Time
+	overridden bool
+	err        error
+}
+
+func (p *overrideProvider) Stop(ctx context.Context, r resource.Resource) error {
+	_, p.overridden = resource.ActiveOverride(ctx, r, p.now)
+	return p.err
+}
+
+func TestApplyManualActionOverrideFirst(t *testing.T) {
+	audit.SetSink(audit.NewWriterSink(&bytes.Buffer{}))
+	defer audit.ResetSink()
+
+	monday10 := time.Date(2023, 10, 2, 10, 0, 0, 0, time.UTC)
+	r := resource.Resource{
+		ID:    "i-1",
+		Kind:  "fake",
+		State: resource.StateRunning,
+		Tags:  []resource.Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"}},
+	}
+	snooze := resource.Override{Action: ActionSnooze, State: resource.StateRunning, Until: monday10.Add(time.Hour), Created: monday10}
+
+	tests := []struct {
+		name     string
+		previous *resource.Override
+		err      error
+		expected *resource.Override
+	}{
+		{name: "Stopped", expected: &resource.Override{Action: ActionStop, State: resource.StateStopped, Until: time.Date(2023, 10, 2, 20, 1, 0, 0, time.UTC), Created: monday10}},
+		{name: "Failed", err: errors.New("stop refused")},
+		{name: "Failed over a snooze", previous: &snooze, err: errors.New("stop refused"), expected: &snooze},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resource.ResetStore()
+			defer resource.ResetStore()
+			if tt.previous != nil {
+				resource.SetOverride(context.Background(), r, *tt.previous)
+			}
+
+			// A reconcile cycle running during the stop sees the override
+			provider := &overrideProvider{now: monday10, err: tt.err}
+			_, err := ApplyManualAction(context.Background(), provider, r, ActionStop, 0, monday10)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if !provider.overridden {
+				t.Errorf("expected the override recorded before the stop")
+			}
+
+			override, ok := resource.ActiveOverride(context.Background(), r, monday10)
+			if ok != (tt.expected != nil) || (ok && override != *tt.expected) {
+				t.Errorf("expected the override %+v, got %+v", tt.expected, override)
+			}
+		})
+	}
+}
+
+func TestScheduleResourceRestartedDB(t *testing.T) {
+	audit.SetSink(audit.NewWriterSink(&bytes.Buffer{}))
+	defer audit.ResetSink()
+
+	monday10 := time.Date(2023, 10, 2, 10, 0, 0, 0, time.UTC)
+	monday22 := time.Date(2023, 10, 2, 22, 0, 0, 0, time.UTC)
+	uptime := []resource.Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"}}
+
+	// RDS starts a DB stopped for 7 days: it is discovered available, so running
+	tests := []struct {
+		name        string
+		state       resource.State
+		currentTime time.Time
+		stopped     bool
+	}{
+		{"Restarted during downtime is stopped again", resource.StateRunning, monday22, true},
+		{"Restarted during uptime is kept", resource.StateRunning, monday10, false},
+		{"Still starting is left to RDS", resource.StateUnknown, monday22, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resource.ResetStore()
+			defer resource.ResetStore()
+
+			r := resource.Resource{ID: "db-1", Kind: ec2.KindDBInstance, State: tt.state, Tags: uptime}
+			resource.RecordAction(context.Background(), r, resource.CapabilityStop, nil)
+
+			provider := &fakeProvider{}
+			ScheduleResource(context.Background(), provider, r, tt.currentTime)
+			if (len(provider.stopped) == 1) != tt.stopped || len(provider.started) != 0 {
+				t.Errorf("unexpected actions: stopped %v, started %v", provider.stopped, provider.started)
+			}
+		})
+	}
+}
+
+func TestScheduleResourceLostTransition(t *testing.T) {
+	resource.ResetStore()
+	audit.SetSink(audit.NewWriterSink(&bytes.Buffer{}))
+	defer resource.ResetStore()
+	defer audit.ResetSink()
+
+	monday10 := time.Date(2023, 10, 2, 10, 0, 0, 0, time.UTC)
+	r := resource.Resource{
+		ID:    "i-1",
+		Kind:  "fake",
+		State: resource.StateStopped,
+		Tags:  []resource.Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"}},
+	}
+	// The start was accepted, then its waiter was lost with a restart
+	resource.BeginTransition(context.Background(), r, resource.CapabilityStart, monday10)
+	provider := &fakeProvider{}
+
+	ScheduleResource(context.Background(), provider, r, monday10.Add(resource.TransitionTimeout))
+	if len(provider.started) != 0 {
+		t.Errorf("expected no start while the transition is in progress, got %v", provider.started)
+	}
+
+	// Past its timeout, the start is failed and retried after its delay
+	expired := monday10.Add(resource.TransitionTimeout + time.Minute)
+	ScheduleResource(context.Background(), provider, r, expired)
+	if transition, _ := resource.PendingTransition(context.Background(), r); transition.Attempts != 1 || transition.InProgress() {
+		t.Errorf("expected the start failed, got %+v", transition)
+	}
+	ScheduleResource(context.Background(), provider, r, expired.Add(5*time.Minute))
+	if len(provider.started) != 1 {
+		t.Errorf("expected the start retried, got %v", provider.started)
+	}
+}
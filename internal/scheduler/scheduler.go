@@ -1,194 +1,259 @@
 package scheduler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/bananaops/cloudoff/internal/audit"
 	ec2 "github.com/bananaops/cloudoff/internal/aws"
 	"github.com/bananaops/cloudoff/internal/clean"
+	"github.com/bananaops/cloudoff/internal/health"
+	"github.com/bananaops/cloudoff/internal/resource"
 )
 
 var logger *slog.Logger
 
 // Structure for scheduling information
 type Schedule struct {
-	Days     []string
-	Start    string
-	End      string
-	Timezone string
+	Days     []string `json:"days"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Timezone string   `json:"timezone"`
 }
 
-func ScheduleEC2Instance() {
-
-	ec2List := ec2.DiscoverEC2Instances()
-
-	for _, instance := range ec2List {
-		if instance.State == "running" {
-			DownscaleSchedule(instance)
+// discoverResources discovers the resources of every registered provider, updates the
+// inventory and the count of stuck resources, and records the result of the cycle.
+func discoverResources(ctx context.Context) map[resource.Provider][]resource.Resource {
+	discovered := map[resource.Provider][]resource.Resource{}
+
+	var errs []error
+	for _, provider := range resource.Providers() {
+		resources, err := resource.Discover(ctx, provider)
+		if err != nil {
+			logger.Error("error discovering resources", "kind", provider.Kind(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %v", provider.Kind(), err))
+			continue
 		}
-
-		if instance.State == "stopped" {
-			UpscaleSchedule(instance)
-		}
-
+		resource.UpdateInventory(provider, resources)
+		discovered[provider] = resources
 	}
-}
+	resource.UpdateStuckResources(ctx)
 
-func DownscaleSchedule(instance ec2.Instance) {
+	health.RecordCycle(errors.Join(errs...))
+	return discovered
+}
 
-	for _, tag := range instance.Tags {
-		if tag.Key == "cloudoff:downtime" {
-			schedules, err := ParseSchedule(tag.Value)
-			if err != nil {
-				logger.Error("error parsing schedule for instance", "instance", instance.ID, "error", err)
-				continue
-			}
+// ScheduleResource applies the schedule of a resource at the given time. Running
+// resources are stopped during downtime and stopped resources are started otherwise.
+func ScheduleResource(ctx context.Context, provider resource.Provider, r resource.Resource, currentTime time.Time) {
 
-			// Current time
-			currentTime := time.Now()
+	// A manual action overrides the schedule until it expires
+	override, overridden := resource.ActiveOverride(ctx, r, currentTime)
 
-			for _, schedule := range schedules {
+	// Resources stopped by cloudoff are started again when their schedule is removed
+	if !overridden && !hasSchedule(r.Tags) && !r.StoppedByCloudoff {
+		return
+	}
 
-				// Checker if the current time and day are in the schedule
-				isInSchedule, err := IsTimeInSchedule(currentTime, schedule)
-				if err != nil {
-					fmt.Println("Erreur :", err)
-				}
-				if isInSchedule {
-					if os.Getenv("DRYRUN") != "true" {
-						err := ec2.StopInstance(instance.ID, instance.Region)
-						if err != nil {
-							logger.Error("error stopping instance", "instance", instance.ID, "error", err)
-						} else {
-							logger.Info("instance stopped successfully", "instance", instance.ID)
-						}
-					}
-				}
-			}
+	downtime := override.State == resource.StateStopped
+	if !overridden {
+		var err error
+		downtime, err = IsDowntime(r.Tags, currentTime)
+		if err != nil {
+			logger.Error("error checking schedule", "kind", r.Kind, "resource", r.ID, "error", err)
+			return
 		}
+	}
 
-		if tag.Key == "cloudoff:uptime" {
-			schedules, err := ParseSchedule(tag.Value)
-			if err != nil {
-				fmt.Printf("Error parsing schedule for instance %s: %v\n", instance.ID, err)
-				continue
+	// A transition is forgotten once its state is reached, and a failed start once the
+	// resource is expected stopped. A start is not attempted again while one is in
+	// progress or before the retry delay of the failed one. A transition still in
+	// progress after its timeout lost its waiter, with a restart or a change of leader,
+	// and is failed here.
+	if transition, ok := resource.PendingTransition(ctx, r); ok {
+		settled := (transition.Action == resource.CapabilityStop && r.State == resource.StateStopped) ||
+			(transition.Action == resource.CapabilityStart && (r.State == resource.StateRunning || downtime))
+		if settled {
+			resource.CompleteTransition(ctx, r)
+		} else {
+			if transition.Expired(currentTime) {
+				err := fmt.Errorf("%s of %s not completed after %v", transition.Action, r.ID, resource.TransitionTimeout)
+				resource.AbortTransition(ctx, r, err, currentTime)
+				transition, _ = resource.PendingTransition(ctx, r)
+			}
+			if transition.Action == resource.CapabilityStart && !transition.RetryDue(currentTime) {
+				return
 			}
+		}
+	}
 
-			// Current time
-			currentTime := time.Now()
+	if downtime && r.State == resource.StateRunning {
+		stopper, ok := provider.(resource.Stopper)
+		if !ok {
+			return
+		}
+		event := scheduleEvent(r, resource.CapabilityStop, overridden, currentTime)
+		if os.Getenv("DRYRUN") == "true" {
+			event.DryRun = true
+			audit.Record(event, nil)
+			return
+		}
+		done := resource.StartAction(r, resource.CapabilityStop)
+		err := stopper.Stop(ctx, r)
+		done()
+		if err != nil {
+			logger.Error("error stopping resource", "kind", r.Kind, "resource", r.ID, "region", r.Region, "error", err)
+		}
+		resource.RecordAction(ctx, r, resource.CapabilityStop, err)
+		audit.Record(event, err)
+	}
 
-			var uptime = false
+	if !downtime && r.State == resource.StateStopped {
+		// Don't start a resource whose ttl is exceeded, it is about to be deleted
+		if expiration, ok := clean.Expiration(ctx, r); ok && time.Now().After(expiration) {
+			return
+		}
 
-			for _, schedule := range schedules {
+		starter, ok := provider.(resource.Starter)
+		if !ok {
+			return
+		}
+		event := scheduleEvent(r, resource.CapabilityStart, overridden, currentTime)
+		if os.Getenv("DRYRUN") == "true" {
+			event.DryRun = true
+			audit.Record(event, nil)
+			return
+		}
+		done := resource.StartAction(r, resource.CapabilityStart)
+		err := starter.Start(ctx, r)
+		done()
+		if err != nil {
+			logger.Error("error starting resource", "kind", r.Kind, "resource", r.ID, "region", r.Region, "error", err)
+		}
+		resource.RecordAction(ctx, r, resource.CapabilityStart, err)
+		audit.Record(event, err)
+	}
+}
 
-				// Check if the current time and day are in the schedule
-				isInSchedule, err := IsTimeInSchedule(currentTime, schedule)
+// scheduleEvent returns the audit event of an action of the scheduler.
+func scheduleEvent(r resource.Resource, action resource.Capability, overridden bool, currentTime time.Time) audit.Event {
+	switch {
+	case overridden:
+		return audit.NewEvent(audit.ActorScheduler, string(action), audit.ReasonOverride, r)
+	case !hasSchedule(r.Tags):
+		return audit.NewEvent(audit.ActorScheduler, string(action), audit.ReasonScheduleRemoved, r)
+	default:
+		return ScheduledEvent(r, string(action), action == resource.CapabilityStop, currentTime)
+	}
+}
 
-				if err != nil {
-					fmt.Println("Erreur :", err)
-				}
-				if isInSchedule {
-					uptime = true
-					break
+// ScheduledEvent returns the audit event of an action applying the schedule of a
+// resource, with the tag and the schedule entry which triggered it.
+func ScheduledEvent(r resource.Resource, action string, downtime bool, currentTime time.Time) audit.Event {
+	reason := audit.ReasonUptime
+	if downtime {
+		reason = audit.ReasonDowntime
+	}
 
-				}
+	event := audit.NewEvent(audit.ActorScheduler, action, reason, r)
+	if tag, schedule, ok := matchingSchedule(r.Tags, currentTime); ok {
+		event = event.WithTag(tag.Key, tag.Value)
+		event.Schedule = schedule
+	}
+	return event
+}
 
-			}
+// matchingSchedule returns the tag deciding the state of a resource at the given time, as
+// IsDowntime does, and the entry of its schedule matching this time. The entry is empty
+// when the resource is outside of its uptime.
+func matchingSchedule(tags []ec2.Tag, currentTime time.Time) (ec2.Tag, string, bool) {
+	var decisive ec2.Tag
+	var decisiveEntry string
+	found := false
+
+	for _, tag := range tags {
+		if tag.Key != "cloudoff:uptime" && tag.Key != "cloudoff:downtime" {
+			continue
+		}
 
-			if !uptime {
-				if os.Getenv("DRYRUN") != "true" {
-					err := ec2.StopInstance(instance.ID, instance.Region)
-					if err != nil {
-						logger.Error("error stopping instance", "instance", instance.ID, "error", err)
-					} else {
-						logger.Info("instance stopped successfully", "instance", instance.ID)
-					}
-				}
+		entry := ""
+		for _, candidate := range strings.Split(tag.Value, ",") {
+			if inSchedule, err := isInAnySchedule(candidate, currentTime); err == nil && inSchedule {
+				entry = strings.TrimSpace(candidate)
+				break
 			}
+		}
 
+		if tag.Key == "cloudoff:downtime" && entry != "" {
+			return tag, entry, true
+		}
+		if !found || tag.Key == "cloudoff:uptime" {
+			decisive, decisiveEntry, found = tag, entry, true
 		}
 	}
 
+	return decisive, decisiveEntry, found
 }
 
-func UpscaleSchedule(instance ec2.Instance) {
-
-	if !clean.DurationExceeded(instance) {
-
-		for _, tag := range instance.Tags {
-			if tag.Key == "cloudoff:uptime" {
-				schedules, err := ParseSchedule(tag.Value)
-				if err != nil {
-					logger.Error("error parsing schedule for instance", "instance", instance.ID, "error", err)
-					continue
-				}
-
-				// Current time
-				currentTime := time.Now()
-
-				for _, schedule := range schedules {
-
-					// Checker if the current time and day are in the schedule
-					isInSchedule, err := IsTimeInSchedule(currentTime, schedule)
-					if err != nil {
-						logger.Error("error checking schedule for instance", "instance", instance.ID, "error", err)
-					}
-					if isInSchedule {
-						if os.Getenv("DRYRUN") != "true" {
-							err := ec2.StartInstance(instance.ID, instance.Region)
-							if err != nil {
-								logger.Error("error starting instance", "instance", instance.ID, "error", err)
-							} else {
-								logger.Info("instance started successfully", "instance", instance.ID)
-							}
-						}
-					}
-				}
-			}
-
-			if tag.Key == "cloudoff:downtime" {
-				schedules, err := ParseSchedule(tag.Value)
-				if err != nil {
-					logger.Error("error parsing schedule for instance", "instance", instance.ID, "error", err)
-					continue
-				}
+// IsDowntime reports whether the cloudoff:uptime and cloudoff:downtime tags put
+// a resource in downtime at the given time. A matching downtime overrides uptime.
+func IsDowntime(tags []ec2.Tag, currentTime time.Time) (bool, error) {
+	var downtime = false
 
-				// Current time
-				currentTime := time.Now()
+	for _, tag := range tags {
+		if tag.Key != "cloudoff:uptime" && tag.Key != "cloudoff:downtime" {
+			continue
+		}
 
-				var uptime = false
+		inSchedule, err := isInAnySchedule(tag.Value, currentTime)
+		if err != nil {
+			return false, fmt.Errorf("error checking %s schedule: %v", tag.Key, err)
+		}
 
-				for _, schedule := range schedules {
+		if tag.Key == "cloudoff:downtime" && inSchedule {
+			return true, nil
+		}
+		if tag.Key == "cloudoff:uptime" && !inSchedule {
+			downtime = true
+		}
+	}
 
-					// Check if the current time and day are in the schedule
-					isInSchedule, err := IsTimeInSchedule(currentTime, schedule)
-					if err != nil {
-						logger.Error("error checking schedule for instance", "instance", instance.ID, "error", err)
-					}
-					if isInSchedule {
-						uptime = true
-						break
+	return downtime, nil
+}
 
-					}
+// hasSchedule reports whether the resource carries a cloudoff:uptime or cloudoff:downtime tag
+func hasSchedule(tags []ec2.Tag) bool {
+	for _, tag := range tags {
+		if tag.Key == "cloudoff:uptime" || tag.Key == "cloudoff:downtime" {
+			return true
+		}
+	}
+	return false
+}
 
-				}
+// isInAnySchedule checks if the current time is in one of the schedules of the input
+func isInAnySchedule(input string, currentTime time.Time) (bool, error) {
+	schedules, err := ParseSchedule(input)
+	if err != nil {
+		return false, err
+	}
 
-				if !uptime {
-					if os.Getenv("DRYRUN") != "true" {
-						err := ec2.StartInstance(instance.ID, instance.Region)
-						if err != nil {
-							logger.Error("error starting instance", "instance", instance.ID, "error", err)
-						} else {
-							logger.Info("instance started successfully", "instance", instance.ID)
-						}
-					}
-				}
-			}
+	for _, schedule := range schedules {
+		isInSchedule, err := IsTimeInSchedule(currentTime, schedule)
+		if err != nil {
+			return false, err
+		}
+		if isInSchedule {
+			return true, nil
 		}
 	}
+
+	return false, nil
 }
 
 // splitSchedule parses a schedule string into days, time range, and timezone.
@@ -4,6 +4,8 @@ import (
 	"reflect"
 	"testing"
 	"time"
+
+	ec2 "github.com/bananaops/cloudoff/internal/aws"
 )
 
 func TestParseSchedule(t *testing.T) {
@@ -328,3 +330,164 @@ func equalSlices(a, b []string) bool {
     }
     return true
 }
+
+func TestIsDowntime(t *testing.T) {
+	monday10 := time.Date(2023, 10, 2, 10, 0, 0, 0, time.UTC)
+	monday22 := time.Date(2023, 10, 2, 22, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		tags        []ec2.Tag
+		currentTime time.Time
+		expected    bool
+		wantErr     bool
+	}{
+		{
+			name:        "No schedule tags",
+			tags:        []ec2.Tag{{Key: "Name", Value: "web"}},
+			currentTime: monday10,
+			expected:    false,
+		},
+		{
+			name:        "Inside uptime",
+			tags:        []ec2.Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"}},
+			currentTime: monday10,
+			expected:    false,
+		},
+		{
+			name:        "Outside uptime",
+			tags:        []ec2.Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"}},
+			currentTime: monday22,
+			expected:    true,
+		},
+		{
+			name:        "Inside downtime",
+			tags:        []ec2.Tag{{Key: "cloudoff:downtime", Value: "Mon-Fri 20:00-23:59"}},
+			currentTime: monday22,
+			expected:    true,
+		},
+		{
+			name: "Downtime overrides uptime",
+			tags: []ec2.Tag{
+				{Key: "cloudoff:uptime", Value: "Mon-Fri 08:00-20:00"},
+				{Key: "cloudoff:downtime", Value: "Mon 09:00-11:00"},
+			},
+			currentTime: monday10,
+			expected:    true,
+		},
+		{
+			name:        "Invalid schedule",
+			tags:        []ec2.Tag{{Key: "cloudoff:downtime", Value: "Mon-Funday 09:00-17:00"}},
+			currentTime: monday10,
+			expected:    false,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := IsDowntime(tt.tags, tt.currentTime)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("IsDowntime() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.expected {
+				t.Errorf("IsDowntime() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNatGatewayPlanChanges(t *testing.T) {
+	routes := []ec2.Route{
+		{RouteTableID: "rtb-1", DestinationCidrBlock: "0.0.0.0/0"},
+		{RouteTableID: "rtb-2", DestinationCidrBlock: "10.0.0.0/8"},
+	}
+
+	tests := []struct {
+		name     string
+		plan     NatGatewayPlan
+		expected []string
+	}{
+		{
+			name: "Delete",
+			plan: NatGatewayPlan{Action: "delete", NatGatewayID: "nat-1", SubnetID: "subnet-1", AllocationID: "eipalloc-1", Routes: routes},
+			expected: []string{
+				"delete nat gateway nat-1 (subnet subnet-1, address eipalloc-1)",
+				"route rtb-1 0.0.0.0/0: nat-1 -> blackhole",
+				"route rtb-2 10.0.0.0/8: nat-1 -> blackhole",
+			},
+		},
+		{
+			name: "Create",
+			plan: NatGatewayPlan{Action: "create", SubnetID: "subnet-1", AllocationID: "eipalloc-1", Routes: routes[:1]},
+			expected: []string{
+				"create nat gateway (subnet subnet-1, address eipalloc-1)",
+				"route rtb-1 0.0.0.0/0: blackhole -> new nat gateway",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.plan.Changes()
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Changes() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNextTransition(t *testing.T) {
+	// Monday 06/01/2025 19:00 UTC
+	now := time.Date(2025, 1, 6, 19, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		tags     []ec2.Tag
+		expected time.Time
+		found    bool
+	}{
+		{
+			name:     "Downtime starts",
+			tags:     []ec2.Tag{{Key: "cloudoff:downtime", Value: "Mon-Fri_20:00-23:59"}},
+			expected: time.Date(2025, 1, 6, 20, 0, 0, 0, time.UTC),
+			found:    true,
+		},
+		{
+			name:     "Uptime ends after its last minute",
+			tags:     []ec2.Tag{{Key: "cloudoff:uptime", Value: "Mon-Fri_08:00-19:30"}},
+			expected: time.Date(2025, 1, 6, 19, 31, 0, 0, time.UTC),
+			found:    true,
+		},
+		{
+			name:     "Uptime starts after the weekend",
+			tags:     []ec2.Tag{{Key: "cloudoff:uptime", Value: "Sat-Sun_08:00-20:00"}},
+			expected: time.Date(2025, 1, 11, 8, 0, 0, 0, time.UTC),
+			found:    true,
+		},
+		{
+			name:     "Timezone",
+			tags:     []ec2.Tag{{Key: "cloudoff:downtime", Value: "Mon-Fri_22:00-23:59_Europe/Paris"}},
+			expected: time.Date(2025, 1, 6, 21, 0, 0, 0, time.UTC),
+			found:    true,
+		},
+		{
+			name:  "Always up",
+			tags:  []ec2.Tag{{Key: "cloudoff:uptime", Value: "infinity"}},
+			found: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, found, err := NextTransition(tt.tags, now)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if found != tt.found || (found && !next.Equal(tt.expected)) {
+				t.Errorf("expected %v (%v), got %v (%v)", tt.expected, tt.found, next, found)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	ec2 "github.com/bananaops/cloudoff/internal/aws"
+)
+
+// NextTransition returns the next time a resource switches between uptime and downtime.
+// Schedules repeat every week, so it returns false when no transition happens within
+// the next 8 days.
+func NextTransition(tags []ec2.Tag, currentTime time.Time) (time.Time, bool, error) {
+	currentTime = currentTime.Truncate(time.Minute)
+
+	current, err := IsDowntime(tags, currentTime)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	var candidates []time.Time
+	for _, tag := range tags {
+		if tag.Key != "cloudoff:uptime" && tag.Key != "cloudoff:downtime" {
+			continue
+		}
+
+		schedules, err := ParseSchedule(tag.Value)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("error parsing %s schedule: %v", tag.Key, err)
+		}
+		for _, schedule := range schedules {
+			boundaries, err := scheduleBoundaries(schedule, currentTime)
+			if err != nil {
+				return time.Time{}, false, err
+			}
+			candidates = append(candidates, boundaries...)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+
+	// Only the boundaries of the schedules can change the result of IsDowntime
+	for _, candidate := range candidates {
+		if !candidate.After(currentTime) {
+			continue
+		}
+		downtime, err := IsDowntime(tags, candidate)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if downtime != current {
+			return candidate, true, nil
+		}
+	}
+
+	return time.Time{}, false, nil
+}
+
+// scheduleBoundaries returns the times at which a schedule starts and stops matching
+// during the 8 days following the current time. The end minute is part of the schedule.
+func scheduleBoundaries(schedule Schedule, currentTime time.Time) ([]time.Time, error) {
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone : %v", err)
+	}
+	start, err := time.Parse("15:04", schedule.Start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start time : %v", err)
+	}
+	end, err := time.Parse("15:04", schedule.End)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end time: %v", err)
+	}
+
+	local := currentTime.In(location)
+
+	var boundaries []time.Time
+	for day := 0; day <= 8; day++ {
+		date := local.AddDate(0, 0, day)
+		boundaries = append(boundaries,
+			time.Date(date.Year(), date.Month(), date.Day(), start.Hour(), start.Minute(), 0, 0, location),
+			time.Date(date.Year(), date.Month(), date.Day(), end.Hour(), end.Minute(), 0, 0, location).Add(time.Minute),
+		)
+	}
+	return boundaries, nil
+}
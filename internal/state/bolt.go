@@ -0,0 +1,161 @@
+package state
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bananaops/cloudoff/internal/resource"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	recordsBucket = []byte("records")
+	historyBucket = []byte("history")
+)
+
+// BoltStore keeps the records in an embedded BoltDB file. The file can only be opened by
+// one process, which suits a single replica or the leader with a persistent volume.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens the file at the given path, created with its directory if needed.
+func NewBoltStore(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("error creating state directory of %s: %v", path, err)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening state file %s: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{recordsBucket, historyBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing state file %s: %v", path, err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Load(ctx context.Context, key string) (resource.Record, bool, error) {
+	var record resource.Record
+	var found bool
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(recordsBucket).Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, &record)
+	})
+	if err != nil {
+		return resource.Record{}, false, fmt.Errorf("error reading state of %s: %v", key, err)
+	}
+	return record, found, nil
+}
+
+func (s *BoltStore) Save(ctx context.Context, key string, record resource.Record) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordsBucket).Put([]byte(key), value)
+	})
+	if err != nil {
+		return fmt.Errorf("error writing state of %s: %v", key, err)
+	}
+	return nil
+}
+
+// AppendHistory adds an action to the bucket of the resource, keyed by a sequence so
+// the actions are sorted, and removes the oldest ones beyond resource.HistorySize.
+func (s *BoltStore) AppendHistory(ctx context.Context, key string, action resource.Action) error {
+	value, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(historyBucket).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+
+		sequence, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(sequenceKey(sequence), value); err != nil {
+			return err
+		}
+
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		for len(keys) > resource.HistorySize {
+			if err := bucket.Delete(keys[0]); err != nil {
+				return err
+			}
+			keys = keys[1:]
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error writing history of %s: %v", key, err)
+	}
+	return nil
+}
+
+func (s *BoltStore) History(ctx context.Context, key string, limit int) ([]resource.Action, error) {
+	var actions []resource.Action
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyBucket).Bucket([]byte(key))
+		if bucket == nil {
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+		for k, value := cursor.Last(); k != nil && (limit <= 0 || len(actions) < limit); k, value = cursor.Prev() {
+			var action resource.Action
+			if err := json.Unmarshal(value, &action); err != nil {
+				return err
+			}
+			actions = append(actions, action)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading history of %s: %v", key, err)
+	}
+	return actions, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func sequenceKey(sequence uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, sequence)
+	return key
+}
@@ -0,0 +1,177 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+const (
+	recordSortKey = "record"
+	historyPrefix = "history#"
+	// historyRetention is the retention of the history items, removed by the TTL of the
+	// table when it is enabled on the expiresAt attribute
+	historyRetention = 90 * 24 * time.Hour
+	// requestTimeout bounds each request, so a slow table doesn't hold the scheduler
+	requestTimeout = 10 * time.Second
+)
+
+// dynamoAPI is the part of the DynamoDB client used by the store.
+type dynamoAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+// DynamoDBStore keeps the records in a DynamoDB table shared by all the replicas. The
+// table has a "pk" partition key and a "sk" sort key, both strings.
+type DynamoDBStore struct {
+	client dynamoAPI
+	table  string
+}
+
+// NewDynamoDBStore returns a store using the given table. The endpoint is only set to
+// use a local stand-in such as DynamoDB Local.
+func NewDynamoDBStore(ctx context.Context, table, endpoint string) (*DynamoDBStore, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading AWS configuration: %v", err)
+	}
+
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+	return &DynamoDBStore{client: client, table: table}, nil
+}
+
+func (s *DynamoDBStore) Load(ctx context.Context, key string) (resource.Record, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            itemKey(key, recordSortKey),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return resource.Record{}, false, fmt.Errorf("error reading state of %s: %v", key, err)
+	}
+	if result.Item == nil {
+		return resource.Record{}, false, nil
+	}
+
+	var record resource.Record
+	if err := unmarshalData(result.Item, &record); err != nil {
+		return resource.Record{}, false, fmt.Errorf("error reading state of %s: %v", key, err)
+	}
+	return record, true, nil
+}
+
+func (s *DynamoDBStore) Save(ctx context.Context, key string, record resource.Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	item := itemKey(key, recordSortKey)
+	item["data"] = &types.AttributeValueMemberS{Value: string(data)}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("error writing state of %s: %v", key, err)
+	}
+	return nil
+}
+
+// AppendHistory adds an item sorted by the time of the action. Old items are removed by
+// the TTL of the table rather than on each write.
+func (s *DynamoDBStore) AppendHistory(ctx context.Context, key string, action resource.Action) error {
+	data, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+
+	item := itemKey(key, fmt.Sprintf("%s%020d", historyPrefix, action.Time.UnixNano()))
+	item["data"] = &types.AttributeValueMemberS{Value: string(data)}
+	item["expiresAt"] = &types.AttributeValueMemberN{Value: fmt.Sprint(action.Time.Add(historyRetention).Unix())}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("error writing history of %s: %v", key, err)
+	}
+	return nil
+}
+
+func (s *DynamoDBStore) History(ctx context.Context, key string, limit int) ([]resource.Action, error) {
+	if limit <= 0 || limit > resource.HistorySize {
+		limit = resource.HistorySize
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	result, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.table),
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":     &types.AttributeValueMemberS{Value: key},
+			":prefix": &types.AttributeValueMemberS{Value: historyPrefix},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(limit)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading history of %s: %v", key, err)
+	}
+
+	var actions []resource.Action
+	for _, item := range result.Items {
+		var action resource.Action
+		if err := unmarshalData(item, &action); err != nil {
+			return nil, fmt.Errorf("error reading history of %s: %v", key, err)
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+func (s *DynamoDBStore) Close() error {
+	return nil
+}
+
+func itemKey(pk, sk string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: pk},
+		"sk": &types.AttributeValueMemberS{Value: sk},
+	}
+}
+
+// unmarshalData decodes the JSON stored in the data attribute of an item.
+func unmarshalData(item map[string]types.AttributeValue, out any) error {
+	data, ok := item["data"].(*types.AttributeValueMemberS)
+	if !ok {
+		return fmt.Errorf("missing data attribute")
+	}
+	return json.Unmarshal([]byte(data.Value), out)
+}
@@ -0,0 +1,23 @@
+package state
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+// NewStore returns the store of the given type: "memory", "bolt" (the location is the
+// path of the file) or "dynamodb" (the location is the name of the table).
+func NewStore(ctx context.Context, storeType, location, endpoint string) (resource.Store, error) {
+	switch storeType {
+	case "memory":
+		return resource.NewMemoryStore(), nil
+	case "bolt":
+		return NewBoltStore(location)
+	case "dynamodb":
+		return NewDynamoDBStore(ctx, location, endpoint)
+	default:
+		return nil, fmt.Errorf("unknown state store : %s", storeType)
+	}
+}
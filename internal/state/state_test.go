@@ -0,0 +1,196 @@
+package state
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/bananaops/cloudoff/internal/resource"
+)
+
+// fakeDynamoDB is an in-memory stand-in of a DynamoDB table with a pk/sk key.
+type fakeDynamoDB struct {
+	mu    sync.Mutex
+	items map[string]map[string]map[string]types.AttributeValue
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{items: map[string]map[string]map[string]types.AttributeValue{}}
+}
+
+func stringValue(value types.AttributeValue) string {
+	return value.(*types.AttributeValueMemberS).Value
+}
+
+func (f *fakeDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item := f.items[stringValue(params.Key["pk"])][stringValue(params.Key["sk"])]
+	return &dynamodb.GetItemOutput{Item: item}, nil
+}
+
+func (f *fakeDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pk := stringValue(params.Item["pk"])
+	if f.items[pk] == nil {
+		f.items[pk] = map[string]map[string]types.AttributeValue{}
+	}
+	f.items[pk][stringValue(params.Item["sk"])] = params.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// Query only supports the "pk = :pk AND begins_with(sk, :prefix)" condition used by the store.
+func (f *fakeDynamoDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pk := stringValue(params.ExpressionAttributeValues[":pk"])
+	prefix := stringValue(params.ExpressionAttributeValues[":prefix"])
+
+	var keys []string
+	for sk := range f.items[pk] {
+		if strings.HasPrefix(sk, prefix) {
+			keys = append(keys, sk)
+		}
+	}
+	sort.Strings(keys)
+	if params.ScanIndexForward != nil && !*params.ScanIndexForward {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	}
+	if params.Limit != nil && int(*params.Limit) < len(keys) {
+		keys = keys[:*params.Limit]
+	}
+
+	output := &dynamodb.QueryOutput{}
+	for _, sk := range keys {
+		output.Items = append(output.Items, f.items[pk][sk])
+	}
+	return output, nil
+}
+
+func TestStores(t *testing.T) {
+	bolt, err := NewBoltStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer bolt.Close()
+
+	stores := map[string]resource.Store{
+		"memory":   resource.NewMemoryStore(),
+		"bolt":     bolt,
+		"dynamodb": &DynamoDBStore{client: newFakeDynamoDB(), table: "cloudoff"},
+	}
+
+	ctx := context.Background()
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if _, found, err := store.Load(ctx, "asg/web"); err != nil || found {
+				t.Fatalf("expected no record, got found=%v err=%v", found, err)
+			}
+
+			until := time.Date(2025, 1, 6, 18, 0, 0, 0, time.UTC)
+			record := resource.Record{
+				OriginalCapacity: "min=1 max=3 desired=2",
+				Override:         &resource.Override{Action: "stop", State: resource.StateStopped, Until: until},
+			}
+			if err := store.Save(ctx, "asg/web", record); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			loaded, found, err := store.Load(ctx, "asg/web")
+			if err != nil || !found {
+				t.Fatalf("expected a record, got found=%v err=%v", found, err)
+			}
+			if loaded.OriginalCapacity != record.OriginalCapacity || loaded.Override == nil || !loaded.Override.Until.Equal(until) {
+				t.Errorf("unexpected record %+v", loaded)
+			}
+
+			start := time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC)
+			for i := range resource.HistorySize + 5 {
+				action := resource.Action{Action: resource.CapabilityStart, Time: start.Add(time.Duration(i) * time.Minute)}
+				if err := store.AppendHistory(ctx, "asg/web", action); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			history, err := store.History(ctx, "asg/web", 3)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(history) != 3 || !history[0].Time.Equal(start.Add(time.Duration(resource.HistorySize+4)*time.Minute)) {
+				t.Errorf("expected the 3 most recent actions first, got %+v", history)
+			}
+
+			history, err = store.History(ctx, "asg/web", 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(history) != resource.HistorySize {
+				t.Errorf("expected %d actions, got %d", resource.HistorySize, len(history))
+			}
+
+			if history, _ := store.History(ctx, "asg/other", 0); len(history) != 0 {
+				t.Errorf("expected no history, got %+v", history)
+			}
+		})
+	}
+}
+
+func TestBoltStoreReopen(t *testing.T) {
+	// The directory is created on first use
+	path := filepath.Join(t.TempDir(), "cloudoff", "state.db")
+
+	ctx := context.Background()
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Save(ctx, "ecs/web", resource.Record{OriginalCapacity: "min=0 max=0 desired=2"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store.Close()
+
+	store, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer store.Close()
+	record, found, err := store.Load(ctx, "ecs/web")
+	if err != nil || !found || record.OriginalCapacity != "min=0 max=0 desired=2" {
+		t.Errorf("expected the record to survive a restart, got %+v found=%v err=%v", record, found, err)
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	if _, err := NewStore(context.Background(), "memory", "", ""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := NewStore(context.Background(), "unknown", "", ""); err == nil {
+		t.Errorf("expected an error for an unknown store")
+	}
+}
+
+// blockingDynamoDB never answers, the requests end with their context.
+type blockingDynamoDB struct {
+	fakeDynamoDB
+}
+
+func (f *blockingDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestDynamoDBStoreCancel(t *testing.T) {
+	store := &DynamoDBStore{client: &blockingDynamoDB{}, table: "cloudoff"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := store.Load(ctx, "asg/web"); err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Errorf("expected the request to be cancelled, got %v", err)
+	}
+}